@@ -0,0 +1,121 @@
+package baseorbitdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-orbit-db/iface"
+	cid "github.com/ipfs/go-cid"
+	p2pcore "github.com/libp2p/go-libp2p-core"
+	"go.uber.org/zap"
+)
+
+// headsDigest Returns a digest identifying a set of heads
+func headsDigest(hashes []cid.Cid) string {
+	keys := make([]string, len(hashes))
+	for i, h := range hashes {
+		keys[i] = h.KeyString()
+	}
+
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		_, _ = h.Write([]byte(k))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// entriesHashes Returns the hashes of the given entries
+func entriesHashes(entries []ipfslog.Entry) []cid.Cid {
+	hashes := make([]cid.Cid, len(entries))
+	for i, e := range entries {
+		hashes[i] = e.GetHash()
+	}
+
+	return hashes
+}
+
+// setKnownHeads Records the heads a peer is known to have for a store,
+// either because it sent them or because it acknowledged them
+func (o *orbitDB) setKnownHeads(address string, p p2pcore.PeerID, hashes []cid.Cid) {
+	o.muKnownHeads.Lock()
+	defer o.muKnownHeads.Unlock()
+
+	if _, ok := o.knownHeads[address]; !ok {
+		o.knownHeads[address] = map[p2pcore.PeerID]string{}
+	}
+
+	o.knownHeads[address][p] = headsDigest(hashes)
+}
+
+// headsMatch Returns whether a peer is known to have the given heads digest
+func (o *orbitDB) headsMatch(address string, p p2pcore.PeerID, digest string) bool {
+	o.muKnownHeads.RLock()
+	defer o.muKnownHeads.RUnlock()
+
+	known, ok := o.knownHeads[address][p]
+
+	return ok && known == digest
+}
+
+// forgetKnownHeads Drops the heads known for the peers of a store
+func (o *orbitDB) forgetKnownHeads(address string) {
+	o.muKnownHeads.Lock()
+	defer o.muKnownHeads.Unlock()
+
+	delete(o.knownHeads, address)
+}
+
+// antiEntropy Periodically exchanges the heads of a store with the peers of
+// its topic, so peers which missed an announcement converge without waiting
+// for a new write. It stops once the context is done, when the store is
+// suspended or closed
+func (o *orbitDB) antiEntropy(ctx context.Context, store Store, topic iface.PubSubTopic) {
+	go func() {
+		ticker := time.NewTicker(o.antiEntropyInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				o.forgetKnownHeads(store.Address().String())
+				return
+
+			case <-ticker.C:
+			}
+
+			o.antiEntropyRound(ctx, store, topic)
+		}
+	}()
+}
+
+// antiEntropyRound Sends the heads of a store to the peers of its topic,
+// except the peers known to have the same heads and the ones refused by the
+// guard of the store
+func (o *orbitDB) antiEntropyRound(ctx context.Context, store Store, topic iface.PubSubTopic) {
+	peers, err := topic.Peers(ctx)
+	if err != nil {
+		o.logger.Debug("unable to list topic peers", zap.Error(err))
+		return
+	}
+
+	addr := store.Address().String()
+	guard := o.getHeadsGuard(addr)
+	digest := headsDigest(entriesHashes(store.OpLog().Heads().Slice()))
+
+	for _, p := range peers {
+		if p == o.PeerID() || !guard.allowPeer(p) || o.headsMatch(addr, p, digest) {
+			continue
+		}
+
+		if _, err := o.exchangeHeads(ctx, p, store); err != nil {
+			o.logger.Debug("unable to exchange heads", zap.String("peer", p.String()), zap.Error(err))
+		}
+	}
+}
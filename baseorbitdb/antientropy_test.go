@@ -0,0 +1,167 @@
+package baseorbitdb
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"berty.tech/go-orbit-db/events"
+	"berty.tech/go-orbit-db/iface"
+	cid "github.com/ipfs/go-cid"
+	p2pcore "github.com/libp2p/go-libp2p-core"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// testingTopic A topic whose peers are set by the test
+type testingTopic struct {
+	name  string
+	peers []p2pcore.PeerID
+}
+
+func (t *testingTopic) Publish(context.Context, []byte) error {
+	return nil
+}
+
+func (t *testingTopic) Peers(context.Context) ([]p2pcore.PeerID, error) {
+	return t.peers, nil
+}
+
+func (t *testingTopic) WatchPeers(context.Context) (<-chan events.Event, error) {
+	return make(chan events.Event), nil
+}
+
+func (t *testingTopic) WatchMessages(context.Context) (<-chan *iface.EventPubSubMessage, error) {
+	return make(chan *iface.EventPubSubMessage), nil
+}
+
+func (t *testingTopic) Topic() string {
+	return t.name
+}
+
+var _ iface.PubSubTopic = &testingTopic{}
+
+// testingChannels Records the direct channels created for each peer
+type testingChannels struct {
+	muChannels sync.Mutex
+	channels   map[p2pcore.PeerID]*testingDirectChannel
+}
+
+func (c *testingChannels) factory(_ context.Context, receiver p2pcore.PeerID, _ *iface.DirectChannelOptions) (iface.DirectChannel, error) {
+	c.muChannels.Lock()
+	defer c.muChannels.Unlock()
+
+	channel := &testingDirectChannel{}
+	c.channels[receiver] = channel
+
+	return channel, nil
+}
+
+// heads Returns the heads sent to a peer for a store
+func (c *testingChannels) heads(t *testing.T, p p2pcore.PeerID, address string) [][]cid.Cid {
+	t.Helper()
+
+	c.muChannels.Lock()
+	channel, ok := c.channels[p]
+	c.muChannels.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	var heads [][]cid.Cid
+	for _, msg := range channel.messages(t) {
+		if msg.Type != messageTypeHeads || msg.Address != address {
+			continue
+		}
+
+		hashes := make([]cid.Cid, len(msg.Heads))
+		for i, h := range msg.Heads {
+			hashes[i] = h.GetHash()
+		}
+
+		heads = append(heads, hashes)
+	}
+
+	return heads
+}
+
+func TestHeadsDigest(t *testing.T) {
+	a, b, c := testingCID(t, "a"), testingCID(t, "b"), testingCID(t, "c")
+
+	require.Equal(t, headsDigest([]cid.Cid{a, b}), headsDigest([]cid.Cid{b, a}))
+	require.NotEqual(t, headsDigest([]cid.Cid{a, b}), headsDigest([]cid.Cid{a, c}))
+	require.NotEqual(t, headsDigest([]cid.Cid{a}), headsDigest([]cid.Cid{a, b}))
+	require.NotEqual(t, headsDigest(nil), headsDigest([]cid.Cid{a}))
+
+	o := &orbitDB{knownHeads: map[string]map[p2pcore.PeerID]string{}}
+	alice, bob := p2pcore.PeerID("alice"), p2pcore.PeerID("bob")
+
+	o.setKnownHeads("store", alice, []cid.Cid{b, a})
+	require.True(t, o.headsMatch("store", alice, headsDigest([]cid.Cid{a, b})))
+	require.False(t, o.headsMatch("store", alice, headsDigest([]cid.Cid{a, c})))
+	require.False(t, o.headsMatch("store", bob, headsDigest([]cid.Cid{a, b})))
+	require.False(t, o.headsMatch("other", alice, headsDigest([]cid.Cid{a, b})))
+
+	o.forgetKnownHeads("store")
+	require.False(t, o.headsMatch("store", alice, headsDigest([]cid.Cid{a, b})))
+}
+
+func TestAntiEntropy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	channels := &testingChannels{channels: map[p2pcore.PeerID]*testingDirectChannel{}}
+
+	o, clean := testingOrbitDB(ctx, t, mocknet.New(ctx), &NewOrbitDBOptions{
+		DirectChannelFactory: channels.factory,
+	})
+	defer clean()
+
+	alice, denied := p2pcore.PeerID("alice"), p2pcore.PeerID("denied")
+
+	store := testingEventLog(ctx, t, o, "anti-entropy", &CreateDBOptions{DeniedPeers: []p2pcore.PeerID{denied}})
+	defer store.Close()
+
+	addr := store.Address().String()
+
+	first, err := store.Add(ctx, []byte("hello0"))
+	require.NoError(t, err)
+
+	o.antiEntropyInterval = time.Millisecond * 50
+	o.antiEntropy(ctx, store, &testingTopic{name: addr, peers: []p2pcore.PeerID{o.PeerID(), alice, denied}})
+
+	sentHeads := func(p p2pcore.PeerID, c cid.Cid) func() bool {
+		return func() bool {
+			for _, heads := range channels.heads(t, p, addr) {
+				if len(heads) == 1 && heads[0].Equals(c) {
+					return true
+				}
+			}
+
+			return false
+		}
+	}
+
+	require.Eventually(t, sentHeads(alice, first.GetEntry().GetHash()), time.Second*5, time.Millisecond*10)
+
+	// the heads are not exchanged anymore once alice is known to have them
+	o.setKnownHeads(addr, alice, []cid.Cid{first.GetEntry().GetHash()})
+	time.Sleep(time.Millisecond * 100)
+
+	sent := len(channels.heads(t, alice, addr))
+	time.Sleep(time.Millisecond * 250)
+	require.Equal(t, sent, len(channels.heads(t, alice, addr)))
+
+	// a new head which alice didn't acknowledge, as if it missed the
+	// announcement, is exchanged on the next round
+	second, err := store.Add(ctx, []byte("hello1"))
+	require.NoError(t, err)
+
+	require.Eventually(t, sentHeads(alice, second.GetEntry().GetHash()), time.Second*5, time.Millisecond*10)
+
+	// the denied peer is never contacted, nor is the local peer
+	require.Len(t, channels.heads(t, denied, addr), 0)
+	require.Len(t, channels.heads(t, o.PeerID(), addr), 0)
+}
@@ -0,0 +1,349 @@
+package baseorbitdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"berty.tech/go-orbit-db/iface"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	ipfspath "github.com/ipfs/interface-go-ipfs-core/path"
+	p2pcore "github.com/libp2p/go-libp2p-core"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// messageTypeHeads The heads of a store, the only message type known by
+	// older peers, which is why its value is empty
+	messageTypeHeads = ""
+
+	// messageTypeWantBlocks A request for the given entries and their history
+	messageTypeWantBlocks = "want-blocks"
+
+	// messageTypeBlocks The entry blocks sent in response to a request
+	messageTypeBlocks = "blocks"
+)
+
+const (
+	// maxBlocksPerMessage The maximum number of blocks sent in a single
+	// response
+	maxBlocksPerMessage = 128
+
+	// maxBlocksMessageSize The approximate maximum size of the blocks sent in
+	// a single response
+	maxBlocksMessageSize = 1 << 20
+
+	// wantedBlockTimeout The delay during which a block requested from a
+	// peer is accepted
+	wantedBlockTimeout = time.Minute
+
+	// maxWantedBlocks The maximum number of blocks expected from a peer
+	maxWantedBlocks = 8192
+)
+
+type exchangedBlock struct {
+	CID  string `json:"cid"`
+	Data []byte `json:"data"`
+}
+
+// sendDirectMessage Serializes and seals a message then sends it on a direct
+// channel, the binary envelope is used when the peer supports it
+func (o *orbitDB) sendDirectMessage(ctx context.Context, p p2pcore.PeerID, channel iface.DirectChannel, store Store, msg *exchangedHeads) error {
+	msgBytes, err := encodeMessage(msg, o.hasCapability(p, capabilityBinary))
+	if err != nil {
+		return err
+	}
+
+	if sharedKey := store.SharedKey(); sharedKey != nil {
+		msgBytes, err = sharedKey.Seal(msgBytes)
+		if err != nil {
+			return errors.Wrap(err, "unable to encrypt payload")
+		}
+	}
+
+	if o.hasCapability(p, capabilityStoreFraming) {
+		msgBytes = encodeFrame(store.Address().String(), msgBytes)
+	}
+
+	if err := channel.Send(ctx, msgBytes); err != nil {
+		return errors.Wrap(err, "unable to send message")
+	}
+
+	return nil
+}
+
+// requestBlocks Asks a peer for the given entries which are not in the log of
+// the store yet, along with their history
+func (o *orbitDB) requestBlocks(ctx context.Context, p p2pcore.PeerID, channel iface.DirectChannel, store Store, hashes []cid.Cid) error {
+	if o.disableBlockExchange || !o.hasCapability(p, capabilityBlockExchange) {
+		return nil
+	}
+
+	var (
+		wants      []string
+		wantedCIDs []cid.Cid
+		seen       = map[string]struct{}{}
+	)
+
+	for _, c := range hashes {
+		if _, ok := seen[c.String()]; ok {
+			continue
+		}
+
+		seen[c.String()] = struct{}{}
+
+		if _, ok := store.OpLog().Get(c); ok {
+			continue
+		}
+
+		wants = append(wants, c.String())
+		wantedCIDs = append(wantedCIDs, c)
+	}
+
+	if len(wants) == 0 {
+		return nil
+	}
+
+	o.addWantedBlocks(store.Address().String(), p, wantedCIDs)
+
+	o.logger.Debug(fmt.Sprintf("requesting %d entries for '%s'", len(wants), store.Address().String()))
+
+	return o.sendDirectMessage(ctx, p, channel, store, &exchangedHeads{
+		Type:    messageTypeWantBlocks,
+		Address: store.Address().String(),
+		Wants:   wants,
+	})
+}
+
+// serveBlocks Sends the requested entries and as much of their history as
+// possible, only the entries of the log of the store are served, from the
+// local blockstore and within the rate limit of the peer
+func (o *orbitDB) serveBlocks(ctx context.Context, p p2pcore.PeerID, channel iface.DirectChannel, store Store, wants []string) error {
+	if !o.allowServing(store.Address().String(), p) {
+		return errors.New(fmt.Sprintf("rejecting blocks request from peer %s", p.String()))
+	}
+
+	offline, err := o.IPFS().WithOptions(options.Api.Offline(true))
+	if err != nil {
+		return errors.Wrap(err, "unable to get offline IPFS API")
+	}
+
+	var (
+		blocks   []exchangedBlock
+		frontier []string
+		size     int
+	)
+
+	queued := map[string]struct{}{}
+	pending := make([]cid.Cid, 0, len(wants))
+
+	for _, w := range wants {
+		c, err := cid.Decode(w)
+		if err != nil {
+			return errors.Wrap(err, "unable to decode requested cid")
+		}
+
+		if _, ok := queued[c.String()]; ok {
+			continue
+		}
+
+		queued[c.String()] = struct{}{}
+		pending = append(pending, c)
+	}
+
+	for len(pending) > 0 {
+		c := pending[0]
+		pending = pending[1:]
+
+		if len(blocks) >= maxBlocksPerMessage || size >= maxBlocksMessageSize {
+			frontier = append(frontier, c.String())
+			continue
+		}
+
+		e, ok := store.OpLog().Get(c)
+		if !ok {
+			continue
+		}
+
+		r, err := offline.Block().Get(ctx, ipfspath.IpfsPath(c))
+		if err != nil {
+			o.logger.Debug(fmt.Sprintf("unable to get block %s", c.String()), zap.Error(err))
+			continue
+		}
+
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return errors.Wrap(err, "unable to read block")
+		}
+
+		blocks = append(blocks, exchangedBlock{CID: c.String(), Data: data})
+		size += len(data)
+
+		for _, n := range append(e.GetNext(), e.GetRefs()...) {
+			if _, ok := queued[n.String()]; ok {
+				continue
+			}
+
+			queued[n.String()] = struct{}{}
+			pending = append(pending, n)
+		}
+	}
+
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	o.logger.Debug(fmt.Sprintf("sending %d entries for '%s'", len(blocks), store.Address().String()))
+
+	return o.sendDirectMessage(ctx, p, channel, store, &exchangedHeads{
+		Type:     messageTypeBlocks,
+		Address:  store.Address().String(),
+		Blocks:   blocks,
+		Frontier: frontier,
+	})
+}
+
+// receiveBlocks Verifies and stores the received blocks which were requested
+// from the peer, then requests the remaining history
+func (o *orbitDB) receiveBlocks(ctx context.Context, p p2pcore.PeerID, channel iface.DirectChannel, store Store, blocks []exchangedBlock, frontier []string) error {
+	address := store.Address().String()
+
+	if !o.getHeadsGuard(address).allowBlocks(p) {
+		return errors.New(fmt.Sprintf("rejecting blocks from peer %s", p.String()))
+	}
+
+	for _, b := range blocks {
+		c, err := cid.Decode(b.CID)
+		if err != nil {
+			return errors.Wrap(err, "unable to decode received cid")
+		}
+
+		if !o.takeWantedBlock(address, p, c) {
+			o.logger.Debug(fmt.Sprintf("ignoring unrequested block %s from peer %s", c.String(), p.String()))
+			continue
+		}
+
+		prefix := c.Prefix()
+
+		computed, err := prefix.Sum(b.Data)
+		if err != nil {
+			return errors.Wrap(err, "unable to hash received block")
+		}
+
+		if !computed.Equals(c) {
+			return errors.New(fmt.Sprintf("received block doesn't match its cid %s", c.String()))
+		}
+
+		codec, ok := cid.CodecToStr[prefix.Codec]
+		if !ok {
+			return errors.New(fmt.Sprintf("unsupported codec for block %s", c.String()))
+		}
+
+		if _, err := o.IPFS().Block().Put(ctx, bytes.NewReader(b.Data), options.Block.Format(codec), options.Block.Hash(prefix.MhType, prefix.MhLength)); err != nil {
+			return errors.Wrap(err, "unable to store received block")
+		}
+
+		// the history of a block is sent after it
+		o.addWantedBlocks(address, p, blockLinks(c, b.Data))
+	}
+
+	if len(frontier) == 0 {
+		return nil
+	}
+
+	hashes := make([]cid.Cid, 0, len(frontier))
+	for _, f := range frontier {
+		c, err := cid.Decode(f)
+		if err != nil {
+			o.logger.Debug("unable to decode frontier cid", zap.Error(err))
+			continue
+		}
+
+		hashes = append(hashes, c)
+	}
+
+	return o.requestBlocks(ctx, p, channel, store, hashes)
+}
+
+// blockLinks Returns the CIDs linked by a block, the next and refs of an entry
+func blockLinks(c cid.Cid, data []byte) []cid.Cid {
+	prefix := c.Prefix()
+	if prefix.Codec != cid.DagCBOR {
+		return nil
+	}
+
+	node, err := cbornode.Decode(data, prefix.MhType, prefix.MhLength)
+	if err != nil {
+		return nil
+	}
+
+	links := node.Links()
+
+	cids := make([]cid.Cid, len(links))
+	for i, l := range links {
+		cids[i] = l.Cid
+	}
+
+	return cids
+}
+
+// wantedBlockKey The key of a block of a store in the requested blocks
+func wantedBlockKey(address string, c cid.Cid) string {
+	return address + "/" + c.String()
+}
+
+// addWantedBlocks Records the blocks of a store requested from a peer, so they
+// are accepted when received
+func (o *orbitDB) addWantedBlocks(address string, p p2pcore.PeerID, hashes []cid.Cid) {
+	if len(hashes) == 0 {
+		return
+	}
+
+	o.muWantedBlocks.Lock()
+	defer o.muWantedBlocks.Unlock()
+
+	wants, ok := o.wantedBlocks[p]
+	if !ok {
+		wants = map[string]time.Time{}
+		o.wantedBlocks[p] = wants
+	}
+
+	now := time.Now()
+	for key, expires := range wants {
+		if now.After(expires) {
+			delete(wants, key)
+		}
+	}
+
+	for _, c := range hashes {
+		if len(wants) >= maxWantedBlocks {
+			break
+		}
+
+		wants[wantedBlockKey(address, c)] = now.Add(wantedBlockTimeout)
+	}
+}
+
+// takeWantedBlock Returns whether a block of a store has been requested from
+// a peer and is still expected, a block is only accepted once
+func (o *orbitDB) takeWantedBlock(address string, p p2pcore.PeerID, c cid.Cid) bool {
+	o.muWantedBlocks.Lock()
+	defer o.muWantedBlocks.Unlock()
+
+	wants := o.wantedBlocks[p]
+	key := wantedBlockKey(address, c)
+
+	expires, ok := wants[key]
+	if !ok {
+		return false
+	}
+
+	delete(wants, key)
+
+	return time.Now().Before(expires)
+}
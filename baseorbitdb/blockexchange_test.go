@@ -0,0 +1,133 @@
+package baseorbitdb
+
+import (
+	"context"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	ipfspath "github.com/ipfs/interface-go-ipfs-core/path"
+	p2pcore "github.com/libp2p/go-libp2p-core"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o, clean := testingOrbitDB(ctx, t, mocknet.New(ctx), nil)
+	defer clean()
+
+	store := testingEventLog(ctx, t, o, "serve-blocks", nil)
+	defer store.Close()
+
+	first, err := store.Add(ctx, []byte("hello0"))
+	require.NoError(t, err)
+
+	second, err := store.Add(ctx, []byte("hello1"))
+	require.NoError(t, err)
+
+	channel := &testingDirectChannel{}
+	requester := p2pcore.PeerID("requester")
+
+	// the requested entry is sent along with its history
+	require.NoError(t, o.serveBlocks(ctx, requester, channel, store, []string{second.GetEntry().GetHash().String()}))
+
+	msgs := channel.messages(t)
+	require.Len(t, msgs, 1)
+	require.Equal(t, messageTypeBlocks, msgs[0].Type)
+	require.Equal(t, store.Address().String(), msgs[0].Address)
+	require.Len(t, msgs[0].Blocks, 2)
+	require.Equal(t, second.GetEntry().GetHash().String(), msgs[0].Blocks[0].CID)
+	require.Equal(t, first.GetEntry().GetHash().String(), msgs[0].Blocks[1].CID)
+
+	for _, b := range msgs[0].Blocks {
+		c, err := cid.Decode(b.CID)
+		require.NoError(t, err)
+
+		computed, err := c.Prefix().Sum(b.Data)
+		require.NoError(t, err)
+		require.True(t, computed.Equals(c))
+	}
+
+	// the blocks which are not entries of the store are not served
+	unknown := testingBlock(t, "unknown")
+	require.NoError(t, o.serveBlocks(ctx, requester, channel, store, []string{unknown.Cid().String()}))
+	require.Len(t, channel.messages(t), 1)
+
+	// the requests of a peer are rate limited
+	for i := 2; i < servingBurst; i++ {
+		require.NoError(t, o.serveBlocks(ctx, requester, channel, store, []string{first.GetEntry().GetHash().String()}))
+	}
+
+	require.Error(t, o.serveBlocks(ctx, requester, channel, store, []string{first.GetEntry().GetHash().String()}))
+
+	// the other peers are served
+	require.NoError(t, o.serveBlocks(ctx, p2pcore.PeerID("other"), channel, store, []string{first.GetEntry().GetHash().String()}))
+}
+
+func TestServeBlocksDeniedPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o, clean := testingOrbitDB(ctx, t, mocknet.New(ctx), nil)
+	defer clean()
+
+	denied := p2pcore.PeerID("denied")
+
+	store := testingEventLog(ctx, t, o, "serve-blocks-denied", &CreateDBOptions{DeniedPeers: []p2pcore.PeerID{denied}})
+	defer store.Close()
+
+	op, err := store.Add(ctx, []byte("hello"))
+	require.NoError(t, err)
+
+	channel := &testingDirectChannel{}
+
+	require.Error(t, o.serveBlocks(ctx, denied, channel, store, []string{op.GetEntry().GetHash().String()}))
+	require.Len(t, channel.messages(t), 0)
+}
+
+func TestReceiveBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o, clean := testingOrbitDB(ctx, t, mocknet.New(ctx), nil)
+	defer clean()
+
+	store := testingEventLog(ctx, t, o, "receive-blocks", nil)
+	defer store.Close()
+
+	offline, err := o.IPFS().WithOptions(options.Api.Offline(true))
+	require.NoError(t, err)
+
+	hasBlock := func(c cid.Cid) bool {
+		_, err := offline.Block().Stat(ctx, ipfspath.IpfsPath(c))
+		return err == nil
+	}
+
+	sender := p2pcore.PeerID("sender")
+	channel := &testingDirectChannel{}
+
+	wanted := testingBlock(t, "wanted")
+	unwanted := testingBlock(t, "unwanted")
+	mismatched := testingBlock(t, "mismatched")
+
+	o.addWantedBlocks(store.Address().String(), sender, []cid.Cid{wanted.Cid(), mismatched.Cid()})
+
+	// a block which wasn't requested is ignored
+	require.NoError(t, o.receiveBlocks(ctx, sender, channel, store, []exchangedBlock{{CID: unwanted.Cid().String(), Data: unwanted.RawData()}}, nil))
+	require.False(t, hasBlock(unwanted.Cid()))
+
+	// a requested block is stored, once
+	require.NoError(t, o.receiveBlocks(ctx, sender, channel, store, []exchangedBlock{{CID: wanted.Cid().String(), Data: wanted.RawData()}}, nil))
+	require.True(t, hasBlock(wanted.Cid()))
+	require.False(t, o.takeWantedBlock(store.Address().String(), sender, wanted.Cid()))
+
+	// a block whose data doesn't match its hash is rejected
+	require.Error(t, o.receiveBlocks(ctx, sender, channel, store, []exchangedBlock{{CID: mismatched.Cid().String(), Data: unwanted.RawData()}}, nil))
+	require.False(t, hasBlock(mismatched.Cid()))
+
+	// nothing is requested without a frontier
+	require.Len(t, channel.messages(t), 0)
+}
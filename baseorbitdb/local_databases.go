@@ -0,0 +1,117 @@
+package baseorbitdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"berty.tech/go-ipfs-log/io"
+	"berty.tech/go-orbit-db/address"
+	"berty.tech/go-orbit-db/cache"
+	"berty.tech/go-orbit-db/utils"
+	datastore "github.com/ipfs/go-datastore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	coreapi "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// manifestReadTimeout The maximum delay to read the manifest of a local
+// database
+const manifestReadTimeout = time.Second * 5
+
+// ListLocalDatabases Returns the databases with data in the directory of the
+// current DB, either created locally or holding heads. The caches of the
+// databases which aren't open are only opened while being inspected
+func (o *orbitDB) ListLocalDatabases(ctx context.Context) ([]*LocalDatabase, error) {
+	lister, ok := o.cache.(cache.Lister)
+	if !ok {
+		return nil, errors.New("the cache is not able to list its databases")
+	}
+
+	inspector, ok := o.cache.(cache.Inspector)
+	if !ok {
+		return nil, errors.New("the cache is not able to inspect its databases")
+	}
+
+	addrs, err := lister.List(o.directory)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list cached databases")
+	}
+
+	var databases []*LocalDatabase
+
+	for _, addr := range addrs {
+		hasData := false
+
+		err := inspector.Inspect(o.directory, addr, func(c datastore.Read) error {
+			hasData = o.haveLocalData(c, addr) || hasHeads(c)
+			return nil
+		})
+		if err != nil {
+			o.logger.Debug(fmt.Sprintf("unable to inspect cache of %s", addr.String()), zap.Error(err))
+			continue
+		}
+
+		if !hasData {
+			continue
+		}
+
+		db := &LocalDatabase{
+			Address: addr,
+			Name:    addr.GetPath(),
+		}
+
+		if manifest, err := o.readLocalManifest(ctx, addr); err == nil {
+			db.Name = manifest.Name
+			db.Type = manifest.Type
+		} else {
+			o.logger.Debug(fmt.Sprintf("unable to read manifest of %s", addr.String()), zap.Error(err))
+		}
+
+		databases = append(databases, db)
+	}
+
+	return databases, nil
+}
+
+// hasHeads Returns whether heads of a store are saved in its cache
+func hasHeads(c datastore.Read) bool {
+	for _, key := range []string{"_localHeads", "_remoteHeads"} {
+		if ok, err := c.Has(datastore.NewKey(key)); err == nil && ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readLocalManifest Reads the manifest of a database from the local blocks,
+// it isn't fetched from the network
+func (o *orbitDB) readLocalManifest(ctx context.Context, addr address.Address) (*utils.Manifest, error) {
+	ctx, cancel := context.WithTimeout(ctx, manifestReadTimeout)
+	defer cancel()
+
+	offline, err := o.IPFS().WithOptions(options.Api.Offline(true))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get offline IPFS API")
+	}
+
+	return readManifest(ctx, offline, addr)
+}
+
+// readManifest Reads the manifest of a database
+func readManifest(ctx context.Context, ipfs coreapi.CoreAPI, addr address.Address) (*utils.Manifest, error) {
+	manifestNode, err := io.ReadCBOR(ctx, ipfs, addr.GetRoot())
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch database manifest")
+	}
+
+	manifest := &utils.Manifest{}
+	if err := cbornode.DecodeInto(manifestNode.RawData(), manifest); err != nil {
+		return nil, errors.Wrap(err, "unable to unmarshal manifest")
+	}
+
+	return manifest, nil
+}
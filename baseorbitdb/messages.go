@@ -0,0 +1,534 @@
+package baseorbitdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-orbit-db/iface"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	p2pcore "github.com/libp2p/go-libp2p-core"
+	"github.com/pkg/errors"
+	"github.com/polydawn/refmt/obj/atlas"
+)
+
+// protocolVersion The version of the binary envelope, peers ignore the
+// envelopes with a higher version
+const protocolVersion = 1
+
+// frameMagic Prefixes the frames carrying the message of a store, followed
+// by the length of the store address, the address and the sealed message
+var frameMagic = []byte{0x00, 'o', 'r', 'f'}
+
+// envelopeMagic Prefixes the binary envelopes, legacy JSON messages start
+// with either '{' or '['
+var envelopeMagic = []byte{0x00, 'o', 'r', 'b'}
+
+const (
+	// messageTypeHello Advertises the capabilities of a peer when connecting
+	messageTypeHello = "hello"
+
+	// messageTypeAnnounce The heads of a store published on pubsub
+	messageTypeAnnounce = "announce"
+
+	// messageTypeAck Acknowledges the heads received from a peer
+	messageTypeAck = "ack"
+)
+
+const (
+	// capabilityBinary The peer understands the binary envelope
+	capabilityBinary = "binary"
+
+	// capabilityAck The peer acknowledges the heads it receives
+	capabilityAck = "ack"
+
+	// capabilityBlockExchange The peer serves entry blocks, see
+	// messageTypeWantBlocks
+	capabilityBlockExchange = "block-exchange"
+
+	// capabilityStoreFraming The peer reads the store address of the
+	// messages from their frame, see encodeFrame
+	capabilityStoreFraming = "store-framing"
+)
+
+// supportedCapabilities The capabilities advertised by this implementation
+var supportedCapabilities = []string{capabilityBinary, capabilityAck, capabilityBlockExchange, capabilityStoreFraming, capabilityReconcile}
+
+// envelope The binary representation of the exchanged messages
+type envelope struct {
+	Version      int
+	Type         string
+	Capabilities []string
+	Address      string
+	Heads        []envelopeHead
+	Wants        []string
+	Blocks       []envelopeBlock
+	Frontier     []string
+	Acked        []string
+	Filter       []byte
+	FilterHashes int
+	Missing      []string
+}
+
+type envelopeBlock struct {
+	CID  string
+	Data []byte
+}
+
+// envelopeHead The fields of an entry, the hashes being encoded as CBOR links
+type envelopeHead struct {
+	Hash           cid.Cid
+	LogID          string
+	Payload        []byte
+	Next           []cid.Cid
+	Refs           []cid.Cid
+	V              uint64
+	Key            []byte
+	Sig            []byte
+	ClockID        []byte
+	ClockTime      int
+	Identity       envelopeIdentity
+	AdditionalData map[string]string
+}
+
+type envelopeIdentity struct {
+	ID                 string
+	PublicKey          []byte
+	SignatureID        []byte
+	SignaturePublicKey []byte
+	Type               string
+}
+
+// newEnvelopeHead Converts an entry to its envelope representation
+func newEnvelopeHead(e *entry.Entry) (envelopeHead, error) {
+	if !e.Hash.Defined() {
+		return envelopeHead{}, errors.New("unable to serialize a head without hash")
+	}
+
+	head := envelopeHead{
+		Hash:           e.Hash,
+		LogID:          e.LogID,
+		Payload:        e.Payload,
+		Next:           e.Next,
+		Refs:           e.Refs,
+		V:              e.V,
+		Key:            e.Key,
+		Sig:            e.Sig,
+		AdditionalData: e.AdditionalData,
+	}
+
+	if e.Clock != nil {
+		head.ClockID = e.Clock.ID
+		head.ClockTime = e.Clock.Time
+	}
+
+	if e.Identity != nil {
+		head.Identity = envelopeIdentity{
+			ID:        e.Identity.ID,
+			PublicKey: e.Identity.PublicKey,
+			Type:      e.Identity.Type,
+		}
+
+		if e.Identity.Signatures != nil {
+			head.Identity.SignatureID = e.Identity.Signatures.ID
+			head.Identity.SignaturePublicKey = e.Identity.Signatures.PublicKey
+		}
+	}
+
+	return head, nil
+}
+
+// toEntry Converts an envelope head back to an entry
+func (h *envelopeHead) toEntry() *entry.Entry {
+	e := &entry.Entry{
+		Hash:           h.Hash,
+		LogID:          h.LogID,
+		Payload:        h.Payload,
+		Next:           h.Next,
+		Refs:           h.Refs,
+		V:              h.V,
+		Key:            h.Key,
+		Sig:            h.Sig,
+		Clock:          entry.NewLamportClock(h.ClockID, h.ClockTime),
+		AdditionalData: h.AdditionalData,
+	}
+
+	if h.Identity.ID != "" {
+		e.Identity = &identityprovider.Identity{
+			ID:        h.Identity.ID,
+			PublicKey: h.Identity.PublicKey,
+			Type:      h.Identity.Type,
+			Signatures: &identityprovider.IdentitySignature{
+				ID:        h.Identity.SignatureID,
+				PublicKey: h.Identity.SignaturePublicKey,
+			},
+		}
+	}
+
+	return e
+}
+
+// encodeMessage Serializes a message, as a binary envelope if the receiving
+// peer supports it or as JSON otherwise
+func encodeMessage(msg *exchangedHeads, useBinary bool) ([]byte, error) {
+	if !useBinary {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to serialize message")
+		}
+
+		return data, nil
+	}
+
+	env := &envelope{
+		Version:      protocolVersion,
+		Type:         msg.Type,
+		Capabilities: msg.Capabilities,
+		Address:      msg.Address,
+		Wants:        msg.Wants,
+		Frontier:     msg.Frontier,
+		Acked:        msg.Acked,
+		Filter:       msg.Filter,
+		FilterHashes: msg.FilterHashes,
+		Missing:      msg.Missing,
+	}
+
+	for _, h := range msg.Heads {
+		head, err := newEnvelopeHead(h)
+		if err != nil {
+			return nil, err
+		}
+
+		env.Heads = append(env.Heads, head)
+	}
+
+	for _, b := range msg.Blocks {
+		env.Blocks = append(env.Blocks, envelopeBlock{CID: b.CID, Data: b.Data})
+	}
+
+	data, err := cbornode.DumpObject(env)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to serialize envelope")
+	}
+
+	return append(append([]byte{}, envelopeMagic...), data...), nil
+}
+
+// decodeMessage Deserializes a message, either a binary envelope or a legacy
+// JSON message
+func decodeMessage(data []byte) (*exchangedHeads, bool, error) {
+	if !bytes.HasPrefix(data, envelopeMagic) {
+		msg := &exchangedHeads{}
+
+		// legacy pubsub announcements are a list of entries
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+			msg.Type = messageTypeAnnounce
+			if err := json.Unmarshal(data, &msg.Heads); err != nil {
+				return nil, false, errors.Wrap(err, "unable to unmarshal heads")
+			}
+
+			return msg, false, nil
+		}
+
+		if err := json.Unmarshal(data, msg); err != nil {
+			return nil, false, errors.Wrap(err, "unable to unmarshal message")
+		}
+
+		return msg, false, nil
+	}
+
+	env := &envelope{}
+	if err := cbornode.DecodeInto(data[len(envelopeMagic):], env); err != nil {
+		return nil, true, errors.Wrap(err, "unable to unmarshal envelope")
+	}
+
+	if env.Version < 1 || env.Version > protocolVersion {
+		return nil, true, errors.New(fmt.Sprintf("unsupported protocol version %d", env.Version))
+	}
+
+	msg := &exchangedHeads{
+		Type:         env.Type,
+		Capabilities: env.Capabilities,
+		Address:      env.Address,
+		Wants:        env.Wants,
+		Frontier:     env.Frontier,
+		Acked:        env.Acked,
+		Filter:       env.Filter,
+		FilterHashes: env.FilterHashes,
+		Missing:      env.Missing,
+	}
+
+	for i := range env.Heads {
+		msg.Heads = append(msg.Heads, env.Heads[i].toEntry())
+	}
+
+	for _, b := range env.Blocks {
+		msg.Blocks = append(msg.Blocks, exchangedBlock{CID: b.CID, Data: b.Data})
+	}
+
+	return msg, true, nil
+}
+
+// encodeFrame Prefixes a sealed message with the address of its store, so
+// the receiver knows which key opens it
+func encodeFrame(address string, sealed []byte) []byte {
+	frame := make([]byte, 0, len(frameMagic)+binary.MaxVarintLen64+len(address)+len(sealed))
+	frame = append(frame, frameMagic...)
+
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(address)))
+
+	frame = append(frame, lenBuf[:n]...)
+	frame = append(frame, address...)
+
+	return append(frame, sealed...)
+}
+
+// decodeFrame Splits a frame into the store address and the sealed message
+func decodeFrame(data []byte) (string, []byte, error) {
+	data = data[len(frameMagic):]
+
+	addrLen, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < addrLen {
+		return "", nil, errors.New("invalid frame")
+	}
+
+	data = data[n:]
+
+	return string(data[:addrLen]), data[addrLen:], nil
+}
+
+// openDirectMessage Opens and decodes a message received on a direct channel,
+// the returned store is nil for hello messages
+func (o *orbitDB) openDirectMessage(payload []byte) (*exchangedHeads, Store, error) {
+	if bytes.HasPrefix(payload, frameMagic) {
+		addr, sealed, err := decodeFrame(payload)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		store, ok := o.getStore(addr)
+		if !ok {
+			return nil, nil, errors.New(fmt.Sprintf("message for unknown store %s", addr))
+		}
+
+		msg, err := openStoreMessage(store, sealed)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if msg.Address != addr {
+			return nil, nil, errors.New("message address doesn't match its frame")
+		}
+
+		return msg, store, nil
+	}
+
+	// unframed messages come from older peers or are hello messages
+	if msg, _, err := decodeMessage(payload); err == nil {
+		if msg.Type == messageTypeHello {
+			return msg, nil, nil
+		}
+
+		store, ok := o.getStore(msg.Address)
+		if ok && store.SharedKey() == nil {
+			return msg, store, nil
+		}
+	}
+
+	// try the keys of the open stores
+	for _, store := range o.openStores() {
+		if store.SharedKey() == nil {
+			continue
+		}
+
+		msg, err := openStoreMessage(store, payload)
+		if err != nil || msg.Address != store.Address().String() {
+			continue
+		}
+
+		return msg, store, nil
+	}
+
+	return nil, nil, errors.New("unable to open message with the keys of the open stores")
+}
+
+// openStoreMessage Opens a message sealed with the shared key of a store
+func openStoreMessage(store Store, sealed []byte) (*exchangedHeads, error) {
+	data := sealed
+
+	if key := store.SharedKey(); key != nil {
+		var err error
+
+		data, err = key.Open(sealed)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to decrypt payload")
+		}
+	}
+
+	msg, _, err := decodeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// sendHello Advertises the supported capabilities to a peer, once. Hello
+// messages are not bound to a store, they are always sent as plain JSON so
+// older peers can ignore them
+func (o *orbitDB) sendHello(ctx context.Context, p p2pcore.PeerID, channel iface.DirectChannel) error {
+	o.muPeerCapabilities.Lock()
+	if o.helloSent[p] {
+		o.muPeerCapabilities.Unlock()
+		return nil
+	}
+
+	o.helloSent[p] = true
+	o.muPeerCapabilities.Unlock()
+
+	msgBytes, err := encodeMessage(&exchangedHeads{
+		Type:         messageTypeHello,
+		Capabilities: supportedCapabilities,
+	}, false)
+	if err != nil {
+		return err
+	}
+
+	if err := channel.Send(ctx, msgBytes); err != nil {
+		return errors.Wrap(err, "unable to send hello")
+	}
+
+	return nil
+}
+
+// decodeCIDs Decodes a list of CIDs, skipping the invalid ones
+func decodeCIDs(values []string) []cid.Cid {
+	cids := make([]cid.Cid, 0, len(values))
+
+	for _, v := range values {
+		c, err := cid.Decode(v)
+		if err != nil {
+			continue
+		}
+
+		cids = append(cids, c)
+	}
+
+	return cids
+}
+
+// hasCapability Returns whether the given peer advertised a capability
+func (o *orbitDB) hasCapability(p p2pcore.PeerID, capability string) bool {
+	o.muPeerCapabilities.RLock()
+	defer o.muPeerCapabilities.RUnlock()
+
+	for _, c := range o.peerCapabilities[p] {
+		if c == capability {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forgetPeer Drops the capabilities, the heads known and the blocks and
+// reconciliations requested or started with a peer, so they are exchanged
+// again on the next connection
+func (o *orbitDB) forgetPeer(p p2pcore.PeerID) {
+	o.muPeerCapabilities.Lock()
+	delete(o.peerCapabilities, p)
+	delete(o.helloSent, p)
+	o.muPeerCapabilities.Unlock()
+
+	o.muKnownHeads.Lock()
+	for _, peers := range o.knownHeads {
+		delete(peers, p)
+	}
+	o.muKnownHeads.Unlock()
+
+	o.muWantedBlocks.Lock()
+	delete(o.wantedBlocks, p)
+	o.muWantedBlocks.Unlock()
+
+	o.muReconcile.Lock()
+	delete(o.reconcileRequests, p)
+	for address, peers := range o.lastReconcile {
+		delete(peers, p)
+		if len(peers) == 0 {
+			delete(o.lastReconcile, address)
+		}
+	}
+	o.muReconcile.Unlock()
+}
+
+// setCapabilities Records the capabilities advertised by a peer
+func (o *orbitDB) setCapabilities(p p2pcore.PeerID, capabilities []string) {
+	o.muPeerCapabilities.Lock()
+	defer o.muPeerCapabilities.Unlock()
+
+	if capabilities == nil {
+		capabilities = []string{}
+	}
+
+	o.peerCapabilities[p] = capabilities
+}
+
+func init() {
+	atlasEnvelope := atlas.BuildEntry(envelope{}).
+		StructMap().
+		AddField("Version", atlas.StructMapEntry{SerialName: "v"}).
+		AddField("Type", atlas.StructMapEntry{SerialName: "type"}).
+		AddField("Capabilities", atlas.StructMapEntry{SerialName: "caps"}).
+		AddField("Address", atlas.StructMapEntry{SerialName: "address"}).
+		AddField("Heads", atlas.StructMapEntry{SerialName: "heads"}).
+		AddField("Wants", atlas.StructMapEntry{SerialName: "wants"}).
+		AddField("Blocks", atlas.StructMapEntry{SerialName: "blocks"}).
+		AddField("Frontier", atlas.StructMapEntry{SerialName: "frontier"}).
+		AddField("Acked", atlas.StructMapEntry{SerialName: "acked"}).
+		AddField("Filter", atlas.StructMapEntry{SerialName: "filter"}).
+		AddField("FilterHashes", atlas.StructMapEntry{SerialName: "filter_hashes"}).
+		AddField("Missing", atlas.StructMapEntry{SerialName: "missing"}).
+		Complete()
+
+	atlasEnvelopeBlock := atlas.BuildEntry(envelopeBlock{}).
+		StructMap().
+		AddField("CID", atlas.StructMapEntry{SerialName: "cid"}).
+		AddField("Data", atlas.StructMapEntry{SerialName: "data"}).
+		Complete()
+
+	atlasEnvelopeHead := atlas.BuildEntry(envelopeHead{}).
+		StructMap().
+		AddField("Hash", atlas.StructMapEntry{SerialName: "hash"}).
+		AddField("LogID", atlas.StructMapEntry{SerialName: "id"}).
+		AddField("Payload", atlas.StructMapEntry{SerialName: "payload"}).
+		AddField("Next", atlas.StructMapEntry{SerialName: "next"}).
+		AddField("Refs", atlas.StructMapEntry{SerialName: "refs"}).
+		AddField("V", atlas.StructMapEntry{SerialName: "v"}).
+		AddField("Key", atlas.StructMapEntry{SerialName: "key"}).
+		AddField("Sig", atlas.StructMapEntry{SerialName: "sig"}).
+		AddField("ClockID", atlas.StructMapEntry{SerialName: "clock_id"}).
+		AddField("ClockTime", atlas.StructMapEntry{SerialName: "clock_time"}).
+		AddField("Identity", atlas.StructMapEntry{SerialName: "identity"}).
+		AddField("AdditionalData", atlas.StructMapEntry{SerialName: "additional_data"}).
+		Complete()
+
+	atlasEnvelopeIdentity := atlas.BuildEntry(envelopeIdentity{}).
+		StructMap().
+		AddField("ID", atlas.StructMapEntry{SerialName: "id"}).
+		AddField("PublicKey", atlas.StructMapEntry{SerialName: "public_key"}).
+		AddField("SignatureID", atlas.StructMapEntry{SerialName: "signature_id"}).
+		AddField("SignaturePublicKey", atlas.StructMapEntry{SerialName: "signature_public_key"}).
+		AddField("Type", atlas.StructMapEntry{SerialName: "type"}).
+		Complete()
+
+	cbornode.RegisterCborType(atlasEnvelope)
+	cbornode.RegisterCborType(atlasEnvelopeBlock)
+	cbornode.RegisterCborType(atlasEnvelopeHead)
+	cbornode.RegisterCborType(atlasEnvelopeIdentity)
+}
@@ -0,0 +1,346 @@
+package baseorbitdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-ipfs-log/identityprovider"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func testingCID(t *testing.T, value string) cid.Cid {
+	t.Helper()
+
+	c, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: 0x12 /* sha2-256 */}.Sum([]byte(value))
+	require.NoError(t, err)
+
+	return c
+}
+
+func testingEntry(t *testing.T) *entry.Entry {
+	t.Helper()
+
+	return &entry.Entry{
+		Hash:    testingCID(t, "head"),
+		LogID:   "log",
+		Payload: []byte("payload"),
+		Next:    []cid.Cid{testingCID(t, "next")},
+		Refs:    []cid.Cid{testingCID(t, "ref")},
+		V:       2,
+		Key:     []byte("key"),
+		Sig:     []byte("sig"),
+		Clock:   entry.NewLamportClock([]byte("clock"), 3),
+		Identity: &identityprovider.Identity{
+			ID:        "identity",
+			PublicKey: []byte("public key"),
+			Type:      "orbitdb",
+			Signatures: &identityprovider.IdentitySignature{
+				ID:        []byte("id signature"),
+				PublicKey: []byte("public key signature"),
+			},
+		},
+	}
+}
+
+// requireSameMessage Compares two messages, ignoring the difference between
+// nil and empty fields which depends on the encoding
+func requireSameMessage(t *testing.T, expected, actual *exchangedHeads) {
+	t.Helper()
+
+	orNil := func(values []string) []string {
+		if len(values) == 0 {
+			return nil
+		}
+
+		return values
+	}
+
+	require.Equal(t, expected.Type, actual.Type)
+	require.Equal(t, orNil(expected.Capabilities), orNil(actual.Capabilities))
+	require.Equal(t, expected.Address, actual.Address)
+	require.Equal(t, orNil(expected.Wants), orNil(actual.Wants))
+	require.Equal(t, orNil(expected.Frontier), orNil(actual.Frontier))
+	require.Equal(t, orNil(expected.Acked), orNil(actual.Acked))
+	require.Equal(t, orNil(expected.Missing), orNil(actual.Missing))
+	require.Equal(t, len(expected.Filter), len(actual.Filter))
+	if len(expected.Filter) > 0 {
+		require.Equal(t, expected.Filter, actual.Filter)
+	}
+	require.Equal(t, expected.FilterHashes, actual.FilterHashes)
+
+	require.Len(t, actual.Blocks, len(expected.Blocks))
+	for i := range expected.Blocks {
+		require.Equal(t, expected.Blocks[i], actual.Blocks[i])
+	}
+
+	require.Len(t, actual.Heads, len(expected.Heads))
+	for i, e := range expected.Heads {
+		a := actual.Heads[i]
+
+		require.True(t, e.Hash.Equals(a.Hash))
+		require.Equal(t, e.LogID, a.LogID)
+		require.Equal(t, e.Payload, a.Payload)
+		require.Equal(t, e.Next, a.Next)
+		require.Equal(t, e.Refs, a.Refs)
+		require.Equal(t, e.V, a.V)
+		require.Equal(t, e.Key, a.Key)
+		require.Equal(t, e.Sig, a.Sig)
+		require.Equal(t, e.Clock.ID, a.Clock.ID)
+		require.Equal(t, e.Clock.Time, a.Clock.Time)
+		require.Equal(t, e.Identity.ID, a.Identity.ID)
+		require.Equal(t, e.Identity.PublicKey, a.Identity.PublicKey)
+		require.Equal(t, e.Identity.Type, a.Identity.Type)
+		require.Equal(t, e.Identity.Signatures.ID, a.Identity.Signatures.ID)
+		require.Equal(t, e.Identity.Signatures.PublicKey, a.Identity.Signatures.PublicKey)
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	address := "/orbitdb/" + testingCID(t, "manifest").String() + "/store"
+	block := testingBlock(t, "block")
+
+	cases := []struct {
+		name string
+		msg  *exchangedHeads
+	}{
+		{
+			name: "hello",
+			msg:  &exchangedHeads{Type: messageTypeHello, Capabilities: supportedCapabilities},
+		},
+		{
+			name: "heads",
+			msg:  &exchangedHeads{Type: messageTypeHeads, Address: address, Heads: []*entry.Entry{testingEntry(t)}},
+		},
+		{
+			name: "ack",
+			msg:  &exchangedHeads{Type: messageTypeAck, Address: address, Acked: []string{testingCID(t, "head").String()}},
+		},
+		{
+			name: "want blocks",
+			msg:  &exchangedHeads{Type: messageTypeWantBlocks, Address: address, Wants: []string{block.Cid().String()}},
+		},
+		{
+			name: "blocks",
+			msg: &exchangedHeads{
+				Type:     messageTypeBlocks,
+				Address:  address,
+				Blocks:   []exchangedBlock{{CID: block.Cid().String(), Data: block.RawData()}},
+				Frontier: []string{testingCID(t, "frontier").String()},
+			},
+		},
+		{
+			name: "reconcile",
+			msg:  &exchangedHeads{Type: messageTypeReconcile, Address: address, Filter: []byte{0x01, 0x02, 0xff}, FilterHashes: 7},
+		},
+		{
+			name: "missing",
+			msg:  &exchangedHeads{Type: messageTypeMissing, Address: address, Missing: []string{testingCID(t, "missing").String()}},
+		},
+	}
+
+	for _, c := range cases {
+		for _, useBinary := range []bool{false, true} {
+			name := c.name + " json"
+			if useBinary {
+				name = c.name + " binary"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				data, err := encodeMessage(c.msg, useBinary)
+				require.NoError(t, err)
+
+				decoded, isBinary, err := decodeMessage(data)
+				require.NoError(t, err)
+				require.Equal(t, useBinary, isBinary)
+
+				requireSameMessage(t, c.msg, decoded)
+			})
+		}
+	}
+}
+
+func TestMessageEncodeHeadWithoutHash(t *testing.T) {
+	head := testingEntry(t)
+	head.Hash = cid.Undef
+
+	_, err := encodeMessage(&exchangedHeads{Heads: []*entry.Entry{head}}, true)
+	require.Error(t, err)
+}
+
+func TestMessageDecodeErrors(t *testing.T) {
+	envelopeWithVersion := func(version int) []byte {
+		data, err := cbornode.DumpObject(&envelope{Version: version, Type: messageTypeHello})
+		require.NoError(t, err)
+
+		return append(append([]byte{}, envelopeMagic...), data...)
+	}
+
+	cases := []struct {
+		name     string
+		data     []byte
+		isBinary bool
+	}{
+		{name: "unknown protocol version", data: envelopeWithVersion(protocolVersion + 1), isBinary: true},
+		{name: "missing protocol version", data: envelopeWithVersion(0), isBinary: true},
+		{name: "invalid envelope", data: append(append([]byte{}, envelopeMagic...), 0xff, 0x00), isBinary: true},
+		{name: "invalid json object", data: []byte(`{"type":`)},
+		{name: "invalid json heads", data: []byte(`[{"hash":`)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msg, isBinary, err := decodeMessage(c.data)
+			require.Error(t, err)
+			require.Nil(t, msg)
+			require.Equal(t, c.isBinary, isBinary)
+		})
+	}
+
+	// the current version is accepted
+	msg, isBinary, err := decodeMessage(envelopeWithVersion(protocolVersion))
+	require.NoError(t, err)
+	require.True(t, isBinary)
+	require.Equal(t, messageTypeHello, msg.Type)
+}
+
+func TestMessageDecodeLegacyAnnouncement(t *testing.T) {
+	head := testingEntry(t)
+
+	data, err := json.Marshal([]*entry.Entry{head})
+	require.NoError(t, err)
+
+	msg, isBinary, err := decodeMessage(data)
+	require.NoError(t, err)
+	require.False(t, isBinary)
+
+	requireSameMessage(t, &exchangedHeads{Type: messageTypeAnnounce, Heads: []*entry.Entry{head}}, msg)
+}
+
+func TestMessageFrameRoundTrip(t *testing.T) {
+	address := "/orbitdb/" + testingCID(t, "manifest").String() + "/store"
+
+	addr, sealed, err := decodeFrame(encodeFrame(address, []byte("sealed")))
+	require.NoError(t, err)
+	require.Equal(t, address, addr)
+	require.Equal(t, []byte("sealed"), sealed)
+
+	// the address length can't exceed the frame
+	_, _, err = decodeFrame(append(append([]byte{}, frameMagic...), 0x10, 'a'))
+	require.Error(t, err)
+}
+
+func TestOpenDirectMessage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o, clean := testingOrbitDB(ctx, t, mocknet.New(ctx), nil)
+	defer clean()
+
+	public := testingEventLog(ctx, t, o, "open-direct-message-public", nil)
+	defer public.Close()
+
+	sharedKey := testingSecretbox(t, 0)
+
+	private := testingEventLog(ctx, t, o, "open-direct-message-private", &CreateDBOptions{SharedKey: sharedKey})
+	defer private.Close()
+
+	encode := func(msg *exchangedHeads, useBinary bool) []byte {
+		data, err := encodeMessage(msg, useBinary)
+		require.NoError(t, err)
+
+		return data
+	}
+
+	seal := func(data []byte) []byte {
+		sealed, err := sharedKey.Seal(data)
+		require.NoError(t, err)
+
+		return sealed
+	}
+
+	publicMsg := &exchangedHeads{Type: messageTypeAck, Address: public.Address().String()}
+	privateMsg := &exchangedHeads{Type: messageTypeAck, Address: private.Address().String()}
+	unknownMsg := &exchangedHeads{Type: messageTypeAck, Address: "/orbitdb/" + testingCID(t, "unknown").String() + "/store"}
+
+	cases := []struct {
+		name    string
+		payload []byte
+		store   Store
+		msgType string
+		err     bool
+	}{
+		{
+			name:    "hello",
+			payload: encode(&exchangedHeads{Type: messageTypeHello, Capabilities: supportedCapabilities}, false),
+			msgType: messageTypeHello,
+		},
+		{
+			name:    "framed",
+			payload: encodeFrame(public.Address().String(), encode(publicMsg, true)),
+			store:   public,
+			msgType: messageTypeAck,
+		},
+		{
+			name:    "framed and sealed",
+			payload: encodeFrame(private.Address().String(), seal(encode(privateMsg, true))),
+			store:   private,
+			msgType: messageTypeAck,
+		},
+		{
+			name:    "legacy unframed",
+			payload: encode(publicMsg, false),
+			store:   public,
+			msgType: messageTypeAck,
+		},
+		{
+			name:    "legacy unframed and sealed",
+			payload: seal(encode(privateMsg, false)),
+			store:   private,
+			msgType: messageTypeAck,
+		},
+		{
+			name:    "legacy unframed for a store with a key",
+			payload: encode(privateMsg, false),
+			err:     true,
+		},
+		{
+			name:    "framed for an unknown store",
+			payload: encodeFrame(unknownMsg.Address, encode(unknownMsg, true)),
+			err:     true,
+		},
+		{
+			name:    "frame not matching its message",
+			payload: encodeFrame(public.Address().String(), encode(privateMsg, true)),
+			err:     true,
+		},
+		{
+			name:    "legacy unframed for an unknown store",
+			payload: encode(unknownMsg, false),
+			err:     true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msg, store, err := o.openDirectMessage(c.payload)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, c.msgType, msg.Type)
+
+			if c.store == nil {
+				require.Nil(t, store)
+			} else {
+				require.Equal(t, c.store.Address().String(), store.Address().String())
+			}
+		})
+	}
+}
@@ -2,6 +2,9 @@ package baseorbitdb
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"path"
@@ -10,7 +13,6 @@ import (
 	"time"
 
 	ipfslog "berty.tech/go-ipfs-log"
-	"berty.tech/go-ipfs-log/enc"
 	"berty.tech/go-ipfs-log/entry"
 	"berty.tech/go-ipfs-log/identityprovider"
 	idp "berty.tech/go-ipfs-log/identityprovider"
@@ -23,14 +25,15 @@ import (
 	"berty.tech/go-orbit-db/cache/cacheleveldown"
 	"berty.tech/go-orbit-db/iface"
 	_ "berty.tech/go-orbit-db/internal/buildconstraints" // fail for bad go version
+	"berty.tech/go-orbit-db/keyring"
 	"berty.tech/go-orbit-db/pubsub/oneonone"
 	"berty.tech/go-orbit-db/pubsub/pubsubcoreapi"
 	"berty.tech/go-orbit-db/stores"
+	"berty.tech/go-orbit-db/stores/headlessstore"
 	"berty.tech/go-orbit-db/utils"
 	cid "github.com/ipfs/go-cid"
 	datastore "github.com/ipfs/go-datastore"
 	leveldb "github.com/ipfs/go-ds-leveldb"
-	cbornode "github.com/ipfs/go-ipld-cbor"
 	coreapi "github.com/ipfs/interface-go-ipfs-core"
 	p2pcore "github.com/libp2p/go-libp2p-core"
 	"github.com/pkg/errors"
@@ -71,12 +74,27 @@ type CreateDBOptions = iface.CreateDBOptions
 // DetermineAddressOptions An alias of the type defined in the iface package
 type DetermineAddressOptions = iface.DetermineAddressOptions
 
+// ReplicationServiceOptions An alias of the type defined in the iface package
+type ReplicationServiceOptions = iface.ReplicationServiceOptions
+
+// LocalDatabase An alias of the type defined in the iface package
+type LocalDatabase = iface.LocalDatabase
+
 // DirectChannelFactory An alias of the type defined in the iface package
 type DirectChannelFactory = iface.DirectChannelFactory
 
 type exchangedHeads struct {
-	Address string         `json:"address,omitempty"`
-	Heads   []*entry.Entry `json:"heads,omitempty"`
+	Type         string           `json:"type,omitempty"`
+	Capabilities []string         `json:"capabilities,omitempty"`
+	Address      string           `json:"address,omitempty"`
+	Heads        []*entry.Entry   `json:"heads,omitempty"`
+	Wants        []string         `json:"wants,omitempty"`
+	Blocks       []exchangedBlock `json:"blocks,omitempty"`
+	Frontier     []string         `json:"frontier,omitempty"`
+	Acked        []string         `json:"acked,omitempty"`
+	Filter       []byte           `json:"filter,omitempty"`
+	FilterHashes int              `json:"filter_hashes,omitempty"`
+	Missing      []string         `json:"missing,omitempty"`
 }
 
 func boolPtr(val bool) *bool {
@@ -96,9 +114,45 @@ type NewOrbitDBOptions struct {
 	Tracer               trace.Tracer
 	DirectChannelFactory iface.DirectChannelFactory
 	PubSub               iface.PubSubInterface
+
+	// PublishHeadsDebounce Coalesces the heads announcements of writes
+	// happening within the given duration, disabled when zero
+	PublishHeadsDebounce time.Duration
+
+	// DisableBlockExchange Prevents requesting the history of the received
+	// heads to the peer which sent them, entries are then only fetched
+	// through bitswap
+	DisableBlockExchange bool
+
+	// BinaryAnnouncements Publishes the heads on pubsub using the binary
+	// envelope, which peers older than the protocol version 1 can't read
+	BinaryAnnouncements bool
+
+	// AntiEntropyInterval Periodically exchanges the heads of the replicated
+	// stores with the peers whose heads are not known to match, disabled
+	// when zero
+	AntiEntropyInterval time.Duration
+
+	// DisableReconciliation Prevents comparing the entries of the stores
+	// with the peers using Bloom filters when their heads differ
+	DisableReconciliation bool
+
+	// MaxOpenStores The maximum number of stores keeping their cache and
+	// replicator loaded, the least recently used ones are suspended even
+	// while they are held and resumed transparently on their next write,
+	// load or sync. A store busy with an operation is suspended shortly
+	// after it is done
+	MaxOpenStores int
+
+	// StoreIdleTimeout Suspends the stores which haven't been used for the
+	// given duration, like the ones exceeding MaxOpenStores. Both are
+	// ignored when the cache is kept in memory
+	StoreIdleTimeout time.Duration
 }
 
 type orbitDB struct {
+	ctx                   context.Context
+	cancel                context.CancelFunc
 	storeTypes            map[string]iface.StoreConstructor
 	accessControllerTypes map[string]iface.AccessControllerConstructor
 	ipfs                  coreapi.CoreAPI
@@ -107,16 +161,36 @@ type orbitDB struct {
 	pubsub                iface.PubSubInterface
 	keystore              keystore.Interface
 	closeKeystore         func() error
-	stores                map[string]Store
+	stores                map[string]*storeEntry
+	openLocks             map[string]*openLock
 	directConnections     map[p2pcore.PeerID]iface.DirectChannel
 	directConnFactory     iface.DirectChannelFactory
 	directory             string
 	cache                 cache.Interface
 	logger                *zap.Logger
 	tracer                trace.Tracer
+	publishHeadsDebounce  time.Duration
+	disableBlockExchange  bool
+	binaryAnnouncements   bool
+	peerCapabilities      map[p2pcore.PeerID][]string
+	helloSent             map[p2pcore.PeerID]bool
+	antiEntropyInterval   time.Duration
+	knownHeads            map[string]map[p2pcore.PeerID]string
+	disableReconciliation bool
+	lastReconcile         map[string]map[p2pcore.PeerID]time.Time
+	reconcileRequests     map[p2pcore.PeerID]map[string]time.Time
+	headsGuards           map[string]*headsGuard
+	servingGuard          *headsGuard
+	storeTopics           map[string]*storeTopicRef
+	topicValidators       map[string]Store
+	wantedBlocks          map[p2pcore.PeerID]map[string]time.Time
+	maxOpenStores         int
+	storeIdleTimeout      time.Duration
+	evictSignal           chan struct{}
 
 	muStoreTypes            sync.RWMutex
 	muStores                sync.RWMutex
+	muOpenLocks             sync.Mutex
 	muIdentity              sync.RWMutex
 	muID                    sync.RWMutex
 	muIPFS                  sync.RWMutex
@@ -124,6 +198,13 @@ type orbitDB struct {
 	muCaches                sync.RWMutex
 	muDirectConnections     sync.RWMutex
 	muAccessControllerTypes sync.RWMutex
+	muPeerCapabilities      sync.RWMutex
+	muKnownHeads            sync.RWMutex
+	muReconcile             sync.Mutex
+	muHeadsGuards           sync.RWMutex
+	muStoreTopics           sync.Mutex
+	muTopicValidators       sync.Mutex
+	muWantedBlocks          sync.Mutex
 }
 
 func (o *orbitDB) Logger() *zap.Logger {
@@ -171,42 +252,6 @@ func (o *orbitDB) CloseKeyStore() func() error {
 	return o.closeKeystore
 }
 
-func (o *orbitDB) setStore(address string, store iface.Store) {
-	o.muStores.Lock()
-	defer o.muStores.Unlock()
-
-	o.stores[address] = store
-}
-
-func (o *orbitDB) getStore(address string) (iface.Store, bool) {
-	o.muStores.RLock()
-	defer o.muStores.RUnlock()
-
-	store, ok := o.stores[address]
-
-	return store, ok
-}
-
-func (o *orbitDB) deleteStore(address string) {
-	o.muStores.Lock()
-	defer o.muStores.Unlock()
-
-	delete(o.stores, address)
-}
-
-func (o *orbitDB) closeAllStores() {
-	o.muStores.Lock()
-	defer o.muStores.Unlock()
-
-	for _, store := range o.stores {
-		if err := store.Close(); err != nil {
-			o.logger.Error("unable to close store", zap.Error(err))
-		}
-	}
-
-	o.stores = map[string]Store{}
-}
-
 func (o *orbitDB) closeCache() {
 	o.muCaches.Lock()
 	defer o.muCaches.Unlock()
@@ -279,7 +324,9 @@ func (o *orbitDB) RegisterAccessControllerType(constructor iface.AccessControlle
 
 }
 
-func (o *orbitDB) getDirectConnection(ctx context.Context, peerID p2pcore.PeerID, sharedKey enc.SharedKey) (iface.DirectChannel, error) {
+// getDirectConnection Returns the direct channel shared by all the stores
+// exchanging with a peer, its lifecycle is bound to the OrbitDB instance
+func (o *orbitDB) getDirectConnection(peerID p2pcore.PeerID) (iface.DirectChannel, error) {
 	o.muDirectConnections.Lock()
 	defer o.muDirectConnections.Unlock()
 
@@ -287,7 +334,7 @@ func (o *orbitDB) getDirectConnection(ctx context.Context, peerID p2pcore.PeerID
 		return conn, nil
 	}
 
-	channel, err := o.directConnFactory(ctx, peerID, &iface.DirectChannelOptions{
+	channel, err := o.directConnFactory(o.ctx, peerID, &iface.DirectChannelOptions{
 		Logger: o.logger,
 	})
 	if err != nil {
@@ -295,11 +342,22 @@ func (o *orbitDB) getDirectConnection(ctx context.Context, peerID p2pcore.PeerID
 	}
 
 	o.directConnections[peerID] = channel
-	o.watchOneOnOneMessage(ctx, channel, sharedKey)
+	o.watchOneOnOneMessage(o.ctx, peerID, channel)
 
 	return channel, nil
 }
 
+// evictDirectConnection Drops a closed direct channel, a new one is created
+// on the next exchange with the peer
+func (o *orbitDB) evictDirectConnection(peerID p2pcore.PeerID, channel iface.DirectChannel) {
+	o.muDirectConnections.Lock()
+	defer o.muDirectConnections.Unlock()
+
+	if conn, ok := o.directConnections[peerID]; ok && conn == channel {
+		delete(o.directConnections, peerID)
+	}
+}
+
 // RegisterStoreType Registers a new store type which can be used by its name
 func (o *orbitDB) RegisterStoreType(storeType string, constructor iface.StoreConstructor) {
 	o.muStoreTypes.Lock()
@@ -386,14 +444,19 @@ func newOrbitDB(ctx context.Context, is coreapi.CoreAPI, identity *idp.Identity,
 		options.Directory = &cacheleveldown.InMemoryDirectory
 	}
 
-	return &orbitDB{
+	odbCtx, cancel := context.WithCancel(context.Background())
+
+	o := &orbitDB{
+		ctx:                   odbCtx,
+		cancel:                cancel,
 		ipfs:                  is,
 		identity:              identity,
 		id:                    *options.PeerID,
 		pubsub:                options.PubSub,
 		cache:                 options.Cache,
 		directory:             *options.Directory,
-		stores:                map[string]Store{},
+		stores:                map[string]*storeEntry{},
+		openLocks:             map[string]*openLock{},
 		directConnections:     map[p2pcore.PeerID]iface.DirectChannel{},
 		closeKeystore:         options.CloseKeystore,
 		storeTypes:            map[string]iface.StoreConstructor{},
@@ -401,7 +464,31 @@ func newOrbitDB(ctx context.Context, is coreapi.CoreAPI, identity *idp.Identity,
 		logger:                options.Logger,
 		tracer:                options.Tracer,
 		directConnFactory:     options.DirectChannelFactory,
-	}, nil
+		publishHeadsDebounce:  options.PublishHeadsDebounce,
+		disableBlockExchange:  options.DisableBlockExchange,
+		binaryAnnouncements:   options.BinaryAnnouncements,
+		peerCapabilities:      map[p2pcore.PeerID][]string{},
+		helloSent:             map[p2pcore.PeerID]bool{},
+		antiEntropyInterval:   options.AntiEntropyInterval,
+		knownHeads:            map[string]map[p2pcore.PeerID]string{},
+		disableReconciliation: options.DisableReconciliation,
+		lastReconcile:         map[string]map[p2pcore.PeerID]time.Time{},
+		reconcileRequests:     map[p2pcore.PeerID]map[string]time.Time{},
+		headsGuards:           map[string]*headsGuard{},
+		servingGuard:          newServingGuard(),
+		storeTopics:           map[string]*storeTopicRef{},
+		topicValidators:       map[string]Store{},
+		wantedBlocks:          map[p2pcore.PeerID]map[string]time.Time{},
+		maxOpenStores:         options.MaxOpenStores,
+		storeIdleTimeout:      options.StoreIdleTimeout,
+		evictSignal:           make(chan struct{}, 1),
+	}
+
+	if o.suspendStores() {
+		go o.evictStoresLoop()
+	}
+
+	return o, nil
 }
 
 // NewOrbitDB Creates a new OrbitDB instance
@@ -474,6 +561,7 @@ func NewOrbitDB(ctx context.Context, ipfs coreapi.CoreAPI, options *NewOrbitDBOp
 func (o *orbitDB) Close() error {
 	o.closeAllStores()
 	o.closeDirectConnections()
+	o.cancel()
 	o.closeCache()
 	o.closeKeyStore()
 
@@ -539,6 +627,15 @@ func (o *orbitDB) Open(ctx context.Context, dbAddress string, options *CreateDBO
 		options.Create = boolPtr(false)
 	}
 
+	if options.KeyRing != nil {
+		sharedKey, err := keyring.NewSharedKey(options.KeyRing)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to use key ring")
+		}
+
+		options.SharedKey = sharedKey
+	}
+
 	if options.IO == nil {
 		cborIO := io.CBOR()
 		if options.SharedKey != nil {
@@ -577,6 +674,17 @@ func (o *orbitDB) Open(ctx context.Context, dbAddress string, options *CreateDBO
 		return nil, errors.Wrap(err, "unable to parse address")
 	}
 
+	unlock := o.lockAddress(parsedDBAddress.String())
+	defer unlock()
+
+	if store, ok, err := o.acquireStore(parsedDBAddress.String(), options); err != nil {
+		return nil, err
+	} else if ok {
+		o.logger.Debug(fmt.Sprintf("database %s is already open", dbAddress))
+
+		return store, nil
+	}
+
 	dbCache, err := o.loadCache(directory, parsedDBAddress)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to acquire cache")
@@ -587,14 +695,9 @@ func (o *orbitDB) Open(ctx context.Context, dbAddress string, options *CreateDBO
 		return nil, errors.New(fmt.Sprintf("database %s doesn't exist!", dbAddress))
 	}
 
-	manifestNode, err := io.ReadCBOR(ctx, o.IPFS(), parsedDBAddress.GetRoot())
+	manifest, err := readManifest(ctx, o.IPFS(), parsedDBAddress)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to fetch database manifest")
-	}
-
-	manifest := &utils.Manifest{}
-	if err := cbornode.DecodeInto(manifestNode.RawData(), manifest); err != nil {
-		return nil, errors.Wrap(err, "unable to unmarshal manifest")
+		return nil, err
 	}
 
 	o.logger.Debug("Creating store instance")
@@ -665,7 +768,7 @@ func (o *orbitDB) loadCache(directory string, dbAddress address.Address) (datast
 	return db, nil
 }
 
-func (o *orbitDB) haveLocalData(c datastore.Datastore, dbAddress address.Address) bool {
+func (o *orbitDB) haveLocalData(c datastore.Read, dbAddress address.Address) bool {
 	if c == nil {
 		o.logger.Debug("haveLocalData: no cache provided")
 		return false
@@ -702,11 +805,26 @@ func (o *orbitDB) addManifestToCache(directory string, dbAddress address.Address
 
 func (o *orbitDB) createStore(ctx context.Context, storeType string, parsedDBAddress address.Address, options *CreateDBOptions) (Store, error) {
 	var err error
+
+	// the defaults below are set on a copy, the caller's options are left
+	// untouched
+	optionsCopy := *options
+	requested := *options
+	options = &optionsCopy
 	storeFunc, ok := o.getStoreConstructor(storeType)
+	if options.Headless {
+		storeFunc, ok = headlessstore.NewHeadlessStore, true
+	}
+
 	if !ok {
 		return nil, errors.New(fmt.Sprintf("store type %s is not supported", storeType))
 	}
 
+	topicName, err := storeTopic(parsedDBAddress, options)
+	if err != nil {
+		return nil, err
+	}
+
 	var accessController accesscontroller.Interface
 	options.AccessControllerAddress = strings.TrimPrefix(options.AccessControllerAddress, "/ipfs/")
 
@@ -753,6 +871,16 @@ func (o *orbitDB) createStore(ctx context.Context, storeType string, parsedDBAdd
 		options.Directory = &o.directory
 	}
 
+	entry := &storeEntry{
+		refs:      1,
+		storeType: storeType,
+		headless:  options.Headless,
+		options:   requested,
+	}
+	release := func(drop bool) bool {
+		return o.releaseStore(parsedDBAddress.String(), entry, drop)
+	}
+
 	store, err := storeFunc(ctx, o.IPFS(), identity, parsedDBAddress, &iface.NewStoreOptions{
 		AccessController: accessController,
 		Cache:            options.Cache,
@@ -764,38 +892,221 @@ func (o *orbitDB) createStore(ctx context.Context, storeType string, parsedDBAdd
 		Tracer:           o.tracer,
 		IO:               options.IO,
 		SharedKey:        options.SharedKey,
+		RecipientKey:     options.RecipientKey,
+		Release:          release,
+		ReopenCache:      func() (datastore.Datastore, error) { return o.loadCache(o.directory, parsedDBAddress) },
+		Used:             func(resumed bool) { o.useStore(entry, resumed) },
+		Suspended:        func() { o.stopStoreTopic(entry) },
+		Resumed:          func() error { return o.startStoreTopic(entry) },
+
+		ReplicationConcurrency:       options.ReplicationConcurrency,
+		ReplicationBatchSize:         options.ReplicationBatchSize,
+		ReplicationAdaptiveBatchSize: options.ReplicationAdaptiveBatchSize,
+		ReplicationMaxBatchSize:      options.ReplicationMaxBatchSize,
+		ReplicationFetchConcurrency:  options.ReplicationFetchConcurrency,
+		ReplicationFetchTimeout:      options.ReplicationFetchTimeout,
+		ReplicationMaxRetries:        options.ReplicationMaxRetries,
+		ReplicationRetryBackoff:      options.ReplicationRetryBackoff,
+		ReplicationMaxRetryBackoff:   options.ReplicationMaxRetryBackoff,
+		ReplicationFilter:            options.ReplicationFilter,
+		ReplicationMaxDepth:          options.ReplicationMaxDepth,
+		ReplicatorConstructor:        options.ReplicatorConstructor,
 	})
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to instantiate store")
 	}
 
-	topic, err := o.pubsub.TopicSubscribe(ctx, parsedDBAddress.String())
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to subscribe to pubsub")
-	}
+	entry.store = store
+	entry.topicName = topicName
+	entry.replicate = *options.Replicate
 
-	o.storeListener(ctx, store, topic)
-	o.setStore(parsedDBAddress.String(), store)
+	o.setHeadsGuard(store, newHeadsGuard(options))
 
 	// Subscribe to pubsub to get updates from peers,
 	// this is what hooks us into the message propagation layer
 	// and the p2p network
-	if *options.Replicate {
-		if err := o.pubSubChanListener(ctx, store, topic, parsedDBAddress); err != nil {
-			return nil, err
-		}
+	if err := o.startStoreTopic(entry); err != nil {
+		_ = store.Close()
+		return nil, errors.Wrap(err, "unable to subscribe to pubsub")
 	}
 
+	o.storeListener(ctx, store, entry)
+	o.registerAnnouncementValidator(store, entry.currentTopic())
+	o.setStore(parsedDBAddress.String(), entry)
+
 	return store, nil
 }
 
-func (o *orbitDB) onClose(addr cid.Cid) error {
-	o.deleteStore(addr.String())
+// privateTopicPrefix Prefixes the names of the private pubsub topics
+const privateTopicPrefix = "/orbitdb/private/"
+
+// privateTopicLabel Prefixes the address authenticated to name a private
+// topic, so the key isn't used the same way for another purpose
+const privateTopicLabel = "orbitdb/private-topic/"
+
+// storeTopic Returns the name of the pubsub topic of a store, either its
+// address or, for private topics, an HMAC of the address with the shared key,
+// or the initial key of its key ring
+func storeTopic(addr address.Address, options *CreateDBOptions) (string, error) {
+	if !options.PrivateTopic {
+		return addr.String(), nil
+	}
+
+	if options.SharedKey == nil {
+		return "", errors.New("a shared key is required to use a private topic")
+	}
+
+	// the topic is derived from a key which survives the key rotations, so
+	// the peers keep meeting on the same topic
+	key, err := keyring.StableKey(options.SharedKey)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get private topic key")
+	}
+
+	raw, err := key.Marshal()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to derive private topic")
+	}
+
+	mac := hmac.New(sha256.New, raw)
+	_, _ = mac.Write([]byte(privateTopicLabel + addr.String()))
+
+	return privateTopicPrefix + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// storeTopicRef A pubsub topic joined by stores, and the number of open
+// stores using it
+type storeTopicRef struct {
+	topic iface.PubSubTopic
+	refs  int
+}
+
+// joinStoreTopic Subscribes to the pubsub topic of a store, a topic is
+// shared by the instances of a store opened again before it was left
+func (o *orbitDB) joinStoreTopic(ctx context.Context, name string) (iface.PubSubTopic, error) {
+	o.muStoreTopics.Lock()
+	defer o.muStoreTopics.Unlock()
+
+	if ref, ok := o.storeTopics[name]; ok {
+		ref.refs++
+		return ref.topic, nil
+	}
+
+	topic, err := o.pubsub.TopicSubscribe(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	o.storeTopics[name] = &storeTopicRef{topic: topic, refs: 1}
+
+	return topic, nil
+}
+
+// leaveStoreTopic Leaves the pubsub topic of a closed store when no other
+// store uses it, so the other peers are notified of the departure
+func (o *orbitDB) leaveStoreTopic(topic iface.PubSubTopic) {
+	o.muStoreTopics.Lock()
+	defer o.muStoreTopics.Unlock()
+
+	ref, ok := o.storeTopics[topic.Topic()]
+	if !ok || ref.topic != topic {
+		return
+	}
+
+	ref.refs--
+	if ref.refs > 0 {
+		return
+	}
+
+	delete(o.storeTopics, topic.Topic())
+
+	leaver, ok := o.pubsub.(iface.PubSubLeaveInterface)
+	if !ok {
+		return
+	}
+
+	if err := leaver.TopicLeave(o.ctx, topic.Topic()); err != nil {
+		o.logger.Debug("unable to leave topic", zap.String("topic", topic.Topic()), zap.Error(err))
+	}
+}
+
+// startStoreTopic Joins the topic of a store and, when it replicates,
+// listens to the heads of its peers and exchanges them periodically until
+// stopStoreTopic is called
+func (o *orbitDB) startStoreTopic(entry *storeEntry) error {
+	entry.muTopic.Lock()
+	defer entry.muTopic.Unlock()
+
+	if entry.topic != nil {
+		return nil
+	}
+
+	topic, err := o.joinStoreTopic(o.ctx, entry.topicName)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(o.ctx)
+
+	if entry.replicate {
+		if err := o.pubSubChanListener(ctx, entry.store, topic, entry.store.Address()); err != nil {
+			cancel()
+			o.leaveStoreTopic(topic)
+			return err
+		}
+
+		if o.antiEntropyInterval > 0 {
+			o.antiEntropy(ctx, entry.store, topic)
+		}
+	}
+
+	entry.topic, entry.stopTopic = topic, cancel
+
+	return nil
+}
+
+// stopStoreTopic Stops listening to the peers of a suspended or closed store
+// and leaves its topic, so its peers don't announce heads to it anymore
+func (o *orbitDB) stopStoreTopic(entry *storeEntry) {
+	entry.muTopic.Lock()
+	defer entry.muTopic.Unlock()
+
+	if entry.topic == nil {
+		return
+	}
+
+	entry.stopTopic()
+	o.leaveStoreTopic(entry.topic)
+
+	entry.topic, entry.stopTopic = nil, nil
+}
+
+func (o *orbitDB) onClose(store Store) error {
+	o.deleteStore(store.Address().String(), store)
+	o.forgetReconciliations(store.Address().String())
 
 	return nil
 }
 
-func (o *orbitDB) storeListener(ctx context.Context, store Store, topic iface.PubSubTopic) {
+func (o *orbitDB) storeListener(ctx context.Context, store Store, entry *storeEntry) {
+	var (
+		muPending    sync.Mutex
+		pendingHeads []ipfslog.Entry
+		pendingTimer *time.Timer
+		flushing     sync.WaitGroup
+	)
+
+	flushPending := func() {
+		muPending.Lock()
+		heads := pendingHeads
+		pendingHeads, pendingTimer = nil, nil
+		muPending.Unlock()
+
+		if topic := entry.currentTopic(); len(heads) > 0 && topic != nil {
+			o.publishHeads(ctx, store, topic, heads)
+		}
+	}
+
 	sub := store.Subscribe(ctx)
 	go func() {
 		for evt := range sub {
@@ -807,40 +1118,95 @@ func (o *orbitDB) storeListener(ctx context.Context, store Store, topic iface.Pu
 					continue
 				}
 
-				if topic != nil {
-					headsBytes, err := json.Marshal(e.Heads)
-					if err != nil {
-						o.logger.Debug(fmt.Sprintf("unable to serialize heads %v", err))
-						continue
-					}
-
-					if key := store.SharedKey(); key != nil {
-						headsBytes, err = key.Seal(headsBytes)
-						if err != nil {
-							o.logger.Error(fmt.Sprintf("unable to encrypt heads %v", err))
-							continue
-						}
-					}
-
-					err = topic.Publish(ctx, headsBytes)
-					if err != nil {
-						o.logger.Debug(fmt.Sprintf("unable to publish message on pubsub %v", err))
-						continue
+				if o.publishHeadsDebounce <= 0 {
+					if topic := entry.currentTopic(); topic != nil {
+						o.publishHeads(ctx, store, topic, e.Heads)
 					}
+					continue
+				}
 
-					o.logger.Debug("stores.write event: published event on pub sub")
+				// only the latest heads are relevant, announce them once
+				// the debounce window is over
+				muPending.Lock()
+				pendingHeads = e.Heads
+				if pendingTimer == nil {
+					flushing.Add(1)
+					pendingTimer = time.AfterFunc(o.publishHeadsDebounce, func() {
+						defer flushing.Done()
+						flushPending()
+					})
 				}
+				muPending.Unlock()
 			}
 		}
 
+		// the pending heads are announced before the store is closed, a
+		// timer which already fired is waited for
+		muPending.Lock()
+		stopped := pendingTimer != nil && pendingTimer.Stop()
+		muPending.Unlock()
+
+		if stopped {
+			flushing.Done()
+			flushPending()
+		}
+
+		flushing.Wait()
+
 		o.logger.Debug("received stores.close event")
 
-		if err := o.onClose(store.Address().GetRoot()); err != nil {
+		o.stopStoreTopic(entry)
+
+		if err := o.onClose(store); err != nil {
 			o.logger.Debug(fmt.Sprintf("unable to perform onClose %v", err))
 		}
 	}()
 }
 
+func (o *orbitDB) publishHeads(ctx context.Context, store Store, topic iface.PubSubTopic, heads []ipfslog.Entry) {
+	var headsBytes []byte
+	var err error
+
+	if o.binaryAnnouncements {
+		msg := &exchangedHeads{
+			Type:    messageTypeAnnounce,
+			Address: store.Address().String(),
+			Heads:   make([]*entry.Entry, 0, len(heads)),
+		}
+
+		for _, h := range heads {
+			if e, ok := h.(*entry.Entry); ok {
+				msg.Heads = append(msg.Heads, e)
+			}
+		}
+
+		headsBytes, err = encodeMessage(msg, true)
+	} else {
+		headsBytes, err = json.Marshal(heads)
+	}
+
+	if err != nil {
+		o.logger.Debug(fmt.Sprintf("unable to serialize heads %v", err))
+		return
+	}
+
+	if key := store.SharedKey(); key != nil {
+		headsBytes, err = key.Seal(headsBytes)
+		if err != nil {
+			o.logger.Error(fmt.Sprintf("unable to encrypt heads %v", err))
+			return
+		}
+	}
+
+	err = topic.Publish(ctx, headsBytes)
+	if err != nil {
+		o.logger.Debug(fmt.Sprintf("unable to publish message on pubsub %v", err))
+		return
+	}
+
+	o.logger.Debug("stores.write event: published event on pub sub")
+}
+
 func (o *orbitDB) pubSubChanListener(ctx context.Context, store Store, topic iface.PubSubTopic, addr address.Address) error {
 	chPeers, err := topic.WatchPeers(ctx)
 	if err != nil {
@@ -874,8 +1240,14 @@ func (o *orbitDB) pubSubChanListener(ctx context.Context, store Store, topic ifa
 		for evt := range chMessages {
 			o.logger.Debug("Got pub sub message")
 
+			guard := o.getHeadsGuard(addr.String())
+			if !guard.allowPeer(evt.From) {
+				o.logger.Debug(fmt.Sprintf("ignoring heads from peer %s for %s", evt.From.String(), addr))
+				store.Emit(ctx, stores.NewEventHeadsRejected(addr, evt.From, 0, stores.HeadsRejectedPeer))
+				continue
+			}
+
 			headsEntriesBytes := evt.Content
-			var headsEntries []*entry.Entry
 
 			if key := store.SharedKey(); key != nil {
 				headsEntriesBytes, err = key.Open(headsEntriesBytes)
@@ -885,17 +1257,30 @@ func (o *orbitDB) pubSubChanListener(ctx context.Context, store Store, topic ifa
 				}
 			}
 
-			err := json.Unmarshal(headsEntriesBytes, &headsEntries)
+			msg, _, err := decodeMessage(headsEntriesBytes)
 			if err != nil {
 				o.logger.Error("unable to unmarshal head entries", zap.Error(err))
 				continue
 			}
 
+			if msg.Type != messageTypeAnnounce {
+				o.logger.Debug(fmt.Sprintf("unexpected message type on pubsub: %s", msg.Type))
+				continue
+			}
+
+			headsEntries := msg.Heads
+
 			if len(headsEntries) == 0 {
 				o.logger.Debug(fmt.Sprintf("Nothing to synchronize for %s:", addr))
 				continue
 			}
 
+			if reason := guard.checkHeads(evt.From, len(headsEntries)); reason != "" {
+				o.logger.Debug(fmt.Sprintf("rejecting %d heads from peer %s for %s: %s", len(headsEntries), evt.From.String(), addr, reason))
+				store.Emit(ctx, stores.NewEventHeadsRejected(addr, evt.From, len(headsEntries), reason))
+				continue
+			}
+
 			o.logger.Debug(fmt.Sprintf("Received %d heads for %s:", len(headsEntries), addr))
 
 			entries := make([]ipfslog.Entry, len(headsEntries))
@@ -903,6 +1288,12 @@ func (o *orbitDB) pubSubChanListener(ctx context.Context, store Store, topic ifa
 				entries[i] = headsEntries[i]
 			}
 
+			// the heads received while the store is being suspended would
+			// resume it
+			if ctx.Err() != nil {
+				continue
+			}
+
 			if err := store.Sync(ctx, entries); err != nil {
 				o.logger.Debug(fmt.Sprintf("Error while syncing heads for %s:", addr))
 			}
@@ -913,6 +1304,10 @@ func (o *orbitDB) pubSubChanListener(ctx context.Context, store Store, topic ifa
 }
 
 func (o *orbitDB) onNewPeerJoined(ctx context.Context, p p2pcore.PeerID, store Store) {
+	if !o.getHeadsGuard(store.Address().String()).allowPeer(p) {
+		return
+	}
+
 	self, err := o.IPFS().Key().Self(ctx)
 	if err == nil {
 		o.logger.Debug(fmt.Sprintf("%s: New peer '%s' connected to %s", self.ID(), p, store.Address().String()))
@@ -931,9 +1326,7 @@ func (o *orbitDB) onNewPeerJoined(ctx context.Context, p p2pcore.PeerID, store S
 }
 
 func (o *orbitDB) exchangeHeads(ctx context.Context, p p2pcore.PeerID, store Store) (iface.DirectChannel, error) {
-	sharedKey := store.SharedKey()
-
-	channel, err := o.getDirectConnection(ctx, p, sharedKey)
+	channel, err := o.getDirectConnection(p)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get a connection to peer")
 	}
@@ -947,6 +1340,10 @@ func (o *orbitDB) exchangeHeads(ctx context.Context, p p2pcore.PeerID, store Sto
 
 	o.logger.Debug(fmt.Sprintf("connected to %s", p))
 
+	if err := o.sendHello(ctx, p, channel); err != nil {
+		return nil, errors.Wrap(err, "unable to say hello to peer")
+	}
+
 	untypedHeads := store.OpLog().Heads().Slice()
 	heads := make([]*entry.Entry, len(untypedHeads))
 	for i := range untypedHeads {
@@ -958,24 +1355,11 @@ func (o *orbitDB) exchangeHeads(ctx context.Context, p p2pcore.PeerID, store Sto
 		heads[i] = head
 	}
 
-	exchangedHeads := &exchangedHeads{
+	err = o.sendDirectMessage(ctx, p, channel, store, &exchangedHeads{
+		Type:    messageTypeHeads,
 		Address: store.Address().String(),
 		Heads:   heads,
-	}
-
-	exchangedHeadsBytes, err := json.Marshal(exchangedHeads)
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to serialize heads to exchange")
-	}
-
-	if sharedKey != nil {
-		exchangedHeadsBytes, err = sharedKey.Seal(exchangedHeadsBytes)
-		if err != nil {
-			return nil, errors.Wrap(err, "unable to encrypt payload")
-		}
-	}
-
-	err = channel.Send(ctx, exchangedHeadsBytes)
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to send heads on pubsub")
 	}
@@ -983,50 +1367,54 @@ func (o *orbitDB) exchangeHeads(ctx context.Context, p p2pcore.PeerID, store Sto
 	return channel, nil
 }
 
-func (o *orbitDB) watchOneOnOneMessage(ctx context.Context, channel iface.DirectChannel, sharedKey enc.SharedKey) {
+func (o *orbitDB) watchOneOnOneMessage(ctx context.Context, p p2pcore.PeerID, channel iface.DirectChannel) {
+	ctx, cancel := context.WithCancel(ctx)
+
 	sub := channel.Subscribe(ctx)
 	go func() {
+		defer cancel()
+
 		for evt := range sub {
 			o.logger.Debug("received one on one message")
 
 			switch e := evt.(type) {
 			case *iface.EventPubSubPayload:
-				heads := &exchangedHeads{}
-				payload := e.Payload
+				heads, store, err := o.openDirectMessage(e.Payload)
+				if err != nil {
+					o.logger.Error("unable to read direct message", zap.Error(err))
+					continue
+				}
 
-				if sharedKey != nil {
-					var err error
+				if heads.Type == messageTypeHello {
+					o.setCapabilities(p, heads.Capabilities)
 
-					payload, err = sharedKey.Open(payload)
-					if err != nil {
-						o.logger.Error("unable to decrypt payload", zap.Error(err))
-						continue
+					if err := o.sendHello(ctx, p, channel); err != nil {
+						o.logger.Error("unable to say hello to peer", zap.Error(err))
 					}
-				}
 
-				err := json.Unmarshal(payload, &heads)
-				if err != nil {
-					o.logger.Error("unable to unmarshal heads", zap.Error(err))
 					continue
 				}
 
-				o.logger.Debug(fmt.Sprintf("%s: Received %d heads for '%s':", o.PeerID().String(), len(heads.Heads), heads.Address))
-				store, ok := o.getStore(heads.Address)
-
-				if !ok {
-					o.logger.Debug("Heads from unknown store, skipping")
+				if store == nil {
+					o.logger.Debug("Message for unknown store, skipping")
 					continue
 				}
 
-				if len(heads.Heads) > 0 {
-					untypedHeads := make([]ipfslog.Entry, len(heads.Heads))
-					for i := range heads.Heads {
-						untypedHeads[i] = heads.Heads[i]
-					}
+				// stores sharing the channel must not wait for each other
+				go o.handleDirectMessage(ctx, p, channel, store, heads)
 
-					if err := store.Sync(ctx, untypedHeads); err != nil {
-						o.logger.Error("unable to sync heads", zap.Error(err))
-					}
+			case *iface.EventDirectChannelConnected:
+				o.logger.Debug(fmt.Sprintf("direct channel with %s connected", p.String()))
+
+			case *iface.EventDirectChannelDisconnected:
+				o.logger.Debug(fmt.Sprintf("direct channel with %s disconnected", p.String()))
+
+				// the peer may come back as a new instance
+				o.forgetPeer(p)
+
+				if e.Permanent {
+					o.evictDirectConnection(p, channel)
+					return
 				}
 
 			default:
@@ -1036,4 +1424,120 @@ func (o *orbitDB) watchOneOnOneMessage(ctx context.Context, channel iface.Direct
 	}()
 }
 
+// handleDirectMessage Handles a message received for a store on a direct
+// channel
+func (o *orbitDB) handleDirectMessage(ctx context.Context, p p2pcore.PeerID, channel iface.DirectChannel, store Store, heads *exchangedHeads) {
+	guard := o.getHeadsGuard(heads.Address)
+	if !guard.allowPeer(p) {
+		o.logger.Debug(fmt.Sprintf("ignoring message from peer %s for '%s'", p.String(), heads.Address))
+		store.Emit(ctx, stores.NewEventHeadsRejected(store.Address(), p, len(heads.Heads), stores.HeadsRejectedPeer))
+		return
+	}
+
+	switch heads.Type {
+	case messageTypeAck:
+		o.logger.Debug(fmt.Sprintf("%s acknowledged %d heads for '%s'", p.String(), len(heads.Acked), heads.Address))
+
+		acked := decodeCIDs(heads.Acked)
+		o.setKnownHeads(heads.Address, p, acked)
+		store.Emit(ctx, stores.NewEventHeadsAcknowledged(store.Address(), p, acked))
+
+		return
+
+	case messageTypeWantBlocks:
+		if err := o.serveBlocks(ctx, p, channel, store, heads.Wants); err != nil {
+			o.logger.Error("unable to serve blocks", zap.Error(err))
+		}
+
+		return
+
+	case messageTypeBlocks:
+		if err := o.receiveBlocks(ctx, p, channel, store, heads.Blocks, heads.Frontier); err != nil {
+			o.logger.Error("unable to receive blocks", zap.Error(err))
+		}
+
+		return
+
+	case messageTypeReconcile:
+		if err := o.serveReconciliation(ctx, p, channel, store, heads); err != nil {
+			o.logger.Error("unable to serve reconciliation", zap.Error(err))
+		}
+
+		return
+
+	case messageTypeMissing:
+		if err := o.receiveMissing(ctx, p, channel, store, heads.Missing); err != nil {
+			o.logger.Error("unable to fetch missing entries", zap.Error(err))
+		}
+
+		return
+	}
+
+	if reason := guard.checkHeads(p, len(heads.Heads)); reason != "" {
+		o.logger.Debug(fmt.Sprintf("rejecting %d heads from peer %s for '%s': %s", len(heads.Heads), p.String(), heads.Address, reason))
+		store.Emit(ctx, stores.NewEventHeadsRejected(store.Address(), p, len(heads.Heads), reason))
+		return
+	}
+
+	o.logger.Debug(fmt.Sprintf("%s: Received %d heads for '%s':", o.PeerID().String(), len(heads.Heads), heads.Address))
+
+	receivedHashes := make([]cid.Cid, len(heads.Heads))
+	for i, h := range heads.Heads {
+		receivedHashes[i] = h.GetHash()
+	}
+
+	o.setKnownHeads(heads.Address, p, receivedHashes)
+
+	// the histories differ, find the missing entries without walking them
+	if headsDigest(receivedHashes) != headsDigest(entriesHashes(store.OpLog().Heads().Slice())) && o.shouldReconcile(heads.Address, p) {
+		go func() {
+			if err := o.requestReconciliation(ctx, p, channel, store); err != nil {
+				o.logger.Error("unable to request reconciliation", zap.Error(err))
+			}
+		}()
+	}
+
+	if len(heads.Heads) == 0 {
+		return
+	}
+
+	untypedHeads := make([]ipfslog.Entry, len(heads.Heads))
+	var history []cid.Cid
+	for i := range heads.Heads {
+		untypedHeads[i] = heads.Heads[i]
+		history = append(history, heads.Heads[i].GetNext()...)
+		history = append(history, heads.Heads[i].GetRefs()...)
+	}
+
+	// ask the sender for the history while the heads are synced
+	go func() {
+		if err := o.requestBlocks(ctx, p, channel, store, history); err != nil {
+			o.logger.Error("unable to request blocks", zap.Error(err))
+		}
+	}()
+
+	if err := store.Sync(ctx, untypedHeads); err != nil {
+		o.logger.Error("unable to sync heads", zap.Error(err))
+		return
+	}
+
+	if !o.hasCapability(p, capabilityAck) {
+		return
+	}
+
+	acked := make([]string, len(heads.Heads))
+	for i, h := range heads.Heads {
+		acked[i] = h.GetHash().String()
+	}
+
+	err := o.sendDirectMessage(ctx, p, channel, store, &exchangedHeads{
+		Type:    messageTypeAck,
+		Address: heads.Address,
+		Acked:   acked,
+	})
+	if err != nil {
+		o.logger.Error("unable to acknowledge heads", zap.Error(err))
+	}
+}
+
 var _ BaseOrbitDB = &orbitDB{}
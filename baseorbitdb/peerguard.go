@@ -0,0 +1,256 @@
+package baseorbitdb
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"berty.tech/go-orbit-db/stores"
+	p2pcore "github.com/libp2p/go-libp2p-core"
+)
+
+// maxRateLimitedPeers The number of peers tracked by a rate limiter before
+// the ones with a full bucket are forgotten
+const maxRateLimitedPeers = 1024
+
+const (
+	// servingRateLimit The number of requests per second served to a peer,
+	// for the requests which make a store read its log or blocks
+	servingRateLimit = 2
+
+	// servingBurst The number of requests served to a peer at once
+	servingBurst = 8
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// headsGuard Filters the heads received for a store before they are synced,
+// according to the peer lists, the rate limit and the heads limit of the
+// store
+type headsGuard struct {
+	allowed  map[p2pcore.PeerID]struct{}
+	denied   map[p2pcore.PeerID]struct{}
+	rate     float64
+	burst    float64
+	maxHeads int
+
+	buckets   map[p2pcore.PeerID]*tokenBucket
+	muBuckets sync.Mutex
+}
+
+// newHeadsGuard Creates a guard from the options of a store, nil is returned
+// when the options don't restrict incoming heads
+func newHeadsGuard(options *CreateDBOptions) *headsGuard {
+	if len(options.AllowedPeers) == 0 && len(options.DeniedPeers) == 0 && options.IncomingHeadsRateLimit <= 0 && options.MaxIncomingHeads <= 0 {
+		return nil
+	}
+
+	g := &headsGuard{
+		denied:   map[p2pcore.PeerID]struct{}{},
+		maxHeads: options.MaxIncomingHeads,
+		buckets:  map[p2pcore.PeerID]*tokenBucket{},
+	}
+
+	if len(options.AllowedPeers) > 0 {
+		g.allowed = map[p2pcore.PeerID]struct{}{}
+		for _, p := range options.AllowedPeers {
+			g.allowed[p] = struct{}{}
+		}
+	}
+
+	for _, p := range options.DeniedPeers {
+		g.denied[p] = struct{}{}
+	}
+
+	if options.IncomingHeadsRateLimit > 0 {
+		g.rate = options.IncomingHeadsRateLimit
+		g.burst = float64(options.IncomingHeadsBurst)
+
+		if g.burst < 1 {
+			g.burst = math.Max(1, math.Ceil(g.rate))
+		}
+	}
+
+	return g
+}
+
+// newServingGuard Creates the guard limiting the requests served to each
+// peer, whatever the options of the store
+func newServingGuard() *headsGuard {
+	return &headsGuard{
+		rate:    servingRateLimit,
+		burst:   servingBurst,
+		buckets: map[p2pcore.PeerID]*tokenBucket{},
+	}
+}
+
+// allowPeer Returns whether messages from the given peer are accepted, an
+// unknown sender is only accepted when there is no allowlist
+func (g *headsGuard) allowPeer(p p2pcore.PeerID) bool {
+	if g == nil {
+		return true
+	}
+
+	if _, ok := g.denied[p]; ok {
+		return false
+	}
+
+	if g.allowed == nil {
+		return true
+	}
+
+	_, ok := g.allowed[p]
+
+	return ok
+}
+
+// deniedPeer Returns whether the given peer is in the deny list, unlike
+// allowPeer the allowlist isn't checked
+func (g *headsGuard) deniedPeer(p p2pcore.PeerID) bool {
+	if g == nil {
+		return false
+	}
+
+	_, ok := g.denied[p]
+
+	return ok
+}
+
+// allowHeads Returns whether a message with the given number of heads from a
+// peer is accepted, consuming a token of the peer when rate limited
+func (g *headsGuard) allowHeads(p p2pcore.PeerID, count int) bool {
+	return g.checkHeads(p, count) == ""
+}
+
+// checkHeads Returns why a message with the given number of heads from a peer
+// is rejected, or an empty reason when it is accepted, consuming a token of
+// the peer when rate limited
+func (g *headsGuard) checkHeads(p p2pcore.PeerID, count int) stores.HeadsRejectReason {
+	if g == nil {
+		return ""
+	}
+
+	if !g.allowPeer(p) {
+		return stores.HeadsRejectedPeer
+	}
+
+	if g.maxHeads > 0 && count > g.maxHeads {
+		return stores.HeadsRejectedTooMany
+	}
+
+	if !g.takeToken(p) {
+		return stores.HeadsRejectedRateLimit
+	}
+
+	return ""
+}
+
+// allowBlocks Returns whether a message of blocks from a peer is accepted,
+// consuming a token of the peer when rate limited
+func (g *headsGuard) allowBlocks(p p2pcore.PeerID) bool {
+	if g == nil {
+		return true
+	}
+
+	if !g.allowPeer(p) {
+		return false
+	}
+
+	return g.takeToken(p)
+}
+
+// allowServing Returns whether a request of a peer served from the log of a
+// store is accepted, the request is charged to the guard of the store and to
+// the serving guard
+func (o *orbitDB) allowServing(address string, p p2pcore.PeerID) bool {
+	if !o.getHeadsGuard(address).allowBlocks(p) {
+		return false
+	}
+
+	return o.servingGuard.takeToken(p)
+}
+
+// takeToken Consumes a token of the peer, returns false when its bucket is
+// empty
+func (g *headsGuard) takeToken(p p2pcore.PeerID) bool {
+	if g.rate <= 0 {
+		return true
+	}
+
+	g.muBuckets.Lock()
+	defer g.muBuckets.Unlock()
+
+	now := time.Now()
+
+	b, ok := g.buckets[p]
+	if !ok {
+		if len(g.buckets) >= maxRateLimitedPeers {
+			g.prune(now)
+		}
+
+		b = &tokenBucket{tokens: g.burst, last: now}
+		g.buckets[p] = b
+	}
+
+	b.tokens = math.Min(g.burst, b.tokens+now.Sub(b.last).Seconds()*g.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// prune Forgets the peers whose bucket would be full by now
+func (g *headsGuard) prune(now time.Time) {
+	for p, b := range g.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*g.rate >= g.burst {
+			delete(g.buckets, p)
+		}
+	}
+}
+
+// setHeadsGuard Registers the guard of a store until the store is closed
+func (o *orbitDB) setHeadsGuard(store Store, g *headsGuard) {
+	if g == nil {
+		return
+	}
+
+	addr := store.Address().String()
+
+	o.muHeadsGuards.Lock()
+	o.headsGuards[addr] = g
+	o.muHeadsGuards.Unlock()
+
+	ctx, cancel := context.WithCancel(o.ctx)
+	sub := store.Subscribe(ctx)
+
+	go func() {
+		defer cancel()
+
+		for range sub {
+		}
+
+		o.muHeadsGuards.Lock()
+		defer o.muHeadsGuards.Unlock()
+
+		if o.headsGuards[addr] == g {
+			delete(o.headsGuards, addr)
+		}
+	}()
+}
+
+// getHeadsGuard Returns the guard of a store, nil if it has none
+func (o *orbitDB) getHeadsGuard(address string) *headsGuard {
+	o.muHeadsGuards.RLock()
+	defer o.muHeadsGuards.RUnlock()
+
+	return o.headsGuards[address]
+}
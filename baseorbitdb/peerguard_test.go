@@ -0,0 +1,79 @@
+package baseorbitdb
+
+import (
+	"testing"
+	"time"
+
+	"berty.tech/go-orbit-db/stores"
+	p2pcore "github.com/libp2p/go-libp2p-core"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeadsGuardPeers(t *testing.T) {
+	alice, bob, carol := p2pcore.PeerID("alice"), p2pcore.PeerID("bob"), p2pcore.PeerID("carol")
+
+	require.Nil(t, newHeadsGuard(&CreateDBOptions{}))
+
+	var noGuard *headsGuard
+	require.True(t, noGuard.allowPeer(alice))
+	require.True(t, noGuard.allowHeads(alice, 1000))
+	require.False(t, noGuard.deniedPeer(alice))
+
+	denied := newHeadsGuard(&CreateDBOptions{DeniedPeers: []p2pcore.PeerID{alice}})
+	require.Equal(t, stores.HeadsRejectedPeer, denied.checkHeads(alice, 1))
+	require.Equal(t, stores.HeadsRejectReason(""), denied.checkHeads(bob, 1))
+
+	// the denied peers win over the allowed ones
+	allowed := newHeadsGuard(&CreateDBOptions{AllowedPeers: []p2pcore.PeerID{alice, bob}, DeniedPeers: []p2pcore.PeerID{bob}})
+	require.True(t, allowed.allowPeer(alice))
+	require.False(t, allowed.allowPeer(bob))
+	require.False(t, allowed.allowPeer(carol))
+
+	// only the deny list is checked by deniedPeer
+	require.True(t, allowed.deniedPeer(bob))
+	require.False(t, allowed.deniedPeer(carol))
+}
+
+func TestHeadsGuardMaxHeads(t *testing.T) {
+	p := p2pcore.PeerID("alice")
+	g := newHeadsGuard(&CreateDBOptions{MaxIncomingHeads: 3})
+
+	require.Equal(t, stores.HeadsRejectReason(""), g.checkHeads(p, 0))
+	require.Equal(t, stores.HeadsRejectReason(""), g.checkHeads(p, 3))
+	require.Equal(t, stores.HeadsRejectedTooMany, g.checkHeads(p, 4))
+
+	// the limit applies to each message, not to the peer
+	require.True(t, g.allowHeads(p, 3))
+}
+
+func TestHeadsGuardRateLimit(t *testing.T) {
+	alice, bob := p2pcore.PeerID("alice"), p2pcore.PeerID("bob")
+	g := newHeadsGuard(&CreateDBOptions{IncomingHeadsRateLimit: 1, IncomingHeadsBurst: 2})
+
+	// the burst is available at once
+	require.True(t, g.allowHeads(alice, 1))
+	require.True(t, g.allowHeads(alice, 1))
+	require.Equal(t, stores.HeadsRejectedRateLimit, g.checkHeads(alice, 1))
+
+	// other peers have their own bucket
+	require.True(t, g.allowHeads(bob, 1))
+
+	// tokens are refilled over time, up to the burst
+	g.muBuckets.Lock()
+	g.buckets[alice].last = g.buckets[alice].last.Add(-time.Second)
+	g.muBuckets.Unlock()
+
+	require.True(t, g.allowHeads(alice, 1))
+	require.False(t, g.allowHeads(alice, 1))
+
+	g.muBuckets.Lock()
+	g.buckets[alice].last = g.buckets[alice].last.Add(-time.Hour)
+	g.muBuckets.Unlock()
+
+	require.True(t, g.allowHeads(alice, 1))
+	require.True(t, g.allowHeads(alice, 1))
+	require.False(t, g.allowHeads(alice, 1))
+
+	// blocks use the same bucket
+	require.False(t, g.allowBlocks(alice))
+}
@@ -0,0 +1,293 @@
+package baseorbitdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"berty.tech/go-orbit-db/iface"
+	cid "github.com/ipfs/go-cid"
+	p2pcore "github.com/libp2p/go-libp2p-core"
+	"github.com/pkg/errors"
+)
+
+const (
+	// messageTypeReconcile Carries a Bloom filter of the entries of the
+	// sender, the receiver replies with the entries missing from it
+	messageTypeReconcile = "reconcile"
+
+	// messageTypeMissing Lists the entries missing from a Bloom filter
+	messageTypeMissing = "missing"
+
+	// capabilityReconcile The peer handles messageTypeReconcile
+	capabilityReconcile = "reconcile"
+)
+
+const (
+	// reconcileFalsePositiveRate The false positive rate of the Bloom
+	// filters, entries hidden by a false positive are still reached by
+	// following the history of the heads
+	reconcileFalsePositiveRate = 0.01
+
+	// reconcileMaxMissing The maximum number of hashes in a single reply
+	reconcileMaxMissing = 4096
+
+	// reconcileMinInterval The minimum delay between two reconciliations of
+	// a store with the same peer
+	reconcileMinInterval = time.Second * 30
+
+	// maxBloomFilterSize The maximum size in bytes of a Bloom filter
+	maxBloomFilterSize = 4 << 20
+
+	// maxBloomFilterHashes The maximum number of hash functions of a Bloom
+	// filter, the optimal number for the false positive rate is 7
+	maxBloomFilterHashes = 32
+)
+
+// bloomFilter A Bloom filter of CIDs
+type bloomFilter struct {
+	bits   []byte
+	hashes int
+}
+
+// newBloomFilter Creates a Bloom filter sized for the given number of items
+func newBloomFilter(count int) *bloomFilter {
+	if count < 1 {
+		count = 1
+	}
+
+	m := math.Ceil(-float64(count) * math.Log(reconcileFalsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / float64(count) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	size := int(math.Ceil(m / 8))
+	if size > maxBloomFilterSize {
+		size = maxBloomFilterSize
+	}
+
+	return &bloomFilter{
+		bits:   make([]byte, size),
+		hashes: k,
+	}
+}
+
+// locations Returns the bits set for a CID, using double hashing
+func (f *bloomFilter) locations(c cid.Cid) []uint64 {
+	sum := sha256.Sum256(c.Bytes())
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16])
+	m := uint64(len(f.bits)) * 8
+
+	locations := make([]uint64, f.hashes)
+	for i := range locations {
+		locations[i] = (h1 + uint64(i)*h2) % m
+	}
+
+	return locations
+}
+
+func (f *bloomFilter) add(c cid.Cid) {
+	for _, l := range f.locations(c) {
+		f.bits[l/8] |= 1 << (l % 8)
+	}
+}
+
+func (f *bloomFilter) has(c cid.Cid) bool {
+	if len(f.bits) == 0 {
+		return false
+	}
+
+	for _, l := range f.locations(c) {
+		if f.bits[l/8]&(1<<(l%8)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// shouldReconcile Returns whether a reconciliation of a store with a peer
+// can be started, at most once every reconcileMinInterval
+func (o *orbitDB) shouldReconcile(address string, p p2pcore.PeerID) bool {
+	if o.disableReconciliation || !o.hasCapability(p, capabilityReconcile) {
+		return false
+	}
+
+	o.muReconcile.Lock()
+	defer o.muReconcile.Unlock()
+
+	peers, ok := o.lastReconcile[address]
+	if !ok {
+		peers = map[p2pcore.PeerID]time.Time{}
+		o.lastReconcile[address] = peers
+	}
+
+	if last, ok := peers[p]; ok && time.Since(last) < reconcileMinInterval {
+		return false
+	}
+
+	peers[p] = time.Now()
+
+	return true
+}
+
+// forgetReconciliations Drops the reconciliations of a store, started or
+// requested, once it is closed
+func (o *orbitDB) forgetReconciliations(address string) {
+	o.muReconcile.Lock()
+	defer o.muReconcile.Unlock()
+
+	delete(o.lastReconcile, address)
+
+	for p, requests := range o.reconcileRequests {
+		delete(requests, address)
+		if len(requests) == 0 {
+			delete(o.reconcileRequests, p)
+		}
+	}
+}
+
+// requestReconciliation Sends a Bloom filter of the entries of a store to a
+// peer, which replies with the entries it has and the filter doesn't
+func (o *orbitDB) requestReconciliation(ctx context.Context, p p2pcore.PeerID, channel iface.DirectChannel, store Store) error {
+	entries := store.OpLog().GetEntries().Slice()
+
+	filter := newBloomFilter(len(entries))
+	for _, e := range entries {
+		filter.add(e.GetHash())
+	}
+
+	// the reply is only accepted once requested
+	o.addReconcileRequest(store.Address().String(), p)
+
+	return o.sendDirectMessage(ctx, p, channel, store, &exchangedHeads{
+		Type:         messageTypeReconcile,
+		Address:      store.Address().String(),
+		Filter:       filter.bits,
+		FilterHashes: filter.hashes,
+	})
+}
+
+// serveReconciliation Replies to a reconciliation request with the entries
+// missing from the received Bloom filter, within the rate limit of the peer
+func (o *orbitDB) serveReconciliation(ctx context.Context, p p2pcore.PeerID, channel iface.DirectChannel, store Store, msg *exchangedHeads) error {
+	if !o.allowServing(store.Address().String(), p) {
+		return errors.New(fmt.Sprintf("rejecting reconciliation request from peer %s", p.String()))
+	}
+
+	if msg.FilterHashes < 1 || msg.FilterHashes > maxBloomFilterHashes || len(msg.Filter) == 0 || len(msg.Filter) > maxBloomFilterSize {
+		return errors.New(fmt.Sprintf("invalid bloom filter, %d hashes and %d bytes", msg.FilterHashes, len(msg.Filter)))
+	}
+
+	filter := &bloomFilter{
+		bits:   msg.Filter,
+		hashes: msg.FilterHashes,
+	}
+
+	var missing []string
+	for _, e := range store.OpLog().GetEntries().Slice() {
+		if filter.has(e.GetHash()) {
+			continue
+		}
+
+		missing = append(missing, e.GetHash().String())
+		if len(missing) >= reconcileMaxMissing {
+			break
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	return o.sendDirectMessage(ctx, p, channel, store, &exchangedHeads{
+		Type:    messageTypeMissing,
+		Address: store.Address().String(),
+		Missing: missing,
+	})
+}
+
+// addReconcileRequest Records a reconciliation requested from a peer
+func (o *orbitDB) addReconcileRequest(address string, p p2pcore.PeerID) {
+	o.muReconcile.Lock()
+	defer o.muReconcile.Unlock()
+
+	requests, ok := o.reconcileRequests[p]
+	if !ok {
+		requests = map[string]time.Time{}
+		o.reconcileRequests[p] = requests
+	}
+
+	requests[address] = time.Now()
+}
+
+// takeReconcileRequest Returns whether a reconciliation of a store has been
+// requested from a peer recently, a request is only answered once
+func (o *orbitDB) takeReconcileRequest(address string, p p2pcore.PeerID) bool {
+	o.muReconcile.Lock()
+	defer o.muReconcile.Unlock()
+
+	requests := o.reconcileRequests[p]
+
+	sent, ok := requests[address]
+	if !ok {
+		return false
+	}
+
+	delete(requests, address)
+	if len(requests) == 0 {
+		delete(o.reconcileRequests, p)
+	}
+
+	return time.Since(sent) < reconcileMinInterval
+}
+
+// receiveMissing Fetches the entries a peer found missing from the store,
+// from the peer itself when it serves blocks. Only the replies to a
+// reconciliation request are accepted, within the limits of the heads
+func (o *orbitDB) receiveMissing(ctx context.Context, p p2pcore.PeerID, channel iface.DirectChannel, store Store, missing []string) error {
+	address := store.Address().String()
+
+	if !o.takeReconcileRequest(address, p) {
+		return errors.New(fmt.Sprintf("unrequested missing entries from peer %s", p.String()))
+	}
+
+	guard := o.getHeadsGuard(address)
+
+	// the entries left out are still reached by following the heads
+	if len(missing) > reconcileMaxMissing {
+		missing = missing[:reconcileMaxMissing]
+	}
+
+	if guard != nil && guard.maxHeads > 0 && len(missing) > guard.maxHeads {
+		missing = missing[:guard.maxHeads]
+	}
+
+	if !guard.allowHeads(p, len(missing)) {
+		return errors.New(fmt.Sprintf("rejecting %d missing entries from peer %s", len(missing), p.String()))
+	}
+
+	var hashes []cid.Cid
+	for _, c := range decodeCIDs(missing) {
+		if _, ok := store.OpLog().Get(c); !ok {
+			hashes = append(hashes, c)
+		}
+	}
+
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	if err := o.requestBlocks(ctx, p, channel, store, hashes); err != nil {
+		return errors.Wrap(err, "unable to request missing blocks")
+	}
+
+	store.Replicator().Load(ctx, hashes)
+
+	return nil
+}
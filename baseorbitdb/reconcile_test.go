@@ -0,0 +1,237 @@
+package baseorbitdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	p2pcore "github.com/libp2p/go-libp2p-core"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomFilter(t *testing.T) {
+	count := 10000
+
+	filter := newBloomFilter(count)
+	require.LessOrEqual(t, filter.hashes, maxBloomFilterHashes)
+
+	for i := 0; i < count; i++ {
+		filter.add(testingCID(t, fmt.Sprintf("member%d", i)))
+	}
+
+	// there are no false negatives
+	for i := 0; i < count; i++ {
+		require.True(t, filter.has(testingCID(t, fmt.Sprintf("member%d", i))))
+	}
+
+	falsePositives := 0
+	for i := 0; i < count; i++ {
+		if filter.has(testingCID(t, fmt.Sprintf("other%d", i))) {
+			falsePositives++
+		}
+	}
+
+	require.Less(t, float64(falsePositives)/float64(count), reconcileFalsePositiveRate*2)
+}
+
+func TestBloomFilterSize(t *testing.T) {
+	cases := []struct {
+		count int
+	}{
+		{count: -1},
+		{count: 0},
+		{count: 1},
+		{count: 1000},
+		{count: 1 << 30},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%d items", c.count), func(t *testing.T) {
+			filter := newBloomFilter(c.count)
+
+			require.NotEmpty(t, filter.bits)
+			require.LessOrEqual(t, len(filter.bits), maxBloomFilterSize)
+			require.GreaterOrEqual(t, filter.hashes, 1)
+			require.LessOrEqual(t, filter.hashes, maxBloomFilterHashes)
+
+			member := testingCID(t, "member")
+			filter.add(member)
+			require.True(t, filter.has(member))
+		})
+	}
+
+	require.Len(t, newBloomFilter(1<<30).bits, maxBloomFilterSize)
+
+	// an empty filter has no members
+	require.False(t, (&bloomFilter{hashes: 1}).has(testingCID(t, "member")))
+}
+
+func TestReconciliationRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o, clean := testingOrbitDB(ctx, t, mocknet.New(ctx), nil)
+	defer clean()
+
+	store := testingEventLog(ctx, t, o, "reconciliation", nil)
+	defer store.Close()
+
+	addr := store.Address().String()
+	p := p2pcore.PeerID("peer")
+
+	_, err := store.Add(ctx, []byte("hello0"))
+	require.NoError(t, err)
+
+	// the peer sends a filter of its entries, missing the next ones
+	requests := &testingDirectChannel{}
+	require.NoError(t, o.requestReconciliation(ctx, p, requests, store))
+
+	sent := requests.messages(t)
+	require.Len(t, sent, 1)
+	require.Equal(t, messageTypeReconcile, sent[0].Type)
+	require.Equal(t, addr, sent[0].Address)
+
+	var expected []string
+	for i := 1; i < 4; i++ {
+		op, err := store.Add(ctx, []byte(fmt.Sprintf("hello%d", i)))
+		require.NoError(t, err)
+
+		expected = append(expected, op.GetEntry().GetHash().String())
+	}
+
+	replies := &testingDirectChannel{}
+	require.NoError(t, o.serveReconciliation(ctx, p, replies, store, sent[0]))
+
+	received := replies.messages(t)
+	require.Len(t, received, 1)
+	require.Equal(t, messageTypeMissing, received[0].Type)
+	require.Equal(t, addr, received[0].Address)
+	require.ElementsMatch(t, expected, received[0].Missing)
+
+	// the reply is accepted once, as it has been requested
+	require.NoError(t, o.receiveMissing(ctx, p, replies, store, received[0].Missing))
+	require.Error(t, o.receiveMissing(ctx, p, replies, store, received[0].Missing))
+
+	// and the replies of other peers are rejected
+	require.Error(t, o.receiveMissing(ctx, p2pcore.PeerID("other"), replies, store, received[0].Missing))
+
+	// nothing is missing from a filter of all the entries
+	replies = &testingDirectChannel{}
+	require.NoError(t, o.requestReconciliation(ctx, p, requests, store))
+	require.NoError(t, o.serveReconciliation(ctx, p, replies, store, requests.messages(t)[1]))
+	require.Len(t, replies.messages(t), 0)
+}
+
+func TestServeReconciliationLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o, clean := testingOrbitDB(ctx, t, mocknet.New(ctx), nil)
+	defer clean()
+
+	store := testingEventLog(ctx, t, o, "reconciliation-limits", nil)
+	defer store.Close()
+
+	channel := &testingDirectChannel{}
+
+	invalid := []*exchangedHeads{
+		{Type: messageTypeReconcile, Filter: []byte{0x01}, FilterHashes: 0},
+		{Type: messageTypeReconcile, Filter: []byte{0x01}, FilterHashes: maxBloomFilterHashes + 1},
+		{Type: messageTypeReconcile, FilterHashes: 1},
+		{Type: messageTypeReconcile, Filter: make([]byte, maxBloomFilterSize+1), FilterHashes: 1},
+	}
+
+	for i, msg := range invalid {
+		require.Error(t, o.serveReconciliation(ctx, p2pcore.PeerID(fmt.Sprintf("peer%d", i)), channel, store, msg))
+	}
+
+	// the requests of a peer are rate limited
+	p := p2pcore.PeerID("requester")
+	valid := &exchangedHeads{Type: messageTypeReconcile, Filter: []byte{0x01}, FilterHashes: 1}
+
+	for i := 0; i < servingBurst; i++ {
+		require.NoError(t, o.serveReconciliation(ctx, p, channel, store, valid))
+	}
+
+	require.Error(t, o.serveReconciliation(ctx, p, channel, store, valid))
+}
+
+func TestReconciliationForgotten(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o, clean := testingOrbitDB(ctx, t, mocknet.New(ctx), nil)
+	defer clean()
+
+	store := testingEventLog(ctx, t, o, "reconciliation-forgotten", nil)
+
+	addr := store.Address().String()
+	alice, bob := p2pcore.PeerID("alice"), p2pcore.PeerID("bob")
+
+	o.setCapabilities(alice, []string{capabilityReconcile})
+	o.setCapabilities(bob, []string{capabilityReconcile})
+
+	reconciled := func(p p2pcore.PeerID) bool {
+		o.muReconcile.Lock()
+		defer o.muReconcile.Unlock()
+
+		_, ok := o.lastReconcile[addr][p]
+		return ok
+	}
+
+	require.True(t, o.shouldReconcile(addr, alice))
+	require.False(t, o.shouldReconcile(addr, alice))
+	require.True(t, o.shouldReconcile(addr, bob))
+
+	// the reconciliations with a peer are forgotten once disconnected
+	o.forgetPeer(alice)
+	require.False(t, reconciled(alice))
+	require.True(t, reconciled(bob))
+
+	o.setCapabilities(alice, []string{capabilityReconcile})
+	require.True(t, o.shouldReconcile(addr, alice))
+
+	// and the ones of a store once closed
+	o.addReconcileRequest(addr, bob)
+	require.NoError(t, store.Close())
+
+	require.Eventually(t, func() bool {
+		o.muReconcile.Lock()
+		defer o.muReconcile.Unlock()
+
+		_, started := o.lastReconcile[addr]
+		_, requested := o.reconcileRequests[bob]
+
+		return !started && !requested
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestReceiveMissingUnknownEntries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o, clean := testingOrbitDB(ctx, t, mocknet.New(ctx), nil)
+	defer clean()
+
+	store := testingEventLog(ctx, t, o, "reconciliation-unknown", nil)
+	defer store.Close()
+
+	p := p2pcore.PeerID("peer")
+	unknown := testingCID(t, "unknown")
+
+	o.addReconcileRequest(store.Address().String(), p)
+	require.NoError(t, o.receiveMissing(ctx, p, &testingDirectChannel{}, store, []string{unknown.String(), "invalid"}))
+
+	// the entries missing from the log are replicated
+	require.Eventually(t, func() bool {
+		for _, c := range store.Replicator().GetPending() {
+			if c.Equals(unknown) {
+				return true
+			}
+		}
+
+		return false
+	}, time.Second*5, time.Millisecond*10)
+}
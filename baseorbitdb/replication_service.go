@@ -0,0 +1,232 @@
+package baseorbitdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"berty.tech/go-orbit-db/address"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores"
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	ipfspath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Follow Opens a store as a headless store, replicating it and answering the
+// heads exchanges of its peers without indexing it. A store already open with
+// its type is returned as is
+func (o *orbitDB) Follow(ctx context.Context, dbAddress string, options *CreateDBOptions) (Store, error) {
+	if err := address.IsValid(dbAddress); err != nil {
+		return nil, errors.Wrap(err, "unable to follow an invalid address")
+	}
+
+	if options == nil {
+		options = &CreateDBOptions{}
+	}
+
+	options.Headless = true
+	options.Replicate = boolPtr(true)
+
+	_, alreadyOpen := o.getStore(dbAddress)
+
+	store, err := o.Open(ctx, dbAddress, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open store")
+	}
+
+	if alreadyOpen {
+		return store, nil
+	}
+
+	if err := store.Load(ctx, -1); err != nil {
+		o.logger.Debug("unable to load followed store", zap.String("address", dbAddress), zap.Error(err))
+	}
+
+	o.logger.Debug(fmt.Sprintf("following %s", dbAddress))
+
+	return store, nil
+}
+
+// StartReplicationService Follows the configured stores and the stores
+// announced on the control topic, until the context is done. The stores
+// opened by the service are closed along with it
+func (o *orbitDB) StartReplicationService(ctx context.Context, options *ReplicationServiceOptions) error {
+	if options == nil {
+		options = &ReplicationServiceOptions{}
+	}
+
+	var chMessages <-chan *iface.EventPubSubMessage
+
+	if options.ControlTopic != "" {
+		// anyone can publish on a topic, the peers to listen to are chosen
+		// explicitly
+		if len(options.ControlTopicPeers) == 0 && !options.ControlTopicAllowAll {
+			return errors.New("the control topic requires ControlTopicPeers or ControlTopicAllowAll")
+		}
+
+		topic, err := o.pubsub.TopicSubscribe(ctx, options.ControlTopic)
+		if err != nil {
+			return errors.Wrap(err, "unable to subscribe to control topic")
+		}
+
+		chMessages, err = topic.WatchMessages(ctx)
+		if err != nil {
+			return errors.Wrap(err, "unable to watch control topic")
+		}
+	}
+
+	var (
+		followed   = map[string]struct{}{}
+		muFollowed sync.Mutex
+	)
+
+	// an address is only followed once by the service, announcing it again
+	// doesn't take another reference on its store
+	follow := func(dbAddress string) {
+		muFollowed.Lock()
+		if _, ok := followed[dbAddress]; ok {
+			muFollowed.Unlock()
+			return
+		}
+		followed[dbAddress] = struct{}{}
+		muFollowed.Unlock()
+
+		if err := o.followForService(ctx, dbAddress, options); err != nil {
+			o.logger.Error("unable to follow store", zap.String("address", dbAddress), zap.Error(err))
+
+			// the address is followed again when it is announced again
+			muFollowed.Lock()
+			delete(followed, dbAddress)
+			muFollowed.Unlock()
+		}
+	}
+
+	for _, addr := range options.Addresses {
+		follow(addr)
+	}
+
+	if chMessages == nil {
+		return nil
+	}
+
+	allowed := map[peer.ID]struct{}{}
+	for _, p := range options.ControlTopicPeers {
+		allowed[p] = struct{}{}
+	}
+
+	go func() {
+		for evt := range chMessages {
+			if _, ok := allowed[evt.From]; !ok && !options.ControlTopicAllowAll {
+				o.logger.Debug(fmt.Sprintf("ignoring address announced by %s", evt.From.String()))
+				continue
+			}
+
+			go follow(strings.TrimSpace(string(evt.Content)))
+		}
+	}()
+
+	return nil
+}
+
+// followForService Follows a store of a replication service, and pins its
+// entries unless disabled
+func (o *orbitDB) followForService(ctx context.Context, dbAddress string, options *ReplicationServiceOptions) error {
+	var storeOptions *CreateDBOptions
+	if options.StoreOptions != nil {
+		storeOptions = options.StoreOptions(dbAddress)
+	}
+
+	store, err := o.Follow(ctx, dbAddress, storeOptions)
+	if err != nil {
+		return err
+	}
+
+	// releases the reference taken by the service, the store stays open
+	// while other holders use it
+	go func() {
+		<-ctx.Done()
+
+		if err := store.Close(); err != nil {
+			o.logger.Debug("unable to close followed store", zap.String("address", dbAddress), zap.Error(err))
+		}
+	}()
+
+	if !options.DisablePinning {
+		o.pinStore(store)
+	}
+
+	return nil
+}
+
+// pinStore Pins the manifest of a store and its entries as they are
+// replicated, until the store is closed
+func (o *orbitDB) pinStore(store Store) {
+	ctx, cancel := context.WithCancel(o.ctx)
+	sub := store.Subscribe(ctx)
+
+	pinned := map[cid.Cid]struct{}{}
+
+	pin := func(c cid.Cid, recursive bool) {
+		if _, ok := pinned[c]; ok {
+			return
+		}
+
+		if err := o.IPFS().Pin().Add(ctx, ipfspath.IpfsPath(c), options.Pin.Recursive(recursive)); err != nil {
+			o.logger.Debug("unable to pin", zap.String("cid", c.String()), zap.Error(err))
+			return
+		}
+
+		pinned[c] = struct{}{}
+	}
+
+	// walks the log from its heads down to the entries already pinned, so
+	// only the new entries are visited. Entry links may be sealed, each
+	// entry is pinned on its own
+	pinEntries := func() {
+		oplog := store.OpLog()
+		stack := oplog.Heads().Slice()
+		visited := map[cid.Cid]struct{}{}
+
+		for len(stack) > 0 {
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			h := e.GetHash()
+			if _, ok := pinned[h]; ok {
+				continue
+			}
+
+			if _, ok := visited[h]; ok {
+				continue
+			}
+			visited[h] = struct{}{}
+
+			pin(h, false)
+
+			for _, next := range e.GetNext() {
+				if parent, ok := oplog.Get(next); ok {
+					stack = append(stack, parent)
+				}
+			}
+		}
+	}
+
+	go func() {
+		defer cancel()
+
+		pin(store.Address().GetRoot(), true)
+		pinEntries()
+
+		for evt := range sub {
+			switch evt.(type) {
+			case *stores.EventReplicated, *stores.EventReady, *stores.EventWrite:
+				pinEntries()
+			}
+		}
+	}()
+}
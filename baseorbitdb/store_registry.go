@@ -0,0 +1,472 @@
+package baseorbitdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"berty.tech/go-ipfs-log/enc"
+	"berty.tech/go-orbit-db/accesscontroller"
+	"berty.tech/go-orbit-db/cache/cacheleveldown"
+	"berty.tech/go-orbit-db/iface"
+	p2pcore "github.com/libp2p/go-libp2p-core"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// storeEntry An open store, the number of holders which haven't closed it
+// yet and when it was last opened or used. The type of the store, whether
+// it is headless and the options it was opened with are checked against the
+// options of the next holders. The topic is joined while the store is
+// neither suspended nor closed
+type storeEntry struct {
+	store     Store
+	refs      int
+	lastUsed  time.Time
+	storeType string
+	headless  bool
+	options   CreateDBOptions
+
+	muTopic   sync.Mutex
+	topicName string
+	topic     iface.PubSubTopic
+	stopTopic context.CancelFunc
+	replicate bool
+}
+
+// currentTopic Returns the topic joined by the store, nil while it is
+// suspended or once closed
+func (e *storeEntry) currentTopic() iface.PubSubTopic {
+	e.muTopic.Lock()
+	defer e.muTopic.Unlock()
+
+	return e.topic
+}
+
+// storeEvictionRetryInterval The maximum delay before suspending again the
+// stores which were busy when they exceeded the maximum number of open stores
+const storeEvictionRetryInterval = time.Second
+
+// openLock Serializes the opening of a store address, waiters counts the
+// callers holding or waiting for the lock
+type openLock struct {
+	mu      sync.Mutex
+	waiters int
+}
+
+// lockAddress Prevents concurrent opens of the same address from creating
+// several instances, the returned function releases the lock
+func (o *orbitDB) lockAddress(address string) func() {
+	o.muOpenLocks.Lock()
+	l, ok := o.openLocks[address]
+	if !ok {
+		l = &openLock{}
+		o.openLocks[address] = l
+	}
+	l.waiters++
+	o.muOpenLocks.Unlock()
+
+	l.mu.Lock()
+
+	return func() {
+		l.mu.Unlock()
+
+		o.muOpenLocks.Lock()
+		defer o.muOpenLocks.Unlock()
+
+		l.waiters--
+		if l.waiters == 0 {
+			delete(o.openLocks, address)
+		}
+	}
+}
+
+func (o *orbitDB) setStore(address string, entry *storeEntry) {
+	o.muStores.Lock()
+	entry.lastUsed = time.Now()
+	o.stores[address] = entry
+	o.muStores.Unlock()
+
+	// an open store may exceed the maximum number of open stores
+	o.signalEviction()
+}
+
+func (o *orbitDB) getStore(address string) (Store, bool) {
+	o.muStores.RLock()
+	defer o.muStores.RUnlock()
+
+	entry, ok := o.stores[address]
+	if !ok {
+		return nil, false
+	}
+
+	return entry.store, true
+}
+
+// acquireStore Returns the store open at the given address and takes a
+// reference on it, unless it has been opened with options conflicting with
+// the given ones
+func (o *orbitDB) acquireStore(address string, options *CreateDBOptions) (Store, bool, error) {
+	o.muStores.Lock()
+	defer o.muStores.Unlock()
+
+	entry, ok := o.stores[address]
+	if !ok {
+		return nil, false, nil
+	}
+
+	if err := entry.checkOptions(options); err != nil {
+		return nil, false, errors.Wrap(err, fmt.Sprintf("database %s is already open", address))
+	}
+
+	entry.refs++
+	entry.lastUsed = time.Now()
+
+	return entry.store, true, nil
+}
+
+// checkOptions Returns an error when the options of a new holder conflict
+// with the ones the store has been opened with. The options left unset
+// don't conflict, and a typed store can be used in place of a headless one
+// as it replicates the same way
+func (e *storeEntry) checkOptions(options *CreateDBOptions) error {
+	if options == nil {
+		return nil
+	}
+
+	if e.headless && !options.Headless {
+		return errors.New("it is open as a headless store")
+	}
+
+	if options.StoreType != nil && *options.StoreType != "" && *options.StoreType != e.storeType {
+		return errors.New(fmt.Sprintf("it is open as a %s store", e.storeType))
+	}
+
+	if options.Identity != nil && options.Identity.ID != e.store.Identity().ID {
+		return errors.New("it is open with another identity")
+	}
+
+	if options.SharedKey != nil && !sameSharedKey(options.SharedKey, e.store.SharedKey()) {
+		return errors.New("it is open with another shared key")
+	}
+
+	if options.AccessController != nil && e.options.AccessController != nil && !sameAccessController(options.AccessController, e.options.AccessController) {
+		return errors.New("it is open with another access controller")
+	}
+
+	if options.Replicate != nil && e.options.Replicate != nil && *options.Replicate != *e.options.Replicate {
+		return errors.New("it is open with another replication mode")
+	}
+
+	if options.ReplicationFilter != nil || options.ReplicatorConstructor != nil {
+		return errors.New("a replication filter or constructor can't be applied to an open store")
+	}
+
+	if !sameReplicationOptions(options, &e.options) {
+		return errors.New("it is open with other replication options")
+	}
+
+	if options.PrivateTopic && !e.options.PrivateTopic {
+		return errors.New("it is open on its public topic")
+	}
+
+	if options.RecipientKey != nil && (e.options.RecipientKey == nil || options.RecipientKey.ID() != e.options.RecipientKey.ID()) {
+		return errors.New("it is open with another recipient key")
+	}
+
+	if !sameGuardOptions(options, &e.options) {
+		return errors.New("it is open with other peer restrictions")
+	}
+
+	return nil
+}
+
+// sameSharedKey Returns whether two shared keys hold the same secret
+func sameSharedKey(a, b enc.SharedKey) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	rawA, err := a.Marshal()
+	if err != nil {
+		return false
+	}
+
+	rawB, err := b.Marshal()
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(rawA, rawB)
+}
+
+// sameAccessController Returns whether two access controller parameters
+// grant the same access, their type is compared when both set it
+func sameAccessController(a, b accesscontroller.ManifestParams) bool {
+	if a.GetType() != "" && b.GetType() != "" && a.GetType() != b.GetType() {
+		return false
+	}
+
+	accessA, accessB := a.GetAllAccess(), b.GetAllAccess()
+	if len(accessA) != len(accessB) {
+		return false
+	}
+
+	for role, allowedA := range accessA {
+		allowedB, ok := accessB[role]
+		if !ok || len(allowedA) != len(allowedB) {
+			return false
+		}
+
+		allowed := map[string]struct{}{}
+		for _, id := range allowedA {
+			allowed[id] = struct{}{}
+		}
+
+		for _, id := range allowedB {
+			if _, ok := allowed[id]; !ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// sameReplicationOptions Returns whether the replication options set by a
+// new holder match the ones of the store
+func sameReplicationOptions(options, current *CreateDBOptions) bool {
+	return (options.ReplicationConcurrency == 0 || options.ReplicationConcurrency == current.ReplicationConcurrency) &&
+		(options.ReplicationBatchSize == 0 || options.ReplicationBatchSize == current.ReplicationBatchSize) &&
+		(!options.ReplicationAdaptiveBatchSize || current.ReplicationAdaptiveBatchSize) &&
+		(options.ReplicationMaxBatchSize == 0 || options.ReplicationMaxBatchSize == current.ReplicationMaxBatchSize) &&
+		(options.ReplicationFetchConcurrency == 0 || options.ReplicationFetchConcurrency == current.ReplicationFetchConcurrency) &&
+		(options.ReplicationFetchTimeout == 0 || options.ReplicationFetchTimeout == current.ReplicationFetchTimeout) &&
+		(options.ReplicationMaxRetries == 0 || options.ReplicationMaxRetries == current.ReplicationMaxRetries) &&
+		(options.ReplicationRetryBackoff == 0 || options.ReplicationRetryBackoff == current.ReplicationRetryBackoff) &&
+		(options.ReplicationMaxRetryBackoff == 0 || options.ReplicationMaxRetryBackoff == current.ReplicationMaxRetryBackoff) &&
+		(options.ReplicationMaxDepth == 0 || options.ReplicationMaxDepth == current.ReplicationMaxDepth)
+}
+
+// sameGuardOptions Returns whether the peer lists and the limits of the
+// incoming heads set by a new holder match the ones of the store
+func sameGuardOptions(options, current *CreateDBOptions) bool {
+	return (len(options.AllowedPeers) == 0 || samePeers(options.AllowedPeers, current.AllowedPeers)) &&
+		(len(options.DeniedPeers) == 0 || samePeers(options.DeniedPeers, current.DeniedPeers)) &&
+		(options.IncomingHeadsRateLimit == 0 || options.IncomingHeadsRateLimit == current.IncomingHeadsRateLimit) &&
+		(options.IncomingHeadsBurst == 0 || options.IncomingHeadsBurst == current.IncomingHeadsBurst) &&
+		(options.MaxIncomingHeads == 0 || options.MaxIncomingHeads == current.MaxIncomingHeads)
+}
+
+// samePeers Returns whether two lists hold the same peers, in any order
+func samePeers(a, b []p2pcore.PeerID) bool {
+	peers := make(map[p2pcore.PeerID]struct{}, len(a))
+	for _, p := range a {
+		peers[p] = struct{}{}
+	}
+
+	others := make(map[p2pcore.PeerID]struct{}, len(b))
+	for _, p := range b {
+		if _, ok := peers[p]; !ok {
+			return false
+		}
+
+		others[p] = struct{}{}
+	}
+
+	return len(peers) == len(others)
+}
+
+// releaseStore Drops a reference on a store, or all of them when it is
+// dropped. Returns true when the store has no holders left and can be
+// closed
+func (o *orbitDB) releaseStore(address string, entry *storeEntry, drop bool) bool {
+	o.muStores.Lock()
+	defer o.muStores.Unlock()
+
+	if drop {
+		entry.refs = 0
+	} else if entry.refs > 0 {
+		entry.refs--
+	}
+
+	if entry.refs > 0 {
+		return false
+	}
+
+	if o.stores[address] == entry {
+		delete(o.stores, address)
+	}
+
+	return true
+}
+
+// useStore Records the use of a store, a resumed store may exceed the
+// maximum number of open stores
+func (o *orbitDB) useStore(entry *storeEntry, resumed bool) {
+	o.muStores.Lock()
+	entry.lastUsed = time.Now()
+	o.muStores.Unlock()
+
+	if resumed {
+		o.signalEviction()
+	}
+}
+
+// suspendStores Returns whether the stores are suspended when they exceed
+// the maximum number of open stores or the idle timeout, the in-memory
+// caches would lose their content once closed
+func (o *orbitDB) suspendStores() bool {
+	return (o.maxOpenStores > 0 || o.storeIdleTimeout > 0) && o.directory != cacheleveldown.InMemoryDirectory
+}
+
+// signalEviction Wakes up the suspension of the stores without waiting for it
+func (o *orbitDB) signalEviction() {
+	if !o.suspendStores() {
+		return
+	}
+
+	select {
+	case o.evictSignal <- struct{}{}:
+	default:
+	}
+}
+
+// evictStoresLoop Suspends the stores when signaled and periodically, to
+// retry the stores which were busy, until the current DB is closed
+func (o *orbitDB) evictStoresLoop() {
+	interval := storeEvictionRetryInterval
+	if o.storeIdleTimeout > 0 && o.storeIdleTimeout/2 < interval {
+		interval = o.storeIdleTimeout / 2
+	}
+
+	if interval <= 0 {
+		interval = o.storeIdleTimeout
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-o.ctx.Done():
+			return
+		case <-o.evictSignal:
+		}
+
+		o.evictStores()
+	}
+}
+
+// storesToEvict Returns the addresses of the stores which are not
+// suspended and exceeded the idle timeout or the maximum number of open
+// stores, the least recently used first
+func (o *orbitDB) storesToEvict() []string {
+	o.muStores.RLock()
+	defer o.muStores.RUnlock()
+
+	var open []string
+	for address, entry := range o.stores {
+		if store, ok := entry.store.(iface.StoreSuspendInterface); ok && !store.Suspended() {
+			open = append(open, address)
+		}
+	}
+
+	sort.Slice(open, func(i, j int) bool {
+		return o.stores[open[i]].lastUsed.Before(o.stores[open[j]].lastUsed)
+	})
+
+	excess := 0
+	if o.maxOpenStores > 0 && len(open) > o.maxOpenStores {
+		excess = len(open) - o.maxOpenStores
+	}
+
+	var evicted []string
+	for i, address := range open {
+		if i < excess || (o.storeIdleTimeout > 0 && time.Since(o.stores[address].lastUsed) > o.storeIdleTimeout) {
+			evicted = append(evicted, address)
+		}
+	}
+
+	return evicted
+}
+
+// evictStores Suspends the least recently used stores even when they are
+// held, they are resumed on their next use. A busy store is retried later
+func (o *orbitDB) evictStores() {
+	for _, address := range o.storesToEvict() {
+		store, ok := o.getStore(address)
+		if !ok {
+			continue
+		}
+
+		suspended, err := store.(iface.StoreSuspendInterface).Suspend()
+		if err != nil {
+			o.logger.Error("unable to suspend store", zap.String("address", address), zap.Error(err))
+		} else if suspended {
+			o.logger.Debug("suspended store", zap.String("address", address))
+		}
+	}
+}
+
+// deleteStore Removes a store from the registry, unless another instance
+// has been opened at its address since
+func (o *orbitDB) deleteStore(address string, store Store) {
+	o.muStores.Lock()
+	defer o.muStores.Unlock()
+
+	if entry, ok := o.stores[address]; ok && entry.store == store {
+		delete(o.stores, address)
+	}
+}
+
+// openStores Returns the currently open stores
+func (o *orbitDB) openStores() []Store {
+	o.muStores.RLock()
+	defer o.muStores.RUnlock()
+
+	list := make([]Store, 0, len(o.stores))
+	for _, entry := range o.stores {
+		list = append(list, entry.store)
+	}
+
+	return list
+}
+
+func (o *orbitDB) closeAllStores() {
+	o.muStores.Lock()
+	entries := o.stores
+	o.stores = map[string]*storeEntry{}
+
+	// the stores are closed regardless of their remaining holders
+	for _, entry := range entries {
+		entry.refs = 0
+	}
+	o.muStores.Unlock()
+
+	for _, entry := range entries {
+		if err := entry.store.Close(); err != nil {
+			o.logger.Error("unable to close store", zap.Error(err))
+		}
+	}
+}
+
+func (o *orbitDB) Stores() map[string]Store {
+	o.muStores.RLock()
+	defer o.muStores.RUnlock()
+
+	stores := make(map[string]Store, len(o.stores))
+	for address, entry := range o.stores {
+		stores[address] = entry.store
+	}
+
+	return stores
+}
+
+func (o *orbitDB) GetStore(address string) (Store, bool) {
+	return o.getStore(address)
+}
@@ -0,0 +1,121 @@
+package baseorbitdb
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"berty.tech/go-orbit-db/accesscontroller/ipfs"
+	"berty.tech/go-orbit-db/accesscontroller/simple"
+	"berty.tech/go-orbit-db/events"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores/eventlogstore"
+	ipfsCore "github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreapi"
+	mock "github.com/ipfs/go-ipfs/core/mock"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// testingDirectChannel Records the messages sent to a peer
+type testingDirectChannel struct {
+	events.EventEmitter
+
+	muSent sync.Mutex
+	sent   [][]byte
+}
+
+func (c *testingDirectChannel) Connect(context.Context) error {
+	return nil
+}
+
+func (c *testingDirectChannel) Send(_ context.Context, data []byte) error {
+	c.muSent.Lock()
+	defer c.muSent.Unlock()
+
+	c.sent = append(c.sent, data)
+
+	return nil
+}
+
+func (c *testingDirectChannel) Close() error {
+	return nil
+}
+
+// messages Decodes the messages sent so far
+func (c *testingDirectChannel) messages(t *testing.T) []*exchangedHeads {
+	t.Helper()
+
+	c.muSent.Lock()
+	defer c.muSent.Unlock()
+
+	msgs := make([]*exchangedHeads, len(c.sent))
+	for i, data := range c.sent {
+		msg, _, err := decodeMessage(data)
+		require.NoError(t, err)
+
+		msgs[i] = msg
+	}
+
+	return msgs
+}
+
+var _ iface.DirectChannel = &testingDirectChannel{}
+
+func testingOrbitDB(ctx context.Context, t *testing.T, mn mocknet.Mocknet, options *NewOrbitDBOptions) (*orbitDB, func()) {
+	t.Helper()
+
+	node, err := ipfsCore.NewNode(ctx, &ipfsCore.BuildCfg{
+		Online: true,
+		Host:   mock.MockHostOption(mn),
+		ExtraOpts: map[string]bool{
+			"pubsub": true,
+		},
+	})
+	require.NoError(t, err)
+
+	api, err := coreapi.NewCoreAPI(node)
+	require.NoError(t, err)
+
+	odb, err := NewOrbitDB(ctx, api, options)
+	require.NoError(t, err)
+
+	o, ok := odb.(*orbitDB)
+	require.True(t, ok)
+
+	o.RegisterStoreType("eventlog", eventlogstore.NewOrbitDBEventLogStore)
+	require.NoError(t, o.RegisterAccessControllerType(ipfs.NewIPFSAccessController))
+	require.NoError(t, o.RegisterAccessControllerType(simple.NewSimpleAccessController))
+
+	return o, func() {
+		_ = o.Close()
+		_ = node.Close()
+	}
+}
+
+func testingEventLog(ctx context.Context, t *testing.T, o *orbitDB, name string, options *CreateDBOptions) EventLogStore {
+	t.Helper()
+
+	if options == nil {
+		options = &CreateDBOptions{}
+	}
+
+	store, err := o.Create(ctx, name, "eventlog", options)
+	require.NoError(t, err)
+
+	logStore, ok := store.(EventLogStore)
+	require.True(t, ok)
+
+	return logStore
+}
+
+// testingBlock Creates a CBOR block which isn't stored anywhere
+func testingBlock(t *testing.T, value string) *cbornode.Node {
+	t.Helper()
+
+	node, err := cbornode.WrapObject(map[string]string{"value": value}, 0x12 /* sha2-256 */, -1)
+	require.NoError(t, err)
+
+	return node
+}
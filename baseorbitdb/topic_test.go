@@ -0,0 +1,80 @@
+package baseorbitdb
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"berty.tech/go-ipfs-log/enc"
+	"berty.tech/go-orbit-db/address"
+	"berty.tech/go-orbit-db/keyring"
+	cid "github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func testingSecretbox(t *testing.T, seed byte) enc.SharedKey {
+	t.Helper()
+
+	raw := make([]byte, 32)
+	for i := range raw {
+		raw[i] = seed
+	}
+
+	key, err := enc.NewSecretbox(raw)
+	require.NoError(t, err)
+
+	return key
+}
+
+func TestStoreTopicKeyRotation(t *testing.T) {
+	root, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: 0x12}.Sum([]byte("manifest"))
+	require.NoError(t, err)
+
+	addr, err := address.Parse("/orbitdb/" + root.String() + "/store")
+	require.NoError(t, err)
+
+	public, err := storeTopic(addr, &CreateDBOptions{})
+	require.NoError(t, err)
+	require.Equal(t, addr.String(), public)
+
+	_, err = storeTopic(addr, &CreateDBOptions{PrivateTopic: true})
+	require.Error(t, err)
+
+	ring, err := keyring.NewRing("epoch-0", testingSecretbox(t, 0))
+	require.NoError(t, err)
+
+	sharedKey, err := keyring.NewSharedKey(ring)
+	require.NoError(t, err)
+
+	options := &CreateDBOptions{SharedKey: sharedKey, PrivateTopic: true}
+
+	before, err := storeTopic(addr, options)
+	require.NoError(t, err)
+	require.NotEqual(t, public, before)
+
+	// the topic is a labelled HMAC of the address with the initial key
+	raw, err := testingSecretbox(t, 0).Marshal()
+	require.NoError(t, err)
+
+	mac := hmac.New(sha256.New, raw)
+	_, _ = mac.Write([]byte("orbitdb/private-topic/" + addr.String()))
+	require.Equal(t, privateTopicPrefix+hex.EncodeToString(mac.Sum(nil)), before)
+
+	// the topic is kept when the key is rotated
+	require.NoError(t, ring.Rotate("epoch-1", testingSecretbox(t, 1)))
+
+	after, err := storeTopic(addr, options)
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+
+	// a peer holding the initial key finds the same topic
+	other, err := storeTopic(addr, &CreateDBOptions{SharedKey: testingSecretbox(t, 0), PrivateTopic: true})
+	require.NoError(t, err)
+	require.Equal(t, before, other)
+
+	// and a peer holding another key doesn't
+	other, err = storeTopic(addr, &CreateDBOptions{SharedKey: testingSecretbox(t, 1), PrivateTopic: true})
+	require.NoError(t, err)
+	require.NotEqual(t, before, other)
+}
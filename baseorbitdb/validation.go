@@ -0,0 +1,128 @@
+package baseorbitdb
+
+import (
+	"context"
+
+	logac "berty.tech/go-ipfs-log/accesscontroller"
+	"berty.tech/go-orbit-db/iface"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"go.uber.org/zap"
+)
+
+// storeLogEntries Exposes the entries of the log of a store to the access
+// controller
+type storeLogEntries struct {
+	store Store
+}
+
+func (s *storeLogEntries) GetLogEntries() []logac.LogEntry {
+	logEntries := s.store.OpLog().GetEntries().Slice()
+
+	entries := make([]logac.LogEntry, len(logEntries))
+	for i := range logEntries {
+		entries[i] = logEntries[i]
+	}
+
+	return entries
+}
+
+// registerAnnouncementValidator Validates the announcements of a store at
+// the pubsub level when supported, so invalid announcements are not relayed
+// to other peers. The validator is removed once the store is closed, unless
+// a new instance of the store has registered its own meanwhile
+func (o *orbitDB) registerAnnouncementValidator(store Store, topic iface.PubSubTopic) {
+	registry, ok := o.pubsub.(iface.PubSubValidatorInterface)
+	if !ok {
+		return
+	}
+
+	name := topic.Topic()
+
+	o.muTopicValidators.Lock()
+	if _, ok := o.topicValidators[name]; ok {
+		// a closed instance of the store hasn't removed its validator yet
+		if err := registry.UnregisterTopicValidator(name); err != nil {
+			o.logger.Debug("unable to unregister topic validator", zap.String("topic", name), zap.Error(err))
+		}
+
+		delete(o.topicValidators, name)
+	}
+
+	if err := registry.RegisterTopicValidator(name, o.announcementValidator(store)); err != nil {
+		o.muTopicValidators.Unlock()
+		o.logger.Debug("unable to register topic validator", zap.String("topic", name), zap.Error(err))
+		return
+	}
+
+	o.topicValidators[name] = store
+	o.muTopicValidators.Unlock()
+
+	ctx, cancel := context.WithCancel(o.ctx)
+	sub := store.Subscribe(ctx)
+
+	go func() {
+		defer cancel()
+
+		for range sub {
+		}
+
+		o.muTopicValidators.Lock()
+		defer o.muTopicValidators.Unlock()
+
+		if o.topicValidators[name] != store {
+			return
+		}
+
+		delete(o.topicValidators, name)
+
+		if err := registry.UnregisterTopicValidator(name); err != nil {
+			o.logger.Debug("unable to unregister topic validator", zap.String("topic", name), zap.Error(err))
+		}
+	}()
+}
+
+// announcementValidator Accepts the messages which decode as announcements
+// of heads the access controller of the store allows, published by a peer
+// which isn't denied by the guard of the store
+func (o *orbitDB) announcementValidator(store Store) iface.PubSubTopicValidator {
+	return func(ctx context.Context, from peer.ID, message []byte) bool {
+		if o.getHeadsGuard(store.Address().String()).deniedPeer(from) {
+			o.logger.Debug("rejecting announcement from denied peer", zap.String("peer", from.String()))
+			return false
+		}
+
+		if key := store.SharedKey(); key != nil {
+			var err error
+
+			message, err = key.Open(message)
+			if err != nil {
+				return false
+			}
+		}
+
+		msg, _, err := decodeMessage(message)
+		if err != nil || msg.Type != messageTypeAnnounce {
+			return false
+		}
+
+		ac := store.AccessController()
+		identityProvider := store.Identity().Provider
+
+		if ac == nil || identityProvider == nil {
+			return true
+		}
+
+		for _, h := range msg.Heads {
+			if h == nil {
+				return false
+			}
+
+			if err := ac.CanAppend(h, identityProvider, &storeLogEntries{store: store}); err != nil {
+				o.logger.Debug("rejecting announcement", zap.String("peer", from.String()), zap.Error(err))
+				return false
+			}
+		}
+
+		return true
+	}
+}
@@ -1,12 +1,15 @@
 package cacheleveldown
 
 import (
+	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"sync"
 
 	"berty.tech/go-orbit-db/address"
 	"berty.tech/go-orbit-db/cache"
+	cid "github.com/ipfs/go-cid"
 	datastore "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/query"
 	leveldb "github.com/ipfs/go-ds-leveldb"
@@ -134,6 +137,105 @@ func (l *levelDownCache) Destroy(directory string, dbAddress address.Address) er
 	return nil
 }
 
+// List Returns the addresses of the databases cached in a root directory,
+// following the <directory>/<manifest cid>/<name> layout. Only the open
+// caches are known for the in memory directory
+func (l *levelDownCache) List(directory string) ([]address.Address, error) {
+	if directory == InMemoryDirectory {
+		l.muCaches.Lock()
+		defer l.muCaches.Unlock()
+
+		var addrs []address.Address
+		for keyPath := range l.caches {
+			if !strings.HasPrefix(keyPath, directory+"/") {
+				continue
+			}
+
+			if addr, err := address.Parse(path.Join("/orbitdb", strings.TrimPrefix(keyPath, directory+"/"))); err == nil {
+				addrs = append(addrs, addr)
+			}
+		}
+
+		return addrs, nil
+	}
+
+	roots, err := ioutil.ReadDir(directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "unable to read cache directory")
+	}
+
+	var addrs []address.Address
+
+	for _, root := range roots {
+		if !root.IsDir() {
+			continue
+		}
+
+		if _, err := cid.Decode(root.Name()); err != nil {
+			continue
+		}
+
+		names, err := ioutil.ReadDir(path.Join(directory, root.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read cache directory")
+		}
+
+		for _, name := range names {
+			if !name.IsDir() {
+				continue
+			}
+
+			addr, err := address.Parse(path.Join("/orbitdb", root.Name(), name.Name()))
+			if err != nil {
+				l.logger.Debug("skipping invalid cache directory", zap.String("name", name.Name()), zap.Error(err))
+				continue
+			}
+
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs, nil
+}
+
+// Inspect Calls fn with the cache of a database, a cache which isn't loaded
+// is opened read-only and closed once fn returns. The caches can't be loaded
+// meanwhile
+func (l *levelDownCache) Inspect(directory string, dbAddress address.Address, fn func(datastore.Read) error) error {
+	keyPath := datastoreKey(directory, dbAddress)
+
+	l.muCaches.Lock()
+	defer l.muCaches.Unlock()
+
+	if ds, ok := l.caches[keyPath]; ok {
+		return fn(ds)
+	}
+
+	if directory == InMemoryDirectory {
+		return errors.New("cache is not loaded")
+	}
+
+	ds, err := leveldb.NewDatastore(keyPath, &leveldb.Options{
+		ReadOnly:       true,
+		ErrorIfMissing: true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to open leveldb datastore")
+	}
+
+	defer func() {
+		if err := ds.Close(); err != nil {
+			l.logger.Debug("unable to close inspected cache", zap.String("path", keyPath), zap.Error(err))
+		}
+	}()
+
+	return fn(ds)
+}
+
 // New Creates a new leveldb data store
 func New(opts *cache.Options) cache.Interface {
 	if opts == nil {
@@ -152,4 +254,6 @@ func New(opts *cache.Options) cache.Interface {
 }
 
 var _ cache.Interface = &levelDownCache{}
+var _ cache.Lister = &levelDownCache{}
+var _ cache.Inspector = &levelDownCache{}
 var _ datastore.Datastore = &wrappedCache{}
@@ -0,0 +1,62 @@
+package cacheleveldown
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"berty.tech/go-orbit-db/address"
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	directory, err := ioutil.TempDir("", "leveldown")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(directory)
+
+	root, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: 0x12}.Sum([]byte("manifest"))
+	require.NoError(t, err)
+
+	addr, err := address.Parse("/orbitdb/" + root.String() + "/inspected")
+	require.NoError(t, err)
+
+	c := New(nil).(*levelDownCache)
+	key := datastore.NewKey("key")
+
+	// a database without cache can't be inspected
+	require.Error(t, c.Inspect(directory, addr, func(datastore.Read) error { return nil }))
+
+	ds, err := c.Load(directory, addr)
+	require.NoError(t, err)
+	require.NoError(t, ds.Put(key, []byte("value")))
+
+	// a loaded cache is inspected as is
+	require.NoError(t, c.Inspect(directory, addr, func(r datastore.Read) error {
+		value, err := r.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), value)
+
+		return nil
+	}))
+
+	require.NoError(t, ds.Close())
+	require.Empty(t, c.caches)
+
+	require.NoError(t, c.Inspect(directory, addr, func(r datastore.Read) error {
+		value, err := r.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), value)
+
+		return nil
+	}))
+
+	// the inspected cache isn't kept open
+	require.Empty(t, c.caches)
+
+	ds, err = c.Load(directory, addr)
+	require.NoError(t, err)
+	require.NoError(t, ds.Close())
+}
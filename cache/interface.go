@@ -18,6 +18,20 @@ type Interface interface {
 	Destroy(directory string, dbAddress address.Address) error
 }
 
+// Lister Is implemented by the caches able to list the databases they hold
+type Lister interface {
+	// List Returns the addresses of the databases cached in a root directory
+	List(directory string) ([]address.Address, error)
+}
+
+// Inspector Is implemented by the caches able to read the cache of a
+// database without loading it
+type Inspector interface {
+	// Inspect Calls fn with the cache of a database, the cache is opened
+	// read-only and closed afterwards unless it is already loaded
+	Inspect(directory string, dbAddress address.Address, fn func(datastore.Read) error) error
+}
+
 type Options struct {
 	Logger *zap.Logger
 }
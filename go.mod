@@ -24,4 +24,5 @@ require (
 	go.opentelemetry.io/otel v0.8.0
 	go.uber.org/goleak v1.1.10
 	go.uber.org/zap v1.16.0
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 )
@@ -4,6 +4,7 @@ import (
 	"berty.tech/go-ipfs-log/enc"
 	"berty.tech/go-ipfs-log/iface"
 	"context"
+	"time"
 
 	ipfslog "berty.tech/go-ipfs-log"
 	"berty.tech/go-ipfs-log/identityprovider"
@@ -11,6 +12,7 @@ import (
 	"berty.tech/go-orbit-db/accesscontroller"
 	"berty.tech/go-orbit-db/address"
 	"berty.tech/go-orbit-db/events"
+	"berty.tech/go-orbit-db/keyring"
 	"berty.tech/go-orbit-db/stores/operation"
 	"berty.tech/go-orbit-db/stores/replicator"
 	cid "github.com/ipfs/go-cid"
@@ -38,6 +40,84 @@ type CreateDBOptions struct {
 	SortFn                  ipfslog.SortFn
 	IO                      ipfslog.IO
 	SharedKey               enc.SharedKey
+
+	// KeyRing Allows rotating the shared key of a store while keeping older
+	// entries readable, used instead of SharedKey when set
+	KeyRing keyring.Interface
+
+	// RecipientKey Encrypts the payload of new entries for the members of
+	// the "read" role of the access controller, and opens the entries
+	// sealed for this key
+	RecipientKey *keyring.RecipientKey
+
+	// Replication* Tune the replicator of the store, see NewStoreOptions
+	ReplicationConcurrency       uint
+	ReplicationBatchSize         int
+	ReplicationAdaptiveBatchSize bool
+	ReplicationMaxBatchSize      int
+	ReplicationFetchConcurrency  int
+	ReplicationFetchTimeout      time.Duration
+	ReplicationMaxRetries        int
+	ReplicationRetryBackoff      time.Duration
+	ReplicationMaxRetryBackoff   time.Duration
+	ReplicationFilter            replicator.Filter
+	ReplicationMaxDepth          int
+	ReplicatorConstructor        replicator.Constructor
+
+	// AllowedPeers When set, only the heads received from these peers are
+	// synced
+	AllowedPeers []peer.ID
+
+	// DeniedPeers The heads received from these peers are ignored
+	DeniedPeers []peer.ID
+
+	// IncomingHeadsRateLimit The maximum number of heads messages accepted
+	// per second from a single peer, unlimited when zero
+	IncomingHeadsRateLimit float64
+
+	// IncomingHeadsBurst The number of heads messages a peer can send at once
+	// before being rate limited, defaults to the rate limit
+	IncomingHeadsBurst int
+
+	// MaxIncomingHeads The messages carrying more heads are ignored,
+	// unlimited when zero
+	MaxIncomingHeads int
+
+	// PrivateTopic Derives the pubsub topic of the store from its address
+	// and its SharedKey, so only the holders of the key can find it. When
+	// using a key ring, the topic is derived from its initial key, so it
+	// doesn't change when the ring is rotated
+	PrivateTopic bool
+
+	// Headless Opens the store without indexing it whatever its type, to
+	// replicate it without reading its entries, see BaseOrbitDB.Follow
+	Headless bool
+}
+
+// ReplicationServiceOptions Lists the arguments of a replication service
+type ReplicationServiceOptions struct {
+	// Addresses The addresses of the stores to follow
+	Addresses []string
+
+	// ControlTopic A pubsub topic on which the addresses of the stores to
+	// follow are published as plain strings, ignored when empty
+	ControlTopic string
+
+	// ControlTopicPeers The peers whose addresses published on the control
+	// topic are followed, the other peers are ignored
+	ControlTopicPeers []peer.ID
+
+	// ControlTopicAllowAll Follows the addresses published by any peer on
+	// the control topic, a control topic requires either this option or
+	// ControlTopicPeers
+	ControlTopicAllowAll bool
+
+	// StoreOptions Returns the options used to follow a store, such as its
+	// SharedKey, the default options are used when nil
+	StoreOptions func(address string) *CreateDBOptions
+
+	// DisablePinning Keeps the replicated entries unpinned
+	DisablePinning bool
 }
 
 // DetermineAddressOptions Lists the arguments used to determine a store address
@@ -55,7 +135,10 @@ type BaseOrbitDB interface {
 	// Identity Returns the identity used by the current DB
 	Identity() *identityprovider.Identity
 
-	// Open Opens an existing data store
+	// Open Opens an existing data store, a store already open at the same
+	// address is shared and only closed once all its holders closed it. An
+	// error is returned when the options set conflict with the ones of the
+	// open store, such as its type, shared key or replication options
 	Open(ctx context.Context, dbAddress string, options *CreateDBOptions) (Store, error)
 
 	// Create Creates a new store
@@ -87,6 +170,35 @@ type BaseOrbitDB interface {
 
 	// Tracer Returns the tracer
 	Tracer() trace.Tracer
+
+	// Follow Opens a store as a headless store, replicating it and answering
+	// the heads exchanges of its peers without indexing it. A store already
+	// open with its type is returned as is
+	Follow(ctx context.Context, address string, options *CreateDBOptions) (Store, error)
+
+	// StartReplicationService Follows the configured stores and the stores
+	// announced on the control topic, until the context is done. The stores
+	// opened by the service are closed along with it
+	StartReplicationService(ctx context.Context, options *ReplicationServiceOptions) error
+
+	// ListLocalDatabases Returns the databases with data in the directory
+	// of the current DB
+	ListLocalDatabases(ctx context.Context) ([]*LocalDatabase, error)
+
+	// Stores Returns the open stores indexed by address
+	Stores() map[string]Store
+
+	// GetStore Returns the store open at the given address, without taking
+	// a reference on it
+	GetStore(address string) (Store, bool)
+}
+
+// LocalDatabase Describes a database with data on disk, Type is empty when
+// its manifest is not available
+type LocalDatabase struct {
+	Address address.Address
+	Name    string
+	Type    string
 }
 
 // OrbitDBKVStore An OrbitDB instance providing a KeyValue store
@@ -188,6 +300,10 @@ type Store interface {
 	// AddOperation Adds an operation to this store
 	AddOperation(ctx context.Context, op operation.Operation, onProgressCallback chan<- ipfslog.Entry) (ipfslog.Entry, error)
 
+	// AddOperations Adds a batch of operations to this store, heads are cached and announced once for the whole batch.
+	// On failure, the entries appended before it are returned along with the error
+	AddOperations(ctx context.Context, ops []operation.Operation, onProgressCallback chan<- ipfslog.Entry) ([]ipfslog.Entry, error)
+
 	// Logger Returns the logger
 	Logger() *zap.Logger
 
@@ -199,6 +315,18 @@ type Store interface {
 	SharedKey() enc.SharedKey
 }
 
+// StoreSuspendInterface Is implemented by the stores able to release their
+// cache and replicator while they are still held, they are restored
+// transparently on the next write, load or sync of the store
+type StoreSuspendInterface interface {
+	// Suspend Releases the resources of the store until its next use,
+	// returns false when it is already suspended, closed or busy
+	Suspend() (bool, error)
+
+	// Suspended Returns whether the store is suspended
+	Suspended() bool
+}
+
 // EventLogStore A type of store that provides an append only log
 type EventLogStore interface {
 	Store
@@ -206,6 +334,10 @@ type EventLogStore interface {
 	// Add Appends data to the log
 	Add(ctx context.Context, data []byte) (operation.Operation, error)
 
+	// AddBatch Appends multiple items to the log in a single write, on
+	// failure the items appended before it are returned along with the error
+	AddBatch(ctx context.Context, data [][]byte) ([]operation.Operation, error)
+
 	// Get Fetches an entry of the log
 	Get(ctx context.Context, cid cid.Cid) (operation.Operation, error)
 
@@ -272,10 +404,72 @@ type NewStoreOptions struct {
 	Tracer                 trace.Tracer
 	IO                     ipfslog.IO
 	SharedKey              enc.SharedKey
+	RecipientKey           *keyring.RecipientKey
+
+	// Release Called when a holder closes the store, or drops it when drop
+	// is set, the store is only closed once it returns true
+	Release func(drop bool) bool
+
+	// ReopenCache Returns the cache of a suspended store when it is used
+	// again, a store can't be suspended without it
+	ReopenCache func() (datastore.Datastore, error)
+
+	// Used Called when the store is used, resumed is set when it was
+	// suspended until then
+	Used func(resumed bool)
+
+	// Suspended Called when the store is suspended, before its cache is
+	// closed
+	Suspended func()
+
+	// Resumed Called when the store is resumed, after its replicator is
+	// restarted
+	Resumed func() error
+
+	// ReplicationBatchSize The number of entries fetched at once by the
+	// replicator, defaults to 1
+	ReplicationBatchSize int
+
+	// ReplicationAdaptiveBatchSize Lets the replicator double the batch
+	// size after each full batch of a history, up to ReplicationMaxBatchSize
+	ReplicationAdaptiveBatchSize bool
+	ReplicationMaxBatchSize      int
+
+	// ReplicationFetchConcurrency The number of entries of a batch fetched
+	// concurrently
+	ReplicationFetchConcurrency int
+
+	// ReplicationFetchTimeout The maximum duration of a single fetch,
+	// unbounded when zero
+	ReplicationFetchTimeout time.Duration
+
+	// ReplicationMaxRetries The number of times a failed fetch is retried,
+	// with an exponential backoff between ReplicationRetryBackoff and
+	// ReplicationMaxRetryBackoff, before the hash is marked as failed
+	ReplicationMaxRetries      int
+	ReplicationRetryBackoff    time.Duration
+	ReplicationMaxRetryBackoff time.Duration
+
+	// ReplicationFilter Decides which entries are replicated, see
+	// replicator.FilterByAuthors, replicator.FilterByClock and
+	// replicator.FilterByOperation
+	ReplicationFilter replicator.Filter
+
+	// ReplicationMaxDepth The number of hops followed from the received
+	// heads, the whole history is replicated when zero
+	ReplicationMaxDepth int
+
+	// ReplicatorConstructor Creates the replicator of the store, defaults to
+	// replicator.NewReplicator
+	ReplicatorConstructor replicator.Constructor
 }
 
 type DirectChannelOptions struct {
 	Logger *zap.Logger
+
+	// MaxMessageSize The maximum size of the messages sent and received on
+	// the channel, the default of the implementation is used when zero
+	MaxMessageSize int
 }
 
 type DirectChannel interface {
@@ -328,6 +522,27 @@ type PubSubInterface interface {
 	TopicSubscribe(ctx context.Context, topic string) (PubSubTopic, error)
 }
 
+// PubSubTopicValidator Returns whether a message published on a topic by a
+// peer is valid, invalid messages are neither delivered nor relayed
+type PubSubTopicValidator func(ctx context.Context, from peer.ID, message []byte) bool
+
+// PubSubValidatorInterface Is implemented by the pubsubs able to validate
+// the messages of a topic before relaying them to other peers
+type PubSubValidatorInterface interface {
+	// RegisterTopicValidator Registers the validator of a topic
+	RegisterTopicValidator(topic string, validator PubSubTopicValidator) error
+
+	// UnregisterTopicValidator Removes the validator of a topic
+	UnregisterTopicValidator(topic string) error
+}
+
+// PubSubLeaveInterface Is implemented by the pubsubs able to leave a topic,
+// the other peers of the topic are notified of the departure
+type PubSubLeaveInterface interface {
+	// TopicLeave Leaves a topic and ends the watchers of its subscription
+	TopicLeave(ctx context.Context, topic string) error
+}
+
 type PubSubSubscriptionOptions struct {
 	Logger *zap.Logger
 	Tracer trace.Tracer
@@ -336,6 +551,9 @@ type PubSubSubscriptionOptions struct {
 // EventPubSubMessage Indicates a new message posted on a pubsub topic
 type EventPubSubMessage struct {
 	Content []byte
+
+	// From The peer which published the message, empty when unknown
+	From peer.ID
 }
 
 // EventPubSubPayload An event received on new messages
@@ -352,3 +570,16 @@ type EventPubSubJoin struct {
 type EventPubSubLeave struct {
 	Peer peer.ID
 }
+
+// EventDirectChannelConnected Is an event triggered when the stream of a
+// direct channel is opened, including after a reconnection
+type EventDirectChannelConnected struct {
+	Peer peer.ID
+}
+
+// EventDirectChannelDisconnected Is an event triggered when the stream of a
+// direct channel is lost, the channel is unusable when Permanent is set
+type EventDirectChannelDisconnected struct {
+	Peer      peer.ID
+	Permanent bool
+}
@@ -0,0 +1,2 @@
+// keyring manages the encryption keys of stores
+package keyring // import "berty.tech/go-orbit-db/keyring"
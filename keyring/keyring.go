@@ -0,0 +1,282 @@
+package keyring
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"berty.tech/go-ipfs-log/enc"
+	"github.com/pkg/errors"
+)
+
+// LegacyEpoch The epoch used to read data written without an epoch header,
+// ie. before a store started using a key ring
+const LegacyEpoch = ""
+
+// headerMagic Prefixes every payload sealed by a key ring
+var headerMagic = []byte("okr1")
+
+// Interface Resolves the shared keys of a store by epoch
+type Interface interface {
+	// Current Returns the epoch and the key used to encrypt new data
+	Current() (string, enc.SharedKey, error)
+
+	// Get Returns the key of the given epoch
+	Get(epoch string) (enc.SharedKey, error)
+}
+
+// InitialKeyInterface Is implemented by the key rings remembering the key
+// they were created with, which doesn't change when they are rotated
+type InitialKeyInterface interface {
+	// Initial Returns the epoch and the key the ring was created with
+	Initial() (string, enc.SharedKey, error)
+}
+
+// Ring An in-memory key ring
+type Ring struct {
+	initial string
+	current string
+	keys    map[string]enc.SharedKey
+	mu      sync.RWMutex
+}
+
+// NewRing Creates a new key ring using the given key for its first epoch,
+// the peers sharing a store must create their ring with the same first epoch
+// and add the following ones
+func NewRing(epoch string, key enc.SharedKey) (*Ring, error) {
+	r := &Ring{
+		initial: epoch,
+		keys:    map[string]enc.SharedKey{},
+	}
+
+	if err := r.Rotate(epoch, key); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Ring) Initial() (string, enc.SharedKey, error) {
+	key, err := r.Get(r.initial)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return r.initial, key, nil
+}
+
+func (r *Ring) Current() (string, enc.SharedKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.keys[r.current]
+	if !ok {
+		return "", nil, errors.New("no current key")
+	}
+
+	return r.current, key, nil
+}
+
+func (r *Ring) Get(epoch string) (enc.SharedKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.keys[epoch]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown key epoch %q", epoch))
+	}
+
+	return key, nil
+}
+
+// Add Registers the key of an epoch, it can be used to read data but won't
+// be used to encrypt new data
+func (r *Ring) Add(epoch string, key enc.SharedKey) error {
+	if key == nil {
+		return errors.New("a key must be provided")
+	}
+
+	if len(epoch) > 255 {
+		return errors.New("epoch identifier is too long")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.keys[epoch]; ok && !sameKey(existing, key) {
+		return errors.New(fmt.Sprintf("epoch %q is already registered", epoch))
+	}
+
+	r.keys[epoch] = key
+
+	return nil
+}
+
+// Rotate Registers the key of an epoch and uses it to encrypt new data
+func (r *Ring) Rotate(epoch string, key enc.SharedKey) error {
+	if err := r.Add(epoch, key); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.current = epoch
+	r.mu.Unlock()
+
+	return nil
+}
+
+// sameKey Returns whether two keys hold the same secret, the key
+// implementations aren't necessarily comparable
+func sameKey(a, b enc.SharedKey) bool {
+	rawA, err := a.Marshal()
+	if err != nil {
+		return false
+	}
+
+	rawB, err := b.Marshal()
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(rawA, rawB)
+}
+
+// sharedKey An enc.SharedKey tagging the sealed data with the epoch of the
+// key used, so it can be opened after the ring has been rotated. Every
+// method resolves the current key of the ring when called
+type sharedKey struct {
+	ring Interface
+}
+
+// NewSharedKey Returns a shared key backed by a key ring
+func NewSharedKey(ring Interface) (enc.SharedKey, error) {
+	if ring == nil {
+		return nil, errors.New("a key ring must be provided")
+	}
+
+	if _, _, err := ring.Current(); err != nil {
+		return nil, errors.Wrap(err, "unable to get current key")
+	}
+
+	return &sharedKey{
+		ring: ring,
+	}, nil
+}
+
+// Marshal Returns the current key of the ring
+func (s *sharedKey) Marshal() ([]byte, error) {
+	_, key, err := s.ring.Current()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get current key")
+	}
+
+	return key.Marshal()
+}
+
+func (s *sharedKey) Seal(data []byte) ([]byte, error) {
+	epoch, key, err := s.ring.Current()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get current key")
+	}
+
+	sealed, err := key.Seal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return withHeader(epoch, sealed), nil
+}
+
+func (s *sharedKey) SealWithNonce(data []byte, nonce []byte) ([]byte, error) {
+	epoch, key, err := s.ring.Current()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get current key")
+	}
+
+	sealed, err := key.SealWithNonce(data, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return withHeader(epoch, sealed), nil
+}
+
+func (s *sharedKey) DeriveNonce(input []byte) ([]byte, error) {
+	_, key, err := s.ring.Current()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get current key")
+	}
+
+	return key.DeriveNonce(input)
+}
+
+func (s *sharedKey) Open(data []byte) ([]byte, error) {
+	if epoch, sealed, ok := splitHeader(data); ok {
+		if key, err := s.ring.Get(epoch); err == nil {
+			if opened, err := key.Open(sealed); err == nil {
+				return opened, nil
+			}
+		}
+
+		// the magic can also be the beginning of legacy data, which is
+		// tried below
+	}
+
+	key, err := s.ring.Get(LegacyEpoch)
+	if err != nil {
+		return nil, errors.New("unable to find a key to open data")
+	}
+
+	return key.Open(data)
+}
+
+// StableKey Returns the key to derive the values which must not change when
+// a shared key is rotated, such as a private topic: the initial key of the
+// ring backing the shared key, or the shared key itself
+func StableKey(key enc.SharedKey) (enc.SharedKey, error) {
+	s, ok := key.(*sharedKey)
+	if !ok {
+		return key, nil
+	}
+
+	ring, ok := s.ring.(InitialKeyInterface)
+	if !ok {
+		return nil, errors.New("the key ring doesn't provide its initial key")
+	}
+
+	_, initial, err := ring.Initial()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get initial key")
+	}
+
+	return initial, nil
+}
+
+func withHeader(epoch string, sealed []byte) []byte {
+	out := make([]byte, 0, len(headerMagic)+1+len(epoch)+len(sealed))
+	out = append(out, headerMagic...)
+	out = append(out, byte(len(epoch)))
+	out = append(out, epoch...)
+
+	return append(out, sealed...)
+}
+
+func splitHeader(data []byte) (string, []byte, bool) {
+	if !bytes.HasPrefix(data, headerMagic) || len(data) < len(headerMagic)+1 {
+		return "", nil, false
+	}
+
+	data = data[len(headerMagic):]
+	epochLen := int(data[0])
+	data = data[1:]
+
+	if len(data) < epochLen {
+		return "", nil, false
+	}
+
+	return string(data[:epochLen]), data[epochLen:], true
+}
+
+var _ Interface = &Ring{}
+var _ InitialKeyInterface = &Ring{}
+var _ enc.SharedKey = &sharedKey{}
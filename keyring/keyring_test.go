@@ -0,0 +1,172 @@
+package keyring
+
+import (
+	"testing"
+
+	"berty.tech/go-ipfs-log/enc"
+	"github.com/stretchr/testify/require"
+)
+
+func testingSecretbox(t *testing.T, seed byte) enc.SharedKey {
+	t.Helper()
+
+	raw := make([]byte, 32)
+	for i := range raw {
+		raw[i] = seed
+	}
+
+	key, err := enc.NewSecretbox(raw)
+	require.NoError(t, err)
+
+	return key
+}
+
+func TestRingRotation(t *testing.T) {
+	key0 := testingSecretbox(t, 0)
+	key1 := testingSecretbox(t, 1)
+
+	ring, err := NewRing("epoch-0", key0)
+	require.NoError(t, err)
+
+	sharedKey, err := NewSharedKey(ring)
+	require.NoError(t, err)
+
+	sealed0, err := sharedKey.Seal([]byte("hello0"))
+	require.NoError(t, err)
+
+	require.NoError(t, ring.Rotate("epoch-1", key1))
+
+	sealed1, err := sharedKey.Seal([]byte("hello1"))
+	require.NoError(t, err)
+
+	opened, err := sharedKey.Open(sealed0)
+	require.NoError(t, err)
+	require.Equal(t, "hello0", string(opened))
+
+	opened, err = sharedKey.Open(sealed1)
+	require.NoError(t, err)
+	require.Equal(t, "hello1", string(opened))
+
+	// the shared key follows the rotations
+	raw, err := sharedKey.Marshal()
+	require.NoError(t, err)
+
+	raw1, err := key1.Marshal()
+	require.NoError(t, err)
+	require.Equal(t, raw1, raw)
+
+	// registering an epoch again is only allowed with the same secret
+	require.NoError(t, ring.Add("epoch-1", testingSecretbox(t, 1)))
+	require.Error(t, ring.Add("epoch-1", testingSecretbox(t, 2)))
+
+	// a key ring which only knows the new epoch can't read older data
+	newMemberRing, err := NewRing("epoch-1", key1)
+	require.NoError(t, err)
+
+	newMemberKey, err := NewSharedKey(newMemberRing)
+	require.NoError(t, err)
+
+	_, err = newMemberKey.Open(sealed0)
+	require.Error(t, err)
+
+	opened, err = newMemberKey.Open(sealed1)
+	require.NoError(t, err)
+	require.Equal(t, "hello1", string(opened))
+}
+
+func TestRingLegacyData(t *testing.T) {
+	legacyKey := testingSecretbox(t, 0)
+
+	legacy, err := legacyKey.Seal([]byte("legacy"))
+	require.NoError(t, err)
+
+	ring, err := NewRing("epoch-1", testingSecretbox(t, 1))
+	require.NoError(t, err)
+
+	sharedKey, err := NewSharedKey(ring)
+	require.NoError(t, err)
+
+	_, err = sharedKey.Open(legacy)
+	require.Error(t, err)
+
+	require.NoError(t, ring.Add(LegacyEpoch, legacyKey))
+
+	opened, err := sharedKey.Open(legacy)
+	require.NoError(t, err)
+	require.Equal(t, "legacy", string(opened))
+}
+
+func TestRingErrors(t *testing.T) {
+	_, err := NewRing("epoch-0", nil)
+	require.Error(t, err)
+
+	ring, err := NewRing("epoch-0", testingSecretbox(t, 0))
+	require.NoError(t, err)
+
+	require.Error(t, ring.Add("epoch-0", testingSecretbox(t, 1)))
+
+	_, err = ring.Get("unknown")
+	require.Error(t, err)
+}
+
+func TestSealForRecipients(t *testing.T) {
+	reader0, err := GenerateRecipientKey()
+	require.NoError(t, err)
+
+	reader1, err := GenerateRecipientKey()
+	require.NoError(t, err)
+
+	outsider, err := GenerateRecipientKey()
+	require.NoError(t, err)
+
+	pub0, err := ParseRecipientID(reader0.ID())
+	require.NoError(t, err)
+	require.Equal(t, reader0.PublicKey, pub0)
+
+	sealed, err := SealForRecipients([]byte("hello"), []*[32]byte{reader0.PublicKey, reader1.PublicKey})
+	require.NoError(t, err)
+	require.True(t, IsSealedForRecipients(sealed))
+
+	for _, reader := range []*RecipientKey{reader0, reader1} {
+		opened, err := OpenForRecipient(sealed, reader)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(opened))
+	}
+
+	_, err = OpenForRecipient(sealed, outsider)
+	require.Error(t, err)
+
+	_, err = SealForRecipients([]byte("hello"), nil)
+	require.Error(t, err)
+}
+
+func TestStableKey(t *testing.T) {
+	key0 := testingSecretbox(t, 0)
+
+	// a key outside of a ring is its own stable key
+	stable, err := StableKey(key0)
+	require.NoError(t, err)
+	require.Equal(t, key0, stable)
+
+	ring, err := NewRing("epoch-0", key0)
+	require.NoError(t, err)
+
+	sharedKey, err := NewSharedKey(ring)
+	require.NoError(t, err)
+
+	before, err := StableKey(sharedKey)
+	require.NoError(t, err)
+
+	require.NoError(t, ring.Rotate("epoch-1", testingSecretbox(t, 1)))
+
+	after, err := StableKey(sharedKey)
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+
+	raw, err := after.Marshal()
+	require.NoError(t, err)
+
+	raw0, err := key0.Marshal()
+	require.NoError(t, err)
+	require.Equal(t, raw0, raw)
+}
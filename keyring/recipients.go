@@ -0,0 +1,136 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+
+	"berty.tech/go-ipfs-log/enc"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// recipientsMagic Prefixes every payload sealed for a set of recipients
+var recipientsMagic = []byte("orc1")
+
+// RecipientKey An X25519 key pair used to open payloads sealed for a set of
+// recipients
+type RecipientKey struct {
+	PublicKey  *[32]byte
+	PrivateKey *[32]byte
+}
+
+// GenerateRecipientKey Generates a new recipient key pair
+func GenerateRecipientKey() (*RecipientKey, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate recipient key")
+	}
+
+	return &RecipientKey{
+		PublicKey:  pub,
+		PrivateKey: priv,
+	}, nil
+}
+
+// ID Returns the public key as expected in the "read" role of access controllers
+func (k *RecipientKey) ID() string {
+	return hex.EncodeToString(k.PublicKey[:])
+}
+
+// ParseRecipientID Parses a public key as returned by RecipientKey.ID
+func ParseRecipientID(id string) (*[32]byte, error) {
+	raw, err := hex.DecodeString(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode recipient id")
+	}
+
+	if len(raw) != 32 {
+		return nil, errors.New("invalid recipient id length")
+	}
+
+	pub := &[32]byte{}
+	copy(pub[:], raw)
+
+	return pub, nil
+}
+
+type recipientsEnvelope struct {
+	Keys    [][]byte `json:"keys,omitempty"`
+	Payload []byte   `json:"payload,omitempty"`
+}
+
+// IsSealedForRecipients Checks if data has been sealed with SealForRecipients
+func IsSealedForRecipients(data []byte) bool {
+	return bytes.HasPrefix(data, recipientsMagic)
+}
+
+// SealForRecipients Encrypts data with a random key which is itself sealed
+// for each of the recipients
+func SealForRecipients(data []byte, recipients []*[32]byte) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipient given")
+	}
+
+	payloadKey := make([]byte, 32)
+	if _, err := rand.Read(payloadKey); err != nil {
+		return nil, errors.Wrap(err, "unable to generate payload key")
+	}
+
+	sharedKey, err := enc.NewSecretbox(payloadKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create payload key")
+	}
+
+	env := &recipientsEnvelope{
+		Keys: make([][]byte, len(recipients)),
+	}
+
+	env.Payload, err = sharedKey.Seal(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to seal payload")
+	}
+
+	for i, recipient := range recipients {
+		env.Keys[i], err = box.SealAnonymous(nil, payloadKey, recipient, rand.Reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to seal payload key")
+		}
+	}
+
+	envBytes, err := json.Marshal(env)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to serialize envelope")
+	}
+
+	return append(append([]byte{}, recipientsMagic...), envBytes...), nil
+}
+
+// OpenForRecipient Decrypts data sealed with SealForRecipients
+func OpenForRecipient(data []byte, key *RecipientKey) ([]byte, error) {
+	if !IsSealedForRecipients(data) {
+		return nil, errors.New("data is not sealed for recipients")
+	}
+
+	env := &recipientsEnvelope{}
+	if err := json.Unmarshal(data[len(recipientsMagic):], env); err != nil {
+		return nil, errors.Wrap(err, "unable to deserialize envelope")
+	}
+
+	for _, sealedKey := range env.Keys {
+		payloadKey, ok := box.OpenAnonymous(nil, sealedKey, key.PublicKey, key.PrivateKey)
+		if !ok {
+			continue
+		}
+
+		sharedKey, err := enc.NewSecretbox(payloadKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read payload key")
+		}
+
+		return sharedKey.Open(env.Payload)
+	}
+
+	return nil, errors.New("data has not been sealed for this recipient")
+}
@@ -51,6 +51,12 @@ type CreateDBOptions = iface.CreateDBOptions
 // DetermineAddressOptions An alias of the type defined in the iface package
 type DetermineAddressOptions = iface.DetermineAddressOptions
 
+// ReplicationServiceOptions An alias of the type defined in the iface package
+type ReplicationServiceOptions = iface.ReplicationServiceOptions
+
+// LocalDatabase An alias of the type defined in the iface package
+type LocalDatabase = iface.LocalDatabase
+
 // NewOrbitDBOptions Options for a new OrbitDB instance
 type NewOrbitDBOptions = baseorbitdb.NewOrbitDBOptions
 
@@ -88,6 +94,9 @@ func (o *orbitDB) Log(ctx context.Context, address string, options *CreateDBOpti
 
 	logStore, ok := store.(EventLogStore)
 	if !ok {
+		// releases the reference taken on a store of another type
+		_ = store.Close()
+
 		return nil, errors.New("unable to cast store to log")
 	}
 
@@ -117,6 +126,9 @@ func (o *orbitDB) KeyValue(ctx context.Context, address string, options *CreateD
 
 	kvStore, ok := store.(KeyValueStore)
 	if !ok {
+		// releases the reference taken on a store of another type
+		_ = store.Close()
+
 		return nil, errors.New("unable to cast store to keyvalue")
 	}
 
@@ -1,6 +1,7 @@
 package directchannel
 
 import (
+	"bufio"
 	"context"
 	"encoding/binary"
 	"fmt"
@@ -21,133 +22,429 @@ import (
 	"berty.tech/go-orbit-db/pubsub"
 )
 
-const PROTOCOL = "/go-orbit-db/ipfs-direct-channel/1.0.0"
+const PROTOCOL = "/go-orbit-db/ipfs-direct-channel/2.0.0"
+
+// legacyProtocol The protocol of older peers, their messages are prefixed by
+// their length on two little endian bytes and can't be larger than 64KiB
+const legacyProtocol = "/go-orbit-db/ipfs-direct-channel/1.0.0"
+
+const (
+	// DefaultMaxMessageSize The default maximum size of a message, larger
+	// messages are rejected by both the sender and the receiver
+	DefaultMaxMessageSize = 16 << 20
+
+	// DefaultReconnectBackoff The default delay before the first attempt to
+	// reconnect a dropped channel, doubled after each failed attempt
+	DefaultReconnectBackoff = time.Millisecond * 500
+
+	// DefaultMaxReconnectBackoff The default maximum delay between two
+	// attempts to reconnect a dropped channel
+	DefaultMaxReconnectBackoff = time.Second * 30
+
+	// DefaultMaxReconnectAttempts The default number of attempts to reconnect
+	// a dropped channel before closing it
+	DefaultMaxReconnectAttempts = 8
+
+	// chunkSize The maximum size of a frame, larger messages are split over
+	// several frames
+	chunkSize = 64 << 10
+
+	// acceptTimeout The maximum delay to wait for a channel to pick an
+	// incoming stream
+	acceptTimeout = time.Second * 5
+)
 
 // Channel Channel is a pubsub used for a direct communication between peers
 // new messages are received via events
 
 type channel struct {
 	events.EventEmitter
-	receiverID p2pcore.PeerID
-	logger     *zap.Logger
-	holder     *channelHolder
-	stream     io.Writer
-	muStream   sync.Mutex
+	ctx            context.Context
+	cancel         context.CancelFunc
+	receiverID     p2pcore.PeerID
+	logger         *zap.Logger
+	holder         *channelHolder
+	maxMessageSize int
+
+	// incoming Receives the streams opened by the other peer, nil when this
+	// side opens the streams
+	incoming chan network.Stream
+
+	stream   network.Stream
+	legacy   bool
+	ready    chan struct{}
+	closed   bool
+	muStream sync.Mutex
+	muWrite  sync.Mutex
+	muDial   sync.Mutex
 }
 
+// Send Sends a message to the other peer, split in frames of at most
+// chunkSize bytes. Each frame is prefixed by a uvarint holding its length
+// shifted left by one, the lowest bit is set when more frames follow
 func (c *channel) Send(ctx context.Context, bytes []byte) error {
 	c.muStream.Lock()
-	stream := c.stream
+	stream, legacy := c.stream, c.legacy
 	c.muStream.Unlock()
 
 	if stream == nil {
 		return fmt.Errorf("stream is not opened")
 	}
 
+	if len(bytes) > c.maxMessageSize {
+		return fmt.Errorf("payload is too large, %d bytes while the maximum is %d", len(bytes), c.maxMessageSize)
+	}
+
+	// frames of concurrent messages must not interleave
+	c.muWrite.Lock()
+	defer c.muWrite.Unlock()
+
+	if legacy {
+		return c.sendLegacy(stream, bytes)
+	}
+
+	header := make([]byte, binary.MaxVarintLen64)
+
+	for {
+		chunk, more := bytes, false
+		if len(chunk) > chunkSize {
+			chunk, more = chunk[:chunkSize], true
+		}
+
+		value := uint64(len(chunk)) << 1
+		if more {
+			value |= 1
+		}
+
+		n := binary.PutUvarint(header, value)
+		if _, err := stream.Write(append(header[:n:n], chunk...)); err != nil {
+			c.dropStream(stream)
+			return err
+		}
+
+		if !more {
+			return nil
+		}
+
+		bytes = bytes[len(chunk):]
+	}
+}
+
+// sendLegacy Sends a message to a peer using legacyProtocol
+func (c *channel) sendLegacy(stream network.Stream, bytes []byte) error {
 	if len(bytes) > math.MaxUint16 {
-		return fmt.Errorf("payload is too large")
+		return fmt.Errorf("payload is too large for the legacy protocol, %d bytes while the maximum is %d", len(bytes), math.MaxUint16)
 	}
 
-	b := make([]byte, 2)
-	binary.LittleEndian.PutUint16(b, uint16(len(bytes)))
+	header := make([]byte, 2)
+	binary.LittleEndian.PutUint16(header, uint16(len(bytes)))
 
-	if _, err := stream.Write(b); err != nil {
+	if _, err := stream.Write(append(header, bytes...)); err != nil {
+		c.dropStream(stream)
 		return err
 	}
 
-	_, err := stream.Write(bytes)
-	return err
+	return nil
 }
 
+// Close Closes the stream and stops reconnecting, the channel can't be used
+// afterwards
 func (c *channel) Close() error {
 	c.muStream.Lock()
+	if c.closed {
+		c.muStream.Unlock()
+		return nil
+	}
+
+	c.closed = true
+	stream := c.stream
 	c.stream = nil
 	c.muStream.Unlock()
 
+	// the channel context is done once cancelled, or already when the parent
+	// context ended
+	c.Emit(context.Background(), pubsub.NewEventDirectChannelDisconnected(c.receiverID, true))
+
+	c.cancel()
+	c.holder.removeExpected(c)
+
+	if stream != nil {
+		if err := stream.Close(); err != nil {
+			_ = stream.Reset()
+		}
+	}
+
 	return nil
 }
 
+// Connect Waits for the stream with the other peer to be opened, returns
+// immediately when it already is
 func (c *channel) Connect(ctx context.Context) error {
-	var (
-		err    error
-		stream network.Stream
-		id     protocol.ID
-	)
-
-	if strings.Compare(c.holder.host.ID().String(), c.receiverID.String()) < 0 {
-		ctx, cancel := context.WithTimeout(ctx, time.Second*5)
-		id = c.holder.hostProtocolID(c.receiverID)
-		c.holder.muExpected.Lock()
-		streamChan := c.holder.expectedPID[id]
-		c.holder.muExpected.Unlock()
+	c.muStream.Lock()
+	if c.closed {
+		c.muStream.Unlock()
+		return fmt.Errorf("channel is closed")
+	}
+
+	if c.stream != nil {
+		c.muStream.Unlock()
+		return nil
+	}
+
+	ready := c.ready
+	c.muStream.Unlock()
 
+	if c.incoming == nil {
+		return c.dial(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, acceptTimeout)
+	defer cancel()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("unable to create stream, err: %w", ctx.Err())
+	}
+}
+
+// dial Opens a stream with the other peer
+func (c *channel) dial(ctx context.Context) error {
+	c.muDial.Lock()
+	defer c.muDial.Unlock()
+
+	c.muStream.Lock()
+	connected := c.stream != nil
+	c.muStream.Unlock()
+
+	if connected {
+		return nil
+	}
+
+	// the current protocol is preferred, older peers only support the
+	// legacy one
+	self := c.holder.host.ID()
+	stream, err := c.holder.host.NewStream(ctx, c.receiverID, hostProtocolID(PROTOCOL, self), hostProtocolID(legacyProtocol, self))
+	if err != nil {
+		return err
+	}
+
+	c.setStream(stream)
+
+	return nil
+}
+
+// acceptStreams Picks the streams opened by the other peer, a new stream
+// replaces the current one as the other peer may have restarted
+func (c *channel) acceptStreams() {
+	for {
 		select {
-		case stream = <-streamChan:
-			// nothing else to do, stream is acquired
-		case <-ctx.Done():
-			cancel()
-			return fmt.Errorf("unable to create stream, err: %w", ctx.Err())
-		}
+		case stream := <-c.incoming:
+			c.setStream(stream)
 
-		cancel()
-	} else {
-		id = c.holder.hostProtocolID(c.holder.host.ID())
-		stream, err = c.holder.host.NewStream(ctx, c.receiverID, id)
-		if err != nil {
-			return err
+		case <-c.ctx.Done():
+			return
 		}
 	}
+}
+
+// setStream Makes a stream the current one and starts reading from it
+func (c *channel) setStream(stream network.Stream) {
+	legacy := isLegacyStream(stream)
 
 	c.muStream.Lock()
+	if c.closed {
+		c.muStream.Unlock()
+		_ = stream.Reset()
+		return
+	}
+
+	previous := c.stream
 	c.stream = stream
+	c.legacy = legacy
+
+	if previous == nil {
+		close(c.ready)
+	}
 	c.muStream.Unlock()
 
-	go func() {
-		for {
-			if err := c.incomingEvent(ctx, stream); err == io.EOF {
-				return
-			} else if err != nil {
-				c.logger.Error("error while receiving event", zap.Error(err))
-				return
-			}
+	if previous != nil {
+		_ = previous.Reset()
+	}
+
+	c.Emit(c.ctx, pubsub.NewEventDirectChannelConnected(c.receiverID))
+
+	go c.readStream(stream, legacy)
+}
+
+// dropStream Resets a failing stream and starts reconnecting, unless the
+// stream has already been replaced
+func (c *channel) dropStream(stream network.Stream) {
+	c.muStream.Lock()
+	if c.closed || c.stream != stream {
+		c.muStream.Unlock()
+		return
+	}
+
+	c.stream = nil
+	c.ready = make(chan struct{})
+	c.muStream.Unlock()
+
+	_ = stream.Reset()
+
+	c.Emit(c.ctx, pubsub.NewEventDirectChannelDisconnected(c.receiverID, false))
+
+	go c.reconnect()
+}
+
+// reconnect Tries to connect again with an exponential backoff, the channel
+// is closed once all the attempts failed
+func (c *channel) reconnect() {
+	backoff := c.holder.reconnectBackoff
+
+	for attempt := 1; attempt <= c.holder.maxReconnectAttempts; attempt++ {
+		select {
+		case <-time.After(backoff):
+		case <-c.ctx.Done():
+			return
 		}
-	}()
 
-	return nil
+		err := c.Connect(c.ctx)
+		if err == nil {
+			return
+		}
+
+		c.logger.Debug("unable to reconnect direct channel", zap.String("peer", c.receiverID.String()), zap.Int("attempt", attempt), zap.Error(err))
+
+		backoff *= 2
+		if backoff > c.holder.maxReconnectBackoff {
+			backoff = c.holder.maxReconnectBackoff
+		}
+	}
+
+	c.logger.Debug("giving up reconnecting direct channel", zap.String("peer", c.receiverID.String()))
+
+	_ = c.Close()
+}
+
+func (c *channel) readStream(stream network.Stream, legacy bool) {
+	reader := bufio.NewReader(stream)
+
+	read := c.incomingEvent
+	if legacy {
+		read = c.incomingLegacyEvent
+	}
+
+	for {
+		if err := read(reader); err != nil {
+			if err != io.EOF {
+				c.logger.Debug("error while receiving event", zap.Error(err))
+			}
+
+			c.dropStream(stream)
+
+			return
+		}
+	}
 }
 
-func (c *channel) incomingEvent(ctx context.Context, stream network.Stream) error {
-	b := make([]byte, 2)
+// incomingEvent Reads the frames of a message and emits it, see Send
+func (c *channel) incomingEvent(reader *bufio.Reader) error {
+	var data []byte
 
-	if _, err := stream.Read(b); err != nil {
-		if err == io.EOF {
+	for {
+		value, err := binary.ReadUvarint(reader)
+		if err != nil {
+			if err == io.EOF && len(data) == 0 {
+				return err
+			}
+
+			c.logger.Error("unable to read frame header", zap.Error(err))
 			return err
 		}
 
-		c.logger.Error("unable to read", zap.Error(err))
-		return err
-	}
+		size, more := value>>1, value&1 == 1
+		if size > chunkSize || uint64(len(data))+size > uint64(c.maxMessageSize) {
+			return fmt.Errorf("received message is too large")
+		}
 
-	data := make([]byte, binary.LittleEndian.Uint16(b))
-	_, err := stream.Read(data)
-	if err != nil {
-		if err == io.EOF {
+		offset := len(data)
+		data = append(data, make([]byte, size)...)
+
+		if _, err := io.ReadFull(reader, data[offset:]); err != nil {
+			c.logger.Error("unable to read frame", zap.Error(err))
 			return err
 		}
 
-		c.logger.Error("unable to read", zap.Error(err))
+		if !more {
+			break
+		}
+	}
+
+	c.Emit(c.ctx, pubsub.NewEventPayload(data))
+
+	return nil
+}
+
+// incomingLegacyEvent Reads a message sent using legacyProtocol and emits it
+func (c *channel) incomingLegacyEvent(reader *bufio.Reader) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err != io.EOF {
+			c.logger.Error("unable to read message length", zap.Error(err))
+		}
+
+		return err
+	}
+
+	data := make([]byte, binary.LittleEndian.Uint16(header))
+	if _, err := io.ReadFull(reader, data); err != nil {
+		c.logger.Error("unable to read message", zap.Error(err))
 		return err
 	}
 
-	c.Emit(ctx, pubsub.NewEventPayload(data))
+	c.Emit(c.ctx, pubsub.NewEventPayload(data))
 
 	return nil
 }
 
 type channelHolder struct {
-	expectedPID map[protocol.ID]chan network.Stream
-	host        host.Host
-	muExpected  sync.Mutex
+	expectedPID          map[protocol.ID]chan network.Stream
+	host                 host.Host
+	maxMessageSize       int
+	reconnectBackoff     time.Duration
+	maxReconnectBackoff  time.Duration
+	maxReconnectAttempts int
+	muExpected           sync.Mutex
+}
+
+// Option Configures the channels created by a factory
+type Option func(*channelHolder)
+
+// WithMaxMessageSize Sets the maximum size of the messages sent and received
+// by the channels, unless overridden by their DirectChannelOptions
+func WithMaxMessageSize(size int) Option {
+	return func(h *channelHolder) {
+		h.maxMessageSize = size
+	}
+}
+
+// WithReconnectBackoff Sets the delay before the first attempt to reconnect
+// a dropped channel and the maximum delay between two attempts
+func WithReconnectBackoff(backoff, maxBackoff time.Duration) Option {
+	return func(h *channelHolder) {
+		h.reconnectBackoff = backoff
+		h.maxReconnectBackoff = maxBackoff
+	}
+}
+
+// WithMaxReconnectAttempts Sets the number of attempts to reconnect a
+// dropped channel before closing it
+func WithMaxReconnectAttempts(attempts int) Option {
+	return func(h *channelHolder) {
+		h.maxReconnectAttempts = attempts
+	}
 }
 
 func (h *channelHolder) NewChannel(ctx context.Context, receiver p2pcore.PeerID, opts *iface.DirectChannelOptions) (iface.DirectChannel, error) {
@@ -159,36 +456,79 @@ func (h *channelHolder) NewChannel(ctx context.Context, receiver p2pcore.PeerID,
 		opts.Logger = zap.NewNop()
 	}
 
+	if opts.MaxMessageSize <= 0 {
+		opts.MaxMessageSize = h.maxMessageSize
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
 	ch := &channel{
-		receiverID: receiver,
-		logger:     opts.Logger,
-		holder:     h,
+		ctx:            ctx,
+		cancel:         cancel,
+		receiverID:     receiver,
+		logger:         opts.Logger,
+		holder:         h,
+		maxMessageSize: opts.MaxMessageSize,
+		ready:          make(chan struct{}),
 	}
 
 	if strings.Compare(h.host.ID().String(), receiver.String()) < 0 {
-		id := h.hostProtocolID(receiver)
+		ch.incoming = make(chan network.Stream)
+
 		h.muExpected.Lock()
-		h.expectedPID[id] = make(chan network.Stream)
+		h.expectedPID[hostProtocolID(PROTOCOL, receiver)] = ch.incoming
 		h.muExpected.Unlock()
 
-		go func() {
-			<-ctx.Done()
-			h.muExpected.Lock()
-			delete(h.expectedPID, id)
-			h.muExpected.Unlock()
-		}()
+		go ch.acceptStreams()
 	}
 
+	go func() {
+		<-ctx.Done()
+		_ = ch.Close()
+	}()
+
 	return ch, nil
 }
 
-func (h *channelHolder) hostProtocolID(receiver p2pcore.PeerID) protocol.ID {
-	return protocol.ID(fmt.Sprintf("%s/%s", PROTOCOL, receiver.String()))
+// removeExpected Stops routing the incoming streams to a closed channel
+func (h *channelHolder) removeExpected(c *channel) {
+	if c.incoming == nil {
+		return
+	}
+
+	id := hostProtocolID(PROTOCOL, c.receiverID)
+
+	h.muExpected.Lock()
+	defer h.muExpected.Unlock()
+
+	// a new channel may have been created for the same peer
+	if h.expectedPID[id] == c.incoming {
+		delete(h.expectedPID, id)
+	}
+}
+
+func hostProtocolID(proto string, receiver p2pcore.PeerID) protocol.ID {
+	return protocol.ID(fmt.Sprintf("%s/%s", proto, receiver.String()))
+}
+
+// isLegacyStream Returns whether a stream uses legacyProtocol
+func isLegacyStream(stream network.Stream) bool {
+	return strings.HasPrefix(string(stream.Protocol()), legacyProtocol+"/")
+}
+
+// expectedProtocolID Returns the key of the channel expecting a stream, the
+// streams of both protocols are routed to the same channel
+func expectedProtocolID(id string) protocol.ID {
+	if strings.HasPrefix(id, legacyProtocol+"/") {
+		return protocol.ID(PROTOCOL + strings.TrimPrefix(id, legacyProtocol))
+	}
+
+	return protocol.ID(id)
 }
 
 func (h *channelHolder) checkExpectedStream(s string) bool {
 	h.muExpected.Lock()
-	_, ok := h.expectedPID[protocol.ID(s)]
+	_, ok := h.expectedPID[expectedProtocolID(s)]
 	h.muExpected.Unlock()
 
 	return ok
@@ -196,23 +536,41 @@ func (h *channelHolder) checkExpectedStream(s string) bool {
 
 func (h *channelHolder) incomingConnHandler(stream network.Stream) {
 	h.muExpected.Lock()
-	ch, ok := h.expectedPID[stream.Protocol()]
+	ch, ok := h.expectedPID[expectedProtocolID(string(stream.Protocol()))]
 	h.muExpected.Unlock()
 
 	if !ok {
+		_ = stream.Reset()
 		return
 	}
 
-	ch <- stream
+	select {
+	case ch <- stream:
+	case <-time.After(acceptTimeout):
+		_ = stream.Reset()
+	}
 }
 
-func InitDirectChannelFactory(host host.Host) iface.DirectChannelFactory {
+func InitDirectChannelFactory(host host.Host, opts ...Option) iface.DirectChannelFactory {
 	holder := &channelHolder{
-		expectedPID: map[protocol.ID]chan network.Stream{},
-		host:        host,
+		expectedPID:          map[protocol.ID]chan network.Stream{},
+		host:                 host,
+		maxMessageSize:       DefaultMaxMessageSize,
+		reconnectBackoff:     DefaultReconnectBackoff,
+		maxReconnectBackoff:  DefaultMaxReconnectBackoff,
+		maxReconnectAttempts: DefaultMaxReconnectAttempts,
+	}
+
+	for _, opt := range opts {
+		opt(holder)
+	}
+
+	if holder.maxMessageSize <= 0 {
+		holder.maxMessageSize = DefaultMaxMessageSize
 	}
 
 	host.SetStreamHandlerMatch(PROTOCOL, holder.checkExpectedStream, holder.incomingConnHandler)
+	host.SetStreamHandlerMatch(legacyProtocol, holder.checkExpectedStream, holder.incomingConnHandler)
 
 	return holder.NewChannel
 }
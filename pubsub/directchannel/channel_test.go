@@ -3,15 +3,20 @@ package directchannel
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"berty.tech/go-orbit-db/events"
 	"berty.tech/go-orbit-db/iface"
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -136,3 +141,242 @@ func TestInitDirectChannelFactory(t *testing.T) {
 		time.Sleep(time.Second * 10)
 	}
 }
+
+func TestDirectChannelLargeMessage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+
+	host1, err := mn.GenPeer()
+	require.NoError(t, err)
+
+	host2, err := mn.GenPeer()
+	require.NoError(t, err)
+
+	maxMessageSize := chunkSize * 8
+
+	factory1 := InitDirectChannelFactory(host1, WithMaxMessageSize(maxMessageSize))
+	factory2 := InitDirectChannelFactory(host2, WithMaxMessageSize(maxMessageSize))
+
+	err = mn.LinkAll()
+	require.NoError(t, err)
+
+	err = mn.ConnectAllButSelf()
+	require.NoError(t, err)
+
+	ch1, err := factory1(ctx, host2.ID(), nil)
+	require.NoError(t, err)
+
+	ch2, err := factory2(ctx, host1.ID(), nil)
+	require.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, ch1.Connect(ctx))
+	}()
+
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, ch2.Connect(ctx))
+	}()
+
+	wg.Wait()
+
+	sub := ch2.Subscribe(ctx)
+
+	// spans several frames, the last one being partial
+	expectedMessage := make([]byte, chunkSize*3+42)
+	for i := range expectedMessage {
+		expectedMessage[i] = byte(i)
+	}
+
+	require.NoError(t, ch1.Send(ctx, expectedMessage))
+	require.NoError(t, ch1.Send(ctx, []byte("small")))
+	require.Error(t, ch1.Send(ctx, make([]byte, maxMessageSize+1)))
+
+	var received [][]byte
+	for evt := range sub {
+		if e, ok := evt.(*iface.EventPubSubPayload); ok {
+			received = append(received, e.Payload)
+		}
+
+		if len(received) == 2 {
+			break
+		}
+	}
+
+	require.Len(t, received, 2)
+	require.Equal(t, expectedMessage, received[0])
+	require.Equal(t, []byte("small"), received[1])
+}
+
+func TestDirectChannelReconnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+
+	host1, err := mn.GenPeer()
+	require.NoError(t, err)
+
+	host2, err := mn.GenPeer()
+	require.NoError(t, err)
+
+	factory1 := InitDirectChannelFactory(host1, WithReconnectBackoff(time.Millisecond*50, time.Millisecond*200))
+	factory2 := InitDirectChannelFactory(host2, WithReconnectBackoff(time.Millisecond*50, time.Millisecond*200))
+
+	err = mn.LinkAll()
+	require.NoError(t, err)
+
+	err = mn.ConnectAllButSelf()
+	require.NoError(t, err)
+
+	connect := func(ch1, ch2 iface.DirectChannel) {
+		wg := sync.WaitGroup{}
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, ch1.Connect(ctx))
+		}()
+
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, ch2.Connect(ctx))
+		}()
+
+		wg.Wait()
+	}
+
+	ch1, err := factory1(ctx, host2.ID(), nil)
+	require.NoError(t, err)
+
+	ch2, err := factory2(ctx, host1.ID(), nil)
+	require.NoError(t, err)
+
+	connect(ch1, ch2)
+
+	sub1 := ch1.Subscribe(ctx)
+	sub2 := ch2.Subscribe(ctx)
+
+	waitFor := func(sub <-chan events.Event, match func(events.Event) bool) {
+		for evt := range sub {
+			if match(evt) {
+				return
+			}
+		}
+
+		t.Fatal("event not received")
+	}
+
+	require.NoError(t, ch1.Close())
+	require.Error(t, ch1.Send(ctx, []byte("closed")))
+	require.Error(t, ch1.Connect(ctx))
+
+	waitFor(sub1, func(evt events.Event) bool {
+		e, ok := evt.(*iface.EventDirectChannelDisconnected)
+		return ok && e.Permanent
+	})
+
+	waitFor(sub2, func(evt events.Event) bool {
+		e, ok := evt.(*iface.EventDirectChannelDisconnected)
+		return ok && !e.Permanent
+	})
+
+	// the peer comes back with a new channel
+	ch1, err = factory1(ctx, host2.ID(), nil)
+	require.NoError(t, err)
+
+	connect(ch1, ch2)
+
+	waitFor(sub2, func(evt events.Event) bool {
+		_, ok := evt.(*iface.EventDirectChannelConnected)
+		return ok
+	})
+
+	require.NoError(t, ch1.Send(ctx, []byte("hello")))
+
+	waitFor(sub2, func(evt events.Event) bool {
+		e, ok := evt.(*iface.EventPubSubPayload)
+		return ok && bytes.Equal(e.Payload, []byte("hello"))
+	})
+}
+
+func TestDirectChannelLegacyPeer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+
+	current, err := mn.GenPeer()
+	require.NoError(t, err)
+
+	legacy, err := mn.GenPeer()
+	require.NoError(t, err)
+
+	factory := InitDirectChannelFactory(current)
+
+	err = mn.LinkAll()
+	require.NoError(t, err)
+
+	err = mn.ConnectAllButSelf()
+	require.NoError(t, err)
+
+	// the legacy peer echoes the messages it receives
+	echo := func(stream network.Stream) {
+		defer stream.Close()
+
+		header := make([]byte, 2)
+		for {
+			if _, err := io.ReadFull(stream, header); err != nil {
+				return
+			}
+
+			data := make([]byte, binary.LittleEndian.Uint16(header))
+			if _, err := io.ReadFull(stream, data); err != nil {
+				return
+			}
+
+			if _, err := stream.Write(append(header, data...)); err != nil {
+				return
+			}
+		}
+	}
+
+	ch, err := factory(ctx, legacy.ID(), nil)
+	require.NoError(t, err)
+
+	if strings.Compare(current.ID().String(), legacy.ID().String()) < 0 {
+		// the legacy peer opens the stream
+		go func() {
+			stream, err := legacy.NewStream(ctx, current.ID(), hostProtocolID(legacyProtocol, legacy.ID()))
+			if assert.NoError(t, err) {
+				echo(stream)
+			}
+		}()
+	} else {
+		legacy.SetStreamHandler(hostProtocolID(legacyProtocol, current.ID()), echo)
+	}
+
+	require.NoError(t, ch.Connect(ctx))
+
+	sub := ch.Subscribe(ctx)
+
+	require.NoError(t, ch.Send(ctx, []byte("hello")))
+
+	// the legacy format can't carry larger messages
+	require.Error(t, ch.Send(ctx, make([]byte, math.MaxUint16+1)))
+
+	for evt := range sub {
+		if e, ok := evt.(*iface.EventPubSubPayload); ok {
+			require.Equal(t, []byte("hello"), e.Payload)
+			return
+		}
+	}
+
+	t.Fatal("no message received from the legacy peer")
+}
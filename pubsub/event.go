@@ -14,6 +14,14 @@ func NewEventMessage(content []byte) *iface.EventPubSubMessage {
 	}
 }
 
+// NewEventMessageFrom Creates a new Message event published by the given peer
+func NewEventMessageFrom(from peer.ID, content []byte) *iface.EventPubSubMessage {
+	return &iface.EventPubSubMessage{
+		Content: content,
+		From:    from,
+	}
+}
+
 // NewEventPayload Creates a new Message event
 func NewEventPayload(payload []byte) *iface.EventPubSubPayload {
 	return &iface.EventPubSubPayload{
@@ -34,3 +42,18 @@ func NewEventPeerLeave(p peer.ID) events.Event {
 		Peer: p,
 	}
 }
+
+// NewEventDirectChannelConnected creates a new EventDirectChannelConnected event
+func NewEventDirectChannelConnected(p peer.ID) events.Event {
+	return &iface.EventDirectChannelConnected{
+		Peer: p,
+	}
+}
+
+// NewEventDirectChannelDisconnected creates a new EventDirectChannelDisconnected event
+func NewEventDirectChannelDisconnected(p peer.ID, permanent bool) events.Event {
+	return &iface.EventDirectChannelDisconnected{
+		Peer:      p,
+		Permanent: permanent,
+	}
+}
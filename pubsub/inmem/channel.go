@@ -0,0 +1,178 @@
+package inmem
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	p2pcore "github.com/libp2p/go-libp2p-core"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"berty.tech/go-orbit-db/events"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/pubsub"
+)
+
+const (
+	// connectTimeout The maximum delay to wait for the other peer to create
+	// its side of a channel
+	connectTimeout = time.Second * 5
+
+	// connectPollInterval The delay between two checks of the other side of
+	// a channel while connecting
+	connectPollInterval = time.Millisecond * 10
+)
+
+type channel struct {
+	events.EventEmitter
+	network  *Network
+	ctx      context.Context
+	cancel   context.CancelFunc
+	id       peer.ID
+	receiver peer.ID
+	closed   bool
+	mu       sync.Mutex
+}
+
+// DirectChannelFactory Returns the direct channel factory of a peer of the
+// network
+func (n *Network) DirectChannelFactory(id peer.ID) iface.DirectChannelFactory {
+	return func(ctx context.Context, receiver p2pcore.PeerID, _ *iface.DirectChannelOptions) (iface.DirectChannel, error) {
+		ctx, cancel := context.WithCancel(ctx)
+
+		c := &channel{
+			network:  n,
+			ctx:      ctx,
+			cancel:   cancel,
+			id:       id,
+			receiver: receiver,
+		}
+
+		n.mu.Lock()
+		if _, ok := n.channels[id]; !ok {
+			n.channels[id] = map[peer.ID]*channel{}
+		}
+
+		previous := n.channels[id][receiver]
+		n.channels[id][receiver] = c
+		other := n.channels[receiver][id]
+		n.mu.Unlock()
+
+		if previous != nil {
+			_ = previous.Close()
+		}
+
+		if other != nil {
+			other.Emit(other.ctx, pubsub.NewEventDirectChannelConnected(id))
+			c.Emit(ctx, pubsub.NewEventDirectChannelConnected(receiver))
+		}
+
+		go func() {
+			<-ctx.Done()
+			_ = c.Close()
+		}()
+
+		return c, nil
+	}
+}
+
+// counterpart Returns the channel of the other peer, nil when it has not
+// been created or is unreachable
+func (c *channel) counterpart() (*channel, error) {
+	c.network.mu.RLock()
+	defer c.network.mu.RUnlock()
+
+	other, ok := c.network.channels[c.receiver][c.id]
+	if !ok {
+		return nil, fmt.Errorf("peer is not connected")
+	}
+
+	if !c.network.reachable(c.id, c.receiver) {
+		return nil, fmt.Errorf("peer is unreachable")
+	}
+
+	return other, nil
+}
+
+func (c *channel) Connect(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	for {
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+
+		if closed {
+			return fmt.Errorf("channel is closed")
+		}
+
+		if _, err := c.counterpart(); err == nil {
+			return nil
+		}
+
+		select {
+		case <-time.After(connectPollInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("unable to connect, err: %w", ctx.Err())
+		}
+	}
+}
+
+func (c *channel) Send(ctx context.Context, data []byte) error {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+
+	if closed {
+		return fmt.Errorf("channel is closed")
+	}
+
+	if _, err := c.counterpart(); err != nil {
+		return err
+	}
+
+	payload := append([]byte(nil), data...)
+
+	c.network.deliver(c.id, c.receiver, func() {
+		// the other side may have been replaced while the message was in
+		// flight
+		if other, err := c.counterpart(); err == nil {
+			other.Emit(other.ctx, pubsub.NewEventPayload(payload))
+		}
+	})
+
+	return nil
+}
+
+func (c *channel) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+
+	c.closed = true
+	c.mu.Unlock()
+
+	c.cancel()
+
+	c.network.mu.Lock()
+	if c.network.channels[c.id][c.receiver] == c {
+		delete(c.network.channels[c.id], c.receiver)
+	}
+
+	other := c.network.channels[c.receiver][c.id]
+	c.network.mu.Unlock()
+
+	if other != nil {
+		other.Emit(other.ctx, pubsub.NewEventDirectChannelDisconnected(c.id, false))
+	}
+
+	c.Emit(c.ctx, pubsub.NewEventDirectChannelDisconnected(c.receiver, true))
+
+	return nil
+}
+
+var _ iface.DirectChannel = &channel{}
@@ -0,0 +1,3 @@
+// inmem an in-process pubsub and direct channel implementation for tests,
+// with configurable latency, drops and partitions
+package inmem
@@ -0,0 +1,218 @@
+package inmem
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"berty.tech/go-orbit-db/iface"
+)
+
+// linkQueueSize The number of messages in flight between two peers before
+// the sender blocks
+const linkQueueSize = 1024
+
+// Network Routes the messages of the pubsubs and direct channels created
+// from it, between peers living in the same process
+type Network struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	latency  time.Duration
+	dropRate float64
+	groups   map[peer.ID]int
+	random   *rand.Rand
+
+	topics     map[string]map[peer.ID]*psTopic
+	validators map[peer.ID]map[string]iface.PubSubTopicValidator
+	channels   map[peer.ID]map[peer.ID]*channel
+	links      map[link]chan delivery
+
+	mu       sync.RWMutex
+	muRandom sync.Mutex
+}
+
+type link struct {
+	from peer.ID
+	to   peer.ID
+}
+
+type delivery struct {
+	at      time.Time
+	deliver func()
+}
+
+// NewNetwork Creates an empty network, without latency, drops or partitions
+func NewNetwork() *Network {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Network{
+		ctx:        ctx,
+		cancel:     cancel,
+		groups:     map[peer.ID]int{},
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		topics:     map[string]map[peer.ID]*psTopic{},
+		validators: map[peer.ID]map[string]iface.PubSubTopicValidator{},
+		channels:   map[peer.ID]map[peer.ID]*channel{},
+		links:      map[link]chan delivery{},
+	}
+}
+
+// SetLatency Sets the delay before a message reaches its recipients
+func (n *Network) SetLatency(latency time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.latency = latency
+}
+
+// SetDropRate Sets the probability, between 0 and 1, of a message being
+// silently lost, for both pubsub messages and direct channel messages
+func (n *Network) SetDropRate(rate float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.dropRate = rate
+}
+
+// Partition Splits the network in groups of peers which can't reach each
+// other, the peers not listed form a group of their own
+func (n *Network) Partition(groups ...[]peer.ID) {
+	n.mu.Lock()
+
+	before := n.reachability()
+
+	n.groups = map[peer.ID]int{}
+	for i, group := range groups {
+		for _, p := range group {
+			n.groups[p] = i + 1
+		}
+	}
+
+	changes := n.reachabilityChanges(before)
+	n.mu.Unlock()
+
+	for _, notify := range changes {
+		notify()
+	}
+}
+
+// Heal Removes the partitions
+func (n *Network) Heal() {
+	n.Partition()
+}
+
+// Close Stops delivering the messages
+func (n *Network) Close() {
+	n.cancel()
+}
+
+// reachable Returns whether two peers can exchange messages, the caller
+// must hold the lock
+func (n *Network) reachable(a, b peer.ID) bool {
+	return n.groups[a] == n.groups[b]
+}
+
+// reachability Returns the pairs of topic members which can reach each
+// other, the caller must hold the lock
+func (n *Network) reachability() map[*psTopic]map[peer.ID]bool {
+	result := map[*psTopic]map[peer.ID]bool{}
+
+	for _, members := range n.topics {
+		for _, t := range members {
+			result[t] = map[peer.ID]bool{}
+
+			for id := range members {
+				if id != t.id {
+					result[t][id] = n.reachable(t.id, id)
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// reachabilityChanges Returns the notifications of peers joining or leaving
+// the topics following a partition change, the caller must hold the lock
+func (n *Network) reachabilityChanges(before map[*psTopic]map[peer.ID]bool) []func() {
+	var changes []func()
+
+	for t, peers := range n.reachability() {
+		for id, reachable := range peers {
+			if before[t][id] == reachable {
+				continue
+			}
+
+			t, id, reachable := t, id, reachable
+			changes = append(changes, func() { t.notifyPeer(id, reachable) })
+		}
+	}
+
+	return changes
+}
+
+// deliver Runs a delivery function after the latency of the network,
+// messages between two peers are delivered in order. Returns false when the
+// message has been dropped
+func (n *Network) deliver(from, to peer.ID, deliver func()) bool {
+	n.mu.Lock()
+
+	if !n.reachable(from, to) {
+		n.mu.Unlock()
+		return false
+	}
+
+	dropRate, latency := n.dropRate, n.latency
+
+	l := link{from: from, to: to}
+	queue, ok := n.links[l]
+	if !ok {
+		queue = make(chan delivery, linkQueueSize)
+		n.links[l] = queue
+
+		go n.runLink(queue)
+	}
+
+	n.mu.Unlock()
+
+	if dropRate > 0 {
+		n.muRandom.Lock()
+		dropped := n.random.Float64() < dropRate
+		n.muRandom.Unlock()
+
+		if dropped {
+			return false
+		}
+	}
+
+	select {
+	case queue <- delivery{at: time.Now().Add(latency), deliver: deliver}:
+		return true
+	case <-n.ctx.Done():
+		return false
+	}
+}
+
+func (n *Network) runLink(queue chan delivery) {
+	for {
+		select {
+		case d := <-queue:
+			if wait := time.Until(d.at); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-n.ctx.Done():
+					return
+				}
+			}
+
+			d.deliver()
+
+		case <-n.ctx.Done():
+			return
+		}
+	}
+}
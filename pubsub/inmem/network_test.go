@@ -0,0 +1,182 @@
+package inmem
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/go-orbit-db/iface"
+)
+
+func receiveMessage(t *testing.T, ch <-chan *iface.EventPubSubMessage, timeout time.Duration) *iface.EventPubSubMessage {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	network := NewNetwork()
+	defer network.Close()
+
+	peer1, peer2 := peer.ID("peer1"), peer.ID("peer2")
+
+	topic1, err := network.PubSub(peer1).TopicSubscribe(ctx, "topic")
+	require.NoError(t, err)
+
+	joins, err := topic1.WatchPeers(ctx)
+	require.NoError(t, err)
+
+	topic2, err := network.PubSub(peer2).TopicSubscribe(ctx, "topic")
+	require.NoError(t, err)
+
+	evt := <-joins
+	require.Equal(t, peer2, evt.(*iface.EventPubSubJoin).Peer)
+
+	peers, err := topic1.Peers(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []peer.ID{peer2}, peers)
+
+	msgs, err := topic2.WatchMessages(ctx)
+	require.NoError(t, err)
+
+	network.SetLatency(time.Millisecond * 100)
+
+	start := time.Now()
+	require.NoError(t, topic1.Publish(ctx, []byte("hello")))
+
+	msg := receiveMessage(t, msgs, time.Second)
+	require.NotNil(t, msg)
+	require.Equal(t, []byte("hello"), msg.Content)
+	require.Equal(t, peer1, msg.From)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Millisecond*100))
+
+	network.SetLatency(0)
+
+	// partitioned peers leave the topic and stop receiving messages
+	network.Partition([]peer.ID{peer1}, []peer.ID{peer2})
+
+	evt = <-joins
+	require.Equal(t, peer2, evt.(*iface.EventPubSubLeave).Peer)
+
+	peers, err = topic1.Peers(ctx)
+	require.NoError(t, err)
+	require.Empty(t, peers)
+
+	require.NoError(t, topic1.Publish(ctx, []byte("partitioned")))
+	require.Nil(t, receiveMessage(t, msgs, time.Millisecond*100))
+
+	network.Heal()
+
+	evt = <-joins
+	require.Equal(t, peer2, evt.(*iface.EventPubSubJoin).Peer)
+
+	network.SetDropRate(1)
+	require.NoError(t, topic1.Publish(ctx, []byte("dropped")))
+	require.Nil(t, receiveMessage(t, msgs, time.Millisecond*100))
+
+	network.SetDropRate(0)
+	require.NoError(t, topic1.Publish(ctx, []byte("healed")))
+
+	msg = receiveMessage(t, msgs, time.Second)
+	require.NotNil(t, msg)
+	require.Equal(t, []byte("healed"), msg.Content)
+}
+
+func TestPubSubLeave(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	network := NewNetwork()
+	defer network.Close()
+
+	peer1, peer2 := peer.ID("peer1"), peer.ID("peer2")
+
+	topic1, err := network.PubSub(peer1).TopicSubscribe(ctx, "topic")
+	require.NoError(t, err)
+
+	joins, err := topic1.WatchPeers(ctx)
+	require.NoError(t, err)
+
+	ps2 := network.PubSub(peer2)
+
+	topic2, err := ps2.TopicSubscribe(ctx, "topic")
+	require.NoError(t, err)
+
+	evt := <-joins
+	require.Equal(t, peer2, evt.(*iface.EventPubSubJoin).Peer)
+
+	msgs, err := topic2.WatchMessages(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, ps2.(iface.PubSubLeaveInterface).TopicLeave(ctx, "topic"))
+
+	evt = <-joins
+	require.Equal(t, peer2, evt.(*iface.EventPubSubLeave).Peer)
+
+	// the watchers of the peer which left are closed
+	_, ok := <-msgs
+	require.False(t, ok)
+
+	peers, err := topic1.Peers(ctx)
+	require.NoError(t, err)
+	require.Empty(t, peers)
+}
+
+func TestDirectChannel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	network := NewNetwork()
+	defer network.Close()
+
+	peer1, peer2 := peer.ID("peer1"), peer.ID("peer2")
+
+	ch1, err := network.DirectChannelFactory(peer1)(ctx, peer2, nil)
+	require.NoError(t, err)
+
+	// the other side doesn't exist yet
+	require.Error(t, ch1.Send(ctx, []byte("too early")))
+
+	ch2, err := network.DirectChannelFactory(peer2)(ctx, peer1, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, ch1.Connect(ctx))
+	require.NoError(t, ch2.Connect(ctx))
+
+	sub := ch2.Subscribe(ctx)
+
+	require.NoError(t, ch1.Send(ctx, []byte("hello")))
+
+	for evt := range sub {
+		if e, ok := evt.(*iface.EventPubSubPayload); ok {
+			require.Equal(t, []byte("hello"), e.Payload)
+			break
+		}
+	}
+
+	network.Partition([]peer.ID{peer1})
+	require.Error(t, ch1.Send(ctx, []byte("partitioned")))
+	network.Heal()
+
+	require.NoError(t, ch1.Close())
+	require.Error(t, ch1.Send(ctx, []byte("closed")))
+
+	for evt := range sub {
+		if e, ok := evt.(*iface.EventDirectChannelDisconnected); ok {
+			require.Equal(t, peer1, e.Peer)
+			require.False(t, e.Permanent)
+			break
+		}
+	}
+}
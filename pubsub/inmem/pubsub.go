@@ -0,0 +1,372 @@
+package inmem
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"berty.tech/go-orbit-db/events"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/pubsub"
+)
+
+// watcherBufferSize The number of events buffered for a watcher
+const watcherBufferSize = 128
+
+type inmemPubSub struct {
+	network *Network
+	id      peer.ID
+}
+
+type psTopic struct {
+	network *Network
+	id      peer.ID
+	name    string
+
+	peerWatchers    map[*watcher]struct{}
+	messageWatchers map[*watcher]struct{}
+	muWatchers      sync.Mutex
+}
+
+type watcher struct {
+	ctx    context.Context
+	peers  chan events.Event
+	msgs   chan *iface.EventPubSubMessage
+	closed bool
+	mu     sync.Mutex
+}
+
+// PubSub Returns the pubsub of a peer of the network
+func (n *Network) PubSub(id peer.ID) iface.PubSubInterface {
+	return &inmemPubSub{
+		network: n,
+		id:      id,
+	}
+}
+
+func (p *inmemPubSub) TopicSubscribe(_ context.Context, topic string) (iface.PubSubTopic, error) {
+	p.network.mu.Lock()
+
+	members, ok := p.network.topics[topic]
+	if !ok {
+		members = map[peer.ID]*psTopic{}
+		p.network.topics[topic] = members
+	}
+
+	if t, ok := members[p.id]; ok {
+		p.network.mu.Unlock()
+		return t, nil
+	}
+
+	t := &psTopic{
+		network:         p.network,
+		id:              p.id,
+		name:            topic,
+		peerWatchers:    map[*watcher]struct{}{},
+		messageWatchers: map[*watcher]struct{}{},
+	}
+
+	members[p.id] = t
+
+	var others []*psTopic
+	for id, member := range members {
+		if id != p.id && p.network.reachable(p.id, id) {
+			others = append(others, member)
+		}
+	}
+
+	p.network.mu.Unlock()
+
+	for _, member := range others {
+		member.notifyPeer(p.id, true)
+	}
+
+	return t, nil
+}
+
+// TopicLeave Removes the peer from the topic, its watchers are closed and
+// the other members are notified of its departure
+func (p *inmemPubSub) TopicLeave(_ context.Context, topic string) error {
+	p.network.mu.Lock()
+
+	members := p.network.topics[topic]
+
+	t, ok := members[p.id]
+	if !ok {
+		p.network.mu.Unlock()
+		return nil
+	}
+
+	delete(members, p.id)
+	if len(members) == 0 {
+		delete(p.network.topics, topic)
+	}
+
+	var others []*psTopic
+	for id, member := range members {
+		if p.network.reachable(p.id, id) {
+			others = append(others, member)
+		}
+	}
+
+	p.network.mu.Unlock()
+
+	t.closeWatchers()
+
+	for _, member := range others {
+		member.notifyPeer(p.id, false)
+	}
+
+	return nil
+}
+
+// RegisterTopicValidator Registers the validator of a topic, the messages
+// it rejects are not delivered to the peer
+func (p *inmemPubSub) RegisterTopicValidator(topic string, validator iface.PubSubTopicValidator) error {
+	p.network.mu.Lock()
+	defer p.network.mu.Unlock()
+
+	validators, ok := p.network.validators[p.id]
+	if !ok {
+		validators = map[string]iface.PubSubTopicValidator{}
+		p.network.validators[p.id] = validators
+	}
+
+	if _, ok := validators[topic]; ok {
+		return fmt.Errorf("duplicate validator for topic %s", topic)
+	}
+
+	validators[topic] = validator
+
+	return nil
+}
+
+// UnregisterTopicValidator Removes the validator of a topic
+func (p *inmemPubSub) UnregisterTopicValidator(topic string) error {
+	p.network.mu.Lock()
+	defer p.network.mu.Unlock()
+
+	if _, ok := p.network.validators[p.id][topic]; !ok {
+		return fmt.Errorf("no validator for topic %s", topic)
+	}
+
+	delete(p.network.validators[p.id], topic)
+
+	return nil
+}
+
+// validate Returns whether a message published on a topic is accepted by
+// the validator of the receiving peer, if any
+func (n *Network) validate(to peer.ID, topic string, from peer.ID, message []byte) bool {
+	n.mu.RLock()
+	validator, ok := n.validators[to][topic]
+	n.mu.RUnlock()
+
+	if !ok {
+		return true
+	}
+
+	return validator(n.ctx, from, message)
+}
+
+func (t *psTopic) Publish(ctx context.Context, message []byte) error {
+	t.network.mu.RLock()
+	members := make([]*psTopic, 0, len(t.network.topics[t.name]))
+	for id, member := range t.network.topics[t.name] {
+		if id != t.id {
+			members = append(members, member)
+		}
+	}
+	t.network.mu.RUnlock()
+
+	for _, member := range members {
+		data := append([]byte(nil), message...)
+		member := member
+
+		t.network.deliver(t.id, member.id, func() {
+			if !t.network.validate(member.id, t.name, t.id, data) {
+				return
+			}
+
+			member.notifyMessage(pubsub.NewEventMessageFrom(t.id, data))
+		})
+	}
+
+	return nil
+}
+
+func (t *psTopic) Peers(_ context.Context) ([]peer.ID, error) {
+	t.network.mu.RLock()
+	defer t.network.mu.RUnlock()
+
+	var peers []peer.ID
+	for id := range t.network.topics[t.name] {
+		if id != t.id && t.network.reachable(t.id, id) {
+			peers = append(peers, id)
+		}
+	}
+
+	return peers, nil
+}
+
+func (t *psTopic) WatchPeers(ctx context.Context) (<-chan events.Event, error) {
+	w := &watcher{
+		ctx:   ctx,
+		peers: make(chan events.Event, watcherBufferSize),
+	}
+
+	t.muWatchers.Lock()
+	t.peerWatchers[w] = struct{}{}
+	t.muWatchers.Unlock()
+
+	// the peers already on the topic are reported as joining
+	peers, _ := t.Peers(ctx)
+	for _, p := range peers {
+		w.send(pubsub.NewEventPeerJoin(p))
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		t.muWatchers.Lock()
+		delete(t.peerWatchers, w)
+		t.muWatchers.Unlock()
+
+		w.close()
+	}()
+
+	return w.peers, nil
+}
+
+func (t *psTopic) WatchMessages(ctx context.Context) (<-chan *iface.EventPubSubMessage, error) {
+	w := &watcher{
+		ctx:  ctx,
+		msgs: make(chan *iface.EventPubSubMessage, watcherBufferSize),
+	}
+
+	t.muWatchers.Lock()
+	t.messageWatchers[w] = struct{}{}
+	t.muWatchers.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		t.muWatchers.Lock()
+		delete(t.messageWatchers, w)
+		t.muWatchers.Unlock()
+
+		w.close()
+	}()
+
+	return w.msgs, nil
+}
+
+func (t *psTopic) Topic() string {
+	return t.name
+}
+
+// notifyPeer Reports a peer joining or leaving the topic
+func (t *psTopic) notifyPeer(p peer.ID, joined bool) {
+	var evt events.Event
+	if joined {
+		evt = pubsub.NewEventPeerJoin(p)
+	} else {
+		evt = pubsub.NewEventPeerLeave(p)
+	}
+
+	for _, w := range t.watchers(t.peerWatchers) {
+		w.send(evt)
+	}
+}
+
+// notifyMessage Reports a message published on the topic
+func (t *psTopic) notifyMessage(msg *iface.EventPubSubMessage) {
+	for _, w := range t.watchers(t.messageWatchers) {
+		w.sendMessage(msg)
+	}
+}
+
+// closeWatchers Closes the channels of the watchers of the topic
+func (t *psTopic) closeWatchers() {
+	t.muWatchers.Lock()
+	watchers := make([]*watcher, 0, len(t.peerWatchers)+len(t.messageWatchers))
+	for w := range t.peerWatchers {
+		watchers = append(watchers, w)
+	}
+	for w := range t.messageWatchers {
+		watchers = append(watchers, w)
+	}
+	t.peerWatchers = map[*watcher]struct{}{}
+	t.messageWatchers = map[*watcher]struct{}{}
+	t.muWatchers.Unlock()
+
+	for _, w := range watchers {
+		w.close()
+	}
+}
+
+func (t *psTopic) watchers(set map[*watcher]struct{}) []*watcher {
+	t.muWatchers.Lock()
+	defer t.muWatchers.Unlock()
+
+	watchers := make([]*watcher, 0, len(set))
+	for w := range set {
+		watchers = append(watchers, w)
+	}
+
+	return watchers
+}
+
+func (w *watcher) send(evt events.Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.peers <- evt:
+	case <-w.ctx.Done():
+	}
+}
+
+func (w *watcher) sendMessage(msg *iface.EventPubSubMessage) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.msgs <- msg:
+	case <-w.ctx.Done():
+	}
+}
+
+func (w *watcher) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	w.closed = true
+
+	if w.peers != nil {
+		close(w.peers)
+	}
+
+	if w.msgs != nil {
+		close(w.msgs)
+	}
+}
+
+var _ iface.PubSubInterface = &inmemPubSub{}
+var _ iface.PubSubLeaveInterface = &inmemPubSub{}
+var _ iface.PubSubValidatorInterface = &inmemPubSub{}
+var _ iface.PubSubTopic = &psTopic{}
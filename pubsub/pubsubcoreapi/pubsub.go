@@ -9,6 +9,7 @@ import (
 	options "github.com/ipfs/interface-go-ipfs-core/options"
 	p2pcore "github.com/libp2p/go-libp2p-core"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
 	"go.opentelemetry.io/otel/api/trace"
 	"go.uber.org/zap"
 
@@ -22,6 +23,50 @@ type psTopic struct {
 	ps        *coreAPIPubSub
 	members   []peer.ID
 	muMembers sync.RWMutex
+
+	// ctx Ends the watchers of the topic once it is left
+	ctx      context.Context
+	cancel   context.CancelFunc
+	left     bool
+	watchers sync.WaitGroup
+	muLeft   sync.Mutex
+}
+
+// watchContext Returns a context ending with the given one or when the
+// topic is left, the watcher must call done once it has returned
+func (p *psTopic) watchContext(ctx context.Context) (context.Context, func(), error) {
+	p.muLeft.Lock()
+	defer p.muLeft.Unlock()
+
+	if p.left {
+		return nil, nil, errors.New("topic has been left")
+	}
+
+	p.watchers.Add(1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-p.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		cancel()
+		p.watchers.Done()
+	}, nil
+}
+
+// leave Ends the watchers of the topic, closing its subscriptions
+func (p *psTopic) leave() {
+	p.muLeft.Lock()
+	p.left = true
+	p.muLeft.Unlock()
+
+	p.cancel()
+	p.watchers.Wait()
 }
 
 func (p *psTopic) Publish(ctx context.Context, message []byte) error {
@@ -70,22 +115,44 @@ func (p *psTopic) peersDiff(ctx context.Context) (joining, leaving []p2pcore.Pee
 }
 
 func (p *psTopic) WatchPeers(ctx context.Context) (<-chan events.Event, error) {
+	ctx, done, err := p.watchContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	ch := make(chan events.Event, 32)
 	go func() {
+		defer done()
 		defer close(ch)
+
+		send := func(evt events.Event) bool {
+			select {
+			case ch <- evt:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
 		for {
 			joining, leaving, err := p.peersDiff(ctx)
 			if err != nil {
-				p.ps.logger.Error("", zap.Error(err))
+				if ctx.Err() == nil {
+					p.ps.logger.Error("", zap.Error(err))
+				}
 				return
 			}
 
 			for _, p := range joining {
-				ch <- pubsub.NewEventPeerJoin(p)
+				if !send(pubsub.NewEventPeerJoin(p)) {
+					return
+				}
 			}
 
 			for _, p := range leaving {
-				ch <- pubsub.NewEventPeerLeave(p)
+				if !send(pubsub.NewEventPeerLeave(p)) {
+					return
+				}
 			}
 
 			select {
@@ -101,14 +168,23 @@ func (p *psTopic) WatchPeers(ctx context.Context) (<-chan events.Event, error) {
 }
 
 func (p *psTopic) WatchMessages(ctx context.Context) (<-chan *iface.EventPubSubMessage, error) {
+	ctx, done, err := p.watchContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	sub, err := p.ps.api.PubSub().Subscribe(ctx, p.topic)
 	if err != nil {
+		done()
 		return nil, err
 	}
 
 	ch := make(chan *iface.EventPubSubMessage, 128)
 	go func() {
+		defer done()
+		defer func() { _ = sub.Close() }()
 		defer close(ch)
+
 		for {
 			msg, err := sub.Next(ctx)
 			if err != nil {
@@ -130,7 +206,11 @@ func (p *psTopic) WatchMessages(ctx context.Context) (<-chan *iface.EventPubSubM
 				continue
 			}
 
-			ch <- pubsub.NewEventMessage(msg.Data())
+			select {
+			case ch <- pubsub.NewEventMessageFrom(msg.From(), msg.Data()):
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
@@ -159,14 +239,33 @@ func (c *coreAPIPubSub) TopicSubscribe(_ context.Context, topic string) (iface.P
 		return t, nil
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	c.topics[topic] = &psTopic{
-		topic: topic,
-		ps:    c,
+		topic:  topic,
+		ps:     c,
+		ctx:    ctx,
+		cancel: cancel,
 	}
 
 	return c.topics[topic], nil
 }
 
+// TopicLeave Closes the subscriptions to a topic, the other peers see the
+// current peer leaving once none is left
+func (c *coreAPIPubSub) TopicLeave(_ context.Context, topic string) error {
+	c.muTopics.Lock()
+	t, ok := c.topics[topic]
+	delete(c.topics, topic)
+	c.muTopics.Unlock()
+
+	if ok {
+		t.leave()
+	}
+
+	return nil
+}
+
 func NewPubSub(api coreapi.CoreAPI, id peer.ID, pollInterval time.Duration, logger *zap.Logger, tracer trace.Tracer) iface.PubSubInterface {
 	if logger == nil {
 		logger = zap.NewNop()
@@ -187,4 +286,5 @@ func NewPubSub(api coreapi.CoreAPI, id peer.ID, pollInterval time.Duration, logg
 }
 
 var _ iface.PubSubInterface = &coreAPIPubSub{}
+var _ iface.PubSubLeaveInterface = &coreAPIPubSub{}
 var _ iface.PubSubTopic = &psTopic{}
@@ -7,6 +7,7 @@ import (
 	p2pcore "github.com/libp2p/go-libp2p-core"
 	"github.com/libp2p/go-libp2p-core/peer"
 	p2ppubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/pkg/errors"
 	"go.opentelemetry.io/otel/api/trace"
 	"go.uber.org/zap"
 
@@ -19,6 +20,52 @@ type psTopic struct {
 	topic     *p2ppubsub.Topic
 	ps        *rawPubSub
 	topicName string
+
+	// ctx Ends the watchers of the topic once it is left
+	ctx      context.Context
+	cancel   context.CancelFunc
+	left     bool
+	watchers sync.WaitGroup
+	muLeft   sync.Mutex
+}
+
+// watchContext Returns a context ending with the given one or when the
+// topic is left, the watcher must call done once it has returned
+func (p *psTopic) watchContext(ctx context.Context) (context.Context, func(), error) {
+	p.muLeft.Lock()
+	defer p.muLeft.Unlock()
+
+	if p.left {
+		return nil, nil, errors.New("topic has been left")
+	}
+
+	p.watchers.Add(1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-p.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		cancel()
+		p.watchers.Done()
+	}, nil
+}
+
+// leave Ends the watchers of the topic and closes it
+func (p *psTopic) leave() error {
+	p.muLeft.Lock()
+	p.left = true
+	p.muLeft.Unlock()
+
+	p.cancel()
+	p.watchers.Wait()
+
+	return p.topic.Close()
 }
 
 func (p *psTopic) Publish(ctx context.Context, message []byte) error {
@@ -30,26 +77,46 @@ func (p *psTopic) Peers(_ context.Context) ([]p2pcore.PeerID, error) {
 }
 
 func (p *psTopic) WatchPeers(ctx context.Context) (<-chan events.Event, error) {
+	ctx, done, err := p.watchContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	ph, err := p.topic.EventHandler()
 	if err != nil {
+		done()
 		return nil, err
 	}
 
 	ch := make(chan events.Event, 32)
 	go func() {
+		defer done()
+		defer ph.Cancel()
 		defer close(ch)
+
 		for {
 			evt, err := ph.NextPeerEvent(ctx)
 			if err != nil {
-				p.ps.logger.Error("", zap.Error(err))
+				if ctx.Err() == nil {
+					p.ps.logger.Error("", zap.Error(err))
+				}
 				return
 			}
 
+			var e events.Event
 			switch evt.Type {
 			case p2ppubsub.PeerJoin:
-				ch <- pubsub.NewEventPeerJoin(evt.Peer)
+				e = pubsub.NewEventPeerJoin(evt.Peer)
 			case p2ppubsub.PeerLeave:
-				ch <- pubsub.NewEventPeerLeave(evt.Peer)
+				e = pubsub.NewEventPeerLeave(evt.Peer)
+			default:
+				continue
+			}
+
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -58,14 +125,23 @@ func (p *psTopic) WatchPeers(ctx context.Context) (<-chan events.Event, error) {
 }
 
 func (p *psTopic) WatchMessages(ctx context.Context) (<-chan *iface.EventPubSubMessage, error) {
+	ctx, done, err := p.watchContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	sub, err := p.topic.Subscribe()
 	if err != nil {
+		done()
 		return nil, err
 	}
 
 	ch := make(chan *iface.EventPubSubMessage, 128)
 	go func() {
+		defer done()
+		defer sub.Cancel()
 		defer close(ch)
+
 		for {
 			msg, err := sub.Next(ctx)
 			if err != nil {
@@ -87,7 +163,11 @@ func (p *psTopic) WatchMessages(ctx context.Context) (<-chan *iface.EventPubSubM
 				continue
 			}
 
-			ch <- pubsub.NewEventMessage(msg.Data)
+			select {
+			case ch <- pubsub.NewEventMessageFrom(msg.GetFrom(), msg.Data):
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
@@ -120,15 +200,47 @@ func (c *rawPubSub) TopicSubscribe(_ context.Context, topic string) (iface.PubSu
 		return nil, err
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	c.topics[topic] = &psTopic{
 		topicName: topic,
 		topic:     joinedTopic,
 		ps:        c,
+		ctx:       ctx,
+		cancel:    cancel,
 	}
 
 	return c.topics[topic], nil
 }
 
+// TopicLeave Cancels the subscriptions to a topic and closes it, libp2p
+// then announces to the other peers that the topic has been left
+func (c *rawPubSub) TopicLeave(_ context.Context, topic string) error {
+	c.muTopics.Lock()
+	t, ok := c.topics[topic]
+	delete(c.topics, topic)
+	c.muTopics.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return t.leave()
+}
+
+// RegisterTopicValidator Registers a libp2p pubsub validator for a topic,
+// the messages it rejects are not relayed through the gossip mesh
+func (c *rawPubSub) RegisterTopicValidator(topic string, validator iface.PubSubTopicValidator) error {
+	return c.pubsub.RegisterTopicValidator(topic, func(ctx context.Context, _ peer.ID, msg *p2ppubsub.Message) bool {
+		return validator(ctx, msg.GetFrom(), msg.Data)
+	})
+}
+
+// UnregisterTopicValidator Removes the validator of a topic
+func (c *rawPubSub) UnregisterTopicValidator(topic string) error {
+	return c.pubsub.UnregisterTopicValidator(topic)
+}
+
 func NewPubSub(ps *p2ppubsub.PubSub, id peer.ID, logger *zap.Logger, tracer trace.Tracer) iface.PubSubInterface {
 	if logger == nil {
 		logger = zap.NewNop()
@@ -148,4 +260,6 @@ func NewPubSub(ps *p2ppubsub.PubSub, id peer.ID, logger *zap.Logger, tracer trac
 }
 
 var _ iface.PubSubInterface = &rawPubSub{}
+var _ iface.PubSubValidatorInterface = &rawPubSub{}
+var _ iface.PubSubLeaveInterface = &rawPubSub{}
 var _ iface.PubSubTopic = &psTopic{}
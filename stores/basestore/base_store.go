@@ -19,6 +19,7 @@ import (
 	"berty.tech/go-orbit-db/address"
 	"berty.tech/go-orbit-db/events"
 	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/keyring"
 	"berty.tech/go-orbit-db/stores"
 	"berty.tech/go-orbit-db/stores/operation"
 	"berty.tech/go-orbit-db/stores/replicator"
@@ -37,6 +38,7 @@ import (
 type BaseStore struct {
 	events.EventEmitter
 
+	ctx               context.Context
 	id                string
 	identity          *identityprovider.Identity
 	address           address.Address
@@ -59,6 +61,16 @@ type BaseStore struct {
 	directory      string
 	options        *iface.NewStoreOptions
 	cacheDestroy   func() error
+	recipientLog   *recipientLog
+
+	// suspended is set while the cache is closed and the replicator is
+	// stopped, inUse counts the operations preventing a suspension
+	suspended    bool
+	closed       bool
+	inUse        int
+	stopMainLoop func()
+	muSuspend    sync.Mutex
+	muReplicator sync.RWMutex
 
 	muCache   sync.RWMutex
 	muIndex   sync.RWMutex
@@ -92,6 +104,9 @@ func (b *BaseStore) AccessController() accesscontroller.Interface {
 }
 
 func (b *BaseStore) Replicator() replicator.Replicator {
+	b.muReplicator.RLock()
+	defer b.muReplicator.RUnlock()
+
 	return b.replicator
 }
 
@@ -134,6 +149,8 @@ func (b *BaseStore) InitBaseStore(ctx context.Context, ipfs coreapi.CoreAPI, ide
 		options.Tracer = trace.NoopTracer{}
 	}
 
+	b.ctx = ctx
+
 	if identity == nil {
 		return errors.New("identity required")
 	}
@@ -186,11 +203,6 @@ func (b *BaseStore) InitBaseStore(ctx context.Context, ipfs coreapi.CoreAPI, ide
 
 	atomic.StoreInt64(&b.stats.snapshot.bytesLoaded, -1)
 
-	b.replicator = replicator.NewReplicator(ctx, b, options.ReplicationConcurrency, &replicator.Options{
-		Logger: b.logger,
-		Tracer: b.tracer,
-	})
-
 	b.referenceCount = 64
 	if options.ReferenceCount != nil {
 		b.referenceCount = *options.ReferenceCount
@@ -210,49 +222,167 @@ func (b *BaseStore) InitBaseStore(ctx context.Context, ipfs coreapi.CoreAPI, ide
 
 	b.options = options
 
-	sub := b.Replicator().Subscribe(ctx)
+	b.startReplicator()
+
+	// Resume the replication interrupted by the last shutdown
+	if err := b.resumeReplicationQueue(b.ctx); err != nil {
+		b.logger.Warn("unable to resume replication", zap.Error(err))
+	}
+
+	return nil
+}
+
+// startReplicator Creates the replicator of the store and starts handling
+// its events, until stopMainLoop is called
+func (b *BaseStore) startReplicator() {
+	options := b.options
+
+	// the default is not stored in the options, they are owned by the caller
+	newReplicator := options.ReplicatorConstructor
+	if newReplicator == nil {
+		newReplicator = replicator.NewReplicator
+	}
+
+	ctx, cancel := context.WithCancel(b.ctx)
+	r := newReplicator(ctx, b, options.ReplicationConcurrency, &replicator.Options{
+		Logger:            b.logger,
+		Tracer:            b.tracer,
+		BatchSize:         options.ReplicationBatchSize,
+		AdaptiveBatchSize: options.ReplicationAdaptiveBatchSize,
+		MaxBatchSize:      options.ReplicationMaxBatchSize,
+		FetchConcurrency:  options.ReplicationFetchConcurrency,
+		FetchTimeout:      options.ReplicationFetchTimeout,
+		MaxRetries:        options.ReplicationMaxRetries,
+		RetryBackoff:      options.ReplicationRetryBackoff,
+		MaxRetryBackoff:   options.ReplicationMaxRetryBackoff,
+		Filter:            options.ReplicationFilter,
+		MaxDepth:          options.ReplicationMaxDepth,
+	})
+
+	b.muReplicator.Lock()
+	b.replicator = r
+	b.muReplicator.Unlock()
+
+	done := make(chan struct{})
+	b.stopMainLoop = func() {
+		cancel()
+		<-done
+	}
+
+	sub := r.Subscribe(ctx)
 	go func() {
-		ctx, span := b.tracer.Start(ctx, "base-store-main-loop", trace.WithAttributes(otkv.String("store-address", b.Address().String())))
-		defer span.End()
-
-		for e := range sub {
-			switch evt := e.(type) {
-			case *replicator.EventLoadAdded:
-				span.AddEvent(ctx, "replicator-load-added", otkv.String("hash", evt.Hash.String()))
-				b.ReplicationStatus().IncQueued()
-				b.recalculateReplicationMax(0)
-				b.Emit(ctx, stores.NewEventReplicate(b.Address(), evt.Hash))
-
-			case *replicator.EventLoadEnd:
-				span.AddEvent(ctx, "replicator-load-end")
-				b.replicationLoadComplete(ctx, evt.Logs)
-
-			case *replicator.EventLoadProgress:
-				span.AddEvent(ctx, "replicator-load-progress")
-				if b.ReplicationStatus().GetBuffered() > evt.BufferLength {
-					b.recalculateReplicationProgress(b.ReplicationStatus().GetProgress() + evt.BufferLength)
-				} else {
-					if _, ok := b.OpLog().Get(evt.Hash); ok {
-						continue
-					}
-
-					b.recalculateReplicationProgress(b.OpLog().Len() + evt.BufferLength)
+		defer close(done)
+		b.mainLoop(ctx, sub)
+	}()
+}
+
+// mainLoop Handles the events of the replicator until its subscription is
+// closed
+func (b *BaseStore) mainLoop(ctx context.Context, sub <-chan events.Event) {
+	ctx, span := b.tracer.Start(ctx, "base-store-main-loop", trace.WithAttributes(otkv.String("store-address", b.Address().String())))
+	defer span.End()
+
+	saveTicker := time.NewTicker(replicationQueueSaveInterval)
+	defer saveTicker.Stop()
+
+	// the replication queue changed since it was last saved
+	queueChanged := false
+
+	for {
+		var e events.Event
+
+		select {
+		case <-saveTicker.C:
+			if queueChanged {
+				queueChanged = false
+				if err := b.saveReplicationQueue(); err != nil {
+					b.logger.Warn("unable to save replication queue", zap.Error(err))
 				}
+			}
+
+			continue
 
-				b.ReplicationStatus().SetBuffered(evt.BufferLength)
-				b.recalculateReplicationMax(b.ReplicationStatus().GetProgress())
-				// logger.debug(`<replicate.progress>`)
-				b.Emit(ctx, stores.NewEventReplicateProgress(b.Address(), evt.Hash, evt.Latest, b.ReplicationStatus()))
+		case evt, ok := <-sub:
+			if !ok {
+				return
 			}
+
+			e = evt
 		}
-	}()
 
-	return nil
+		switch evt := e.(type) {
+		case *replicator.EventLoadAdded:
+			span.AddEvent(ctx, "replicator-load-added", otkv.String("hash", evt.Hash.String()))
+			queueChanged = true
+			b.ReplicationStatus().IncQueued()
+			b.recalculateReplicationMax(0)
+			b.Emit(ctx, stores.NewEventReplicate(b.Address(), evt.Hash))
+
+		case *replicator.EventLoadEnd:
+			span.AddEvent(ctx, "replicator-load-end")
+			b.replicationLoadComplete(ctx, evt.Logs)
+
+		case *replicator.EventLoadProgress:
+			span.AddEvent(ctx, "replicator-load-progress")
+			if b.ReplicationStatus().GetBuffered() > evt.BufferLength {
+				b.recalculateReplicationProgress(b.ReplicationStatus().GetProgress() + evt.BufferLength)
+			} else {
+				if _, ok := b.OpLog().Get(evt.Hash); ok {
+					continue
+				}
+
+				b.recalculateReplicationProgress(b.OpLog().Len() + evt.BufferLength)
+			}
+
+			b.ReplicationStatus().SetBuffered(evt.BufferLength)
+			b.recalculateReplicationMax(b.ReplicationStatus().GetProgress())
+			// logger.debug(`<replicate.progress>`)
+			b.Emit(ctx, stores.NewEventReplicateProgress(b.Address(), evt.Hash, evt.Latest, b.ReplicationStatus()))
+
+		case *replicator.EventLoadSkipped:
+			span.AddEvent(ctx, "replicator-load-skipped", otkv.String("hash", evt.Hash.String()))
+			b.ReplicationStatus().DecreaseQueued(1)
+
+		case *replicator.EventFetchRetry:
+			span.AddEvent(ctx, "replicator-fetch-retry", otkv.String("hash", evt.Hash.String()))
+			b.ReplicationStatus().DecreaseQueued(1)
+			b.Emit(ctx, stores.NewEventReplicateRetry(b.Address(), evt.Hash, evt.Attempt, evt.Delay, evt.Err))
+
+		case *replicator.EventFetchFailed:
+			span.AddEvent(ctx, "replicator-fetch-failed", otkv.String("hash", evt.Hash.String()))
+			queueChanged = true
+			b.ReplicationStatus().DecreaseQueued(1)
+			b.Emit(ctx, stores.NewEventReplicateFailed(b.Address(), evt.Hash, evt.Attempts, evt.Err))
+		}
+	}
 }
 
 func (b *BaseStore) Close() error {
-	// Replicator teardown logic
-	b.Replicator().Stop()
+	if b.options.Release != nil && !b.options.Release(false) {
+		// the store is still used by other holders
+		return nil
+	}
+
+	return b.close()
+}
+
+func (b *BaseStore) close() error {
+	b.muSuspend.Lock()
+	defer b.muSuspend.Unlock()
+
+	// a suspended store has already released its replicator and cache
+	suspended := b.suspended
+	b.closed = true
+
+	if !suspended {
+		// Replicator teardown logic
+		b.Replicator().Stop()
+		b.stopMainLoop()
+
+		if err := b.saveReplicationQueue(); err != nil {
+			b.logger.Warn("unable to save replication queue", zap.Error(err))
+		}
+	}
 
 	// Reset replication statistics
 	b.ReplicationStatus().Reset()
@@ -263,6 +393,10 @@ func (b *BaseStore) Close() error {
 
 	b.UnsubscribeAll()
 
+	if suspended {
+		return nil
+	}
+
 	err := b.Cache().Close()
 	if err != nil {
 		return errors.Wrap(err, "unable to close cache")
@@ -292,7 +426,11 @@ func (b *BaseStore) ReplicationStatus() replicator.ReplicationInfo {
 
 func (b *BaseStore) Drop() error {
 	var err error
-	if err = b.Close(); err != nil {
+	if b.options.Release != nil {
+		b.options.Release(true)
+	}
+
+	if err = b.close(); err != nil {
 		return errors.Wrap(err, "unable to close store")
 	}
 
@@ -329,6 +467,12 @@ func (b *BaseStore) Load(ctx context.Context, amount int) error {
 	ctx, span := b.tracer.Start(ctx, "store-load")
 	defer span.End()
 
+	release, err := b.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	if amount <= 0 && b.options.MaxHistory != nil {
 		amount = *b.options.MaxHistory
 	}
@@ -396,16 +540,26 @@ func (b *BaseStore) Load(ctx context.Context, amount int) error {
 			b.recalculateReplicationMax(h.GetClock().GetTime())
 
 			span.AddEvent(ctx, "store-head-loading")
-			l, inErr := ipfslog.NewFromEntryHash(ctx, b.IPFS(), b.Identity(), h.GetHash(), &ipfslog.LogOptions{
-				ID:               oplog.GetID(),
-				AccessController: b.AccessController(),
-				SortFn:           b.SortFn(),
-				IO:               b.options.IO,
-			}, &ipfslog.FetchOptions{
-				Length:  &amount,
-				Exclude: oplog.GetEntries().Slice(),
-				// TODO: ProgressChan:  this._onLoadProgress.bind(this),
-			})
+
+			var (
+				l     ipfslog.Log
+				inErr error
+			)
+
+			if b.options.ReplicationFilter != nil {
+				l, inErr = b.loadFilteredHistory(ctx, h, amount)
+			} else {
+				l, inErr = ipfslog.NewFromEntryHash(ctx, b.IPFS(), b.Identity(), h.GetHash(), &ipfslog.LogOptions{
+					ID:               oplog.GetID(),
+					AccessController: b.AccessController(),
+					SortFn:           b.SortFn(),
+					IO:               b.options.IO,
+				}, &ipfslog.FetchOptions{
+					Length:  &amount,
+					Exclude: oplog.GetEntries().Slice(),
+					// TODO: ProgressChan:  this._onLoadProgress.bind(this),
+				})
+			}
 
 			if inErr != nil {
 				span.AddEvent(ctx, "store-head-loading-error")
@@ -445,9 +599,98 @@ func (b *BaseStore) Load(ctx context.Context, amount int) error {
 	}
 
 	b.Emit(ctx, stores.NewEventReady(b.Address(), b.OpLog().Heads().Slice()))
+
 	return nil
 }
 
+// loadFilteredHistory Walks the history of a cached head through the
+// replication filter, the entries it skips are left out and the ones it stops
+// are neither kept nor walked through, so their history is never fetched
+func (b *BaseStore) loadFilteredHistory(ctx context.Context, head *entry.Entry, amount int) (ipfslog.Log, error) {
+	oplog := b.OpLog()
+	filter := b.options.ReplicationFilter
+	one := 1
+
+	seen := map[string]struct{}{}
+	var (
+		queue []cid.Cid
+		kept  []ipfslog.Entry
+	)
+
+	// the head itself is read from the cache, it is only fetched when kept
+	switch filter(head) {
+	case replicator.FilterStop:
+		b.logger.Debug("cached head stopped by the replication filter", zap.String("cid", head.GetHash().String()))
+		return b.newFilteredLog(nil)
+
+	case replicator.FilterSkip:
+		seen[head.GetHash().String()] = struct{}{}
+		queue = append(queue, head.GetNext()...)
+
+	default:
+		queue = append(queue, head.GetHash())
+	}
+
+	for len(queue) > 0 && (amount < 0 || len(kept) < amount) {
+		c := queue[0]
+		queue = queue[1:]
+
+		if _, ok := seen[c.String()]; ok {
+			continue
+		}
+		seen[c.String()] = struct{}{}
+
+		if _, ok := oplog.Get(c); ok {
+			continue
+		}
+
+		l, err := ipfslog.NewFromEntryHash(ctx, b.IPFS(), b.Identity(), c, &ipfslog.LogOptions{
+			ID:               oplog.GetID(),
+			AccessController: b.AccessController(),
+			SortFn:           b.SortFn(),
+			IO:               b.options.IO,
+		}, &ipfslog.FetchOptions{
+			Length: &one,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to fetch entry")
+		}
+
+		e, ok := l.Get(c)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("entry %s not found", c.String()))
+		}
+
+		switch filter(e) {
+		case replicator.FilterStop:
+			continue
+
+		case replicator.FilterKeep:
+			kept = append(kept, e)
+		}
+
+		queue = append(queue, e.GetNext()...)
+	}
+
+	return b.newFilteredLog(kept)
+}
+
+// newFilteredLog Creates a log of the entries kept by loadFilteredHistory
+func (b *BaseStore) newFilteredLog(entries []ipfslog.Entry) (ipfslog.Log, error) {
+	l, err := ipfslog.NewLog(b.IPFS(), b.Identity(), &ipfslog.LogOptions{
+		ID:               b.OpLog().GetID(),
+		AccessController: b.AccessController(),
+		Entries:          entry.NewOrderedMapFromEntries(entries),
+		SortFn:           b.SortFn(),
+		IO:               b.options.IO,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create log")
+	}
+
+	return l, nil
+}
+
 func (b *BaseStore) Sync(ctx context.Context, heads []ipfslog.Entry) error {
 	ctx, span := b.tracer.Start(ctx, "store-sync")
 	defer span.End()
@@ -458,6 +701,12 @@ func (b *BaseStore) Sync(ctx context.Context, heads []ipfslog.Entry) error {
 		return nil
 	}
 
+	release, err := b.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	var savedEntriesCIDs []cid.Cid
 
 	for _, h := range heads {
@@ -479,6 +728,14 @@ func (b *BaseStore) Sync(ctx context.Context, heads []ipfslog.Entry) error {
 			return errors.New("identity-provider is required, cannot verify entry")
 		}
 
+		// only the stopped heads are dropped here, the skipped ones are
+		// filtered by the replicator once fetched so their history is
+		// still replicated
+		if b.options.ReplicationFilter != nil && b.options.ReplicationFilter(h) == replicator.FilterStop {
+			span.AddEvent(ctx, "store-sync-filtered")
+			continue
+		}
+
 		canAppend := b.AccessController().CanAppend(h, identityProvider, &CanAppendContext{log: b.OpLog()})
 		if canAppend != nil {
 			span.AddEvent(ctx, "store-sync-cant-append", otkv.String("error", canAppend.Error()))
@@ -507,6 +764,13 @@ func (b *BaseStore) Sync(ctx context.Context, heads []ipfslog.Entry) error {
 }
 
 func (b *BaseStore) LoadMoreFrom(ctx context.Context, amount uint, cids []cid.Cid) {
+	release, err := b.use()
+	if err != nil {
+		b.logger.Error("unable to load more entries", zap.Error(err))
+		return
+	}
+	defer release()
+
 	b.Replicator().Load(ctx, cids)
 	// TODO: can this return an error?
 }
@@ -522,6 +786,12 @@ func (b *BaseStore) LoadFromSnapshot(ctx context.Context) error {
 	ctx, span := b.tracer.Start(ctx, "load-from-snapshot")
 	defer span.End()
 
+	release, err := b.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	b.muJoining.Lock()
 	defer b.muJoining.Unlock()
 
@@ -657,23 +927,81 @@ func intPtr(i int) *int {
 }
 
 func (b *BaseStore) AddOperation(ctx context.Context, op operation.Operation, onProgressCallback chan<- ipfslog.Entry) (ipfslog.Entry, error) {
-	ctx, span := b.tracer.Start(ctx, "add-operation")
+	entries, err := b.AddOperations(ctx, []operation.Operation{op}, onProgressCallback)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries[0], nil
+}
+
+// AddOperations Appends a batch of operations, the local heads are written,
+// the index is updated and a write event is emitted only once for the batch.
+// When appending fails partway, the entries already appended are written,
+// indexed and announced, and returned along with the error
+func (b *BaseStore) AddOperations(ctx context.Context, ops []operation.Operation, onProgressCallback chan<- ipfslog.Entry) ([]ipfslog.Entry, error) {
+	ctx, span := b.tracer.Start(ctx, "add-operations", trace.WithAttributes(otkv.Int("count", len(ops))))
 	defer span.End()
 
-	data, err := op.Marshal()
+	if len(ops) == 0 {
+		return nil, errors.New("no operation given")
+	}
+
+	release, err := b.use()
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to marshal operation")
+		return nil, err
+	}
+	defer release()
+
+	var recipients []*[32]byte
+	if b.options.RecipientKey != nil {
+		recipients, err = b.recipients()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to list recipients")
+		}
+	}
+
+	// the operations are all encoded before appending any of them, so an
+	// invalid operation doesn't leave a partial batch
+	payloads := make([][]byte, len(ops))
+	for i, op := range ops {
+		data, err := op.Marshal()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to marshal operation")
+		}
+
+		if recipients != nil {
+			data, err = keyring.SealForRecipients(data, recipients)
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to seal operation")
+			}
+		}
+
+		payloads[i] = data
 	}
 
 	oplog := b.OpLog()
+	entries := make([]ipfslog.Entry, 0, len(ops))
 
-	e, err := oplog.Append(ctx, data, &ipfslog.AppendOptions{PointerCount: b.referenceCount})
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to append data on log")
+	var appendErr error
+	for _, data := range payloads {
+		e, err := oplog.Append(ctx, data, &ipfslog.AppendOptions{PointerCount: b.referenceCount})
+		if err != nil {
+			appendErr = errors.Wrap(err, "unable to append data on log")
+			break
+		}
+
+		entries = append(entries, e)
 	}
-	b.recalculateReplicationStatus(b.ReplicationStatus().GetProgress()+1, e.GetClock().GetTime())
 
-	marshaledEntry, err := json.Marshal([]ipfslog.Entry{e})
+	if len(entries) == 0 {
+		return nil, appendErr
+	}
+
+	last := entries[len(entries)-1]
+	b.recalculateReplicationStatus(b.ReplicationStatus().GetProgress()+len(entries), last.GetClock().GetTime())
+
+	marshaledEntry, err := json.Marshal([]ipfslog.Entry{last})
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to marshal entry")
 	}
@@ -687,13 +1015,25 @@ func (b *BaseStore) AddOperation(ctx context.Context, op operation.Operation, on
 		return nil, errors.Wrap(err, "unable to update index")
 	}
 
-	b.Emit(ctx, stores.NewEventWrite(b.Address(), e, oplog.Heads().Slice()))
+	b.Emit(ctx, stores.NewEventWrite(b.Address(), last, oplog.Heads().Slice()))
 
 	if onProgressCallback != nil {
-		onProgressCallback <- e
+		for _, e := range entries {
+			onProgressCallback <- e
+		}
+	}
+
+	if b.options.RecipientKey != nil {
+		// give back the operations as they were submitted
+		indexedLog := b.indexedLog().(*recipientLog)
+		for i := range entries {
+			if entries[i], err = indexedLog.openEntry(entries[i]); err != nil {
+				return nil, errors.Wrap(err, "unable to open entry")
+			}
+		}
 	}
 
-	return e, nil
+	return entries, appendErr
 }
 
 func (b *BaseStore) recalculateReplicationProgress(max int) {
@@ -730,7 +1070,7 @@ func (b *BaseStore) updateIndex(ctx context.Context) error {
 	defer span.End()
 
 	b.recalculateReplicationMax(0)
-	if err := b.Index().UpdateIndex(b.OpLog(), []ipfslog.Entry{}); err != nil {
+	if err := b.Index().UpdateIndex(b.indexedLog(), []ipfslog.Entry{}); err != nil {
 		return errors.Wrap(err, "unable to update index")
 	}
 	b.recalculateReplicationProgress(0)
@@ -738,6 +1078,24 @@ func (b *BaseStore) updateIndex(ctx context.Context) error {
 	return nil
 }
 
+// indexedLog Returns the log given to the index, with its entries opened
+// when the store is encrypted for recipients
+func (b *BaseStore) indexedLog() ipfslog.Log {
+	oplog := b.OpLog()
+	if b.options.RecipientKey == nil {
+		return oplog
+	}
+
+	b.muIndex.Lock()
+	defer b.muIndex.Unlock()
+
+	if b.recipientLog == nil || b.recipientLog.Log != oplog {
+		b.recipientLog = newRecipientLog(oplog, b.options.RecipientKey, b.logger)
+	}
+
+	return b.recipientLog
+}
+
 func (b *BaseStore) replicationLoadComplete(ctx context.Context, logs []ipfslog.Log) {
 	b.muJoining.Lock()
 	defer b.muJoining.Unlock()
@@ -777,6 +1135,10 @@ func (b *BaseStore) replicationLoadComplete(ctx context.Context, logs []ipfslog.
 
 	b.Logger().Debug(fmt.Sprintf("Saved heads %d", heads.Len()))
 
+	if err := b.saveReplicationQueue(); err != nil {
+		b.Logger().Warn("unable to save replication queue", zap.Error(err))
+	}
+
 	// logger.debug(`<replicated>`)
 	b.Emit(ctx, stores.NewEventReplicated(b.Address(), len(logs)))
 }
@@ -801,3 +1163,4 @@ func (c *CanAppendContext) GetLogEntries() []logac.LogEntry {
 }
 
 var _ iface.Store = &BaseStore{}
+var _ replicator.StoreInterface = &BaseStore{}
@@ -0,0 +1,177 @@
+package basestore
+
+import (
+	"sync"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/entry"
+	logiface "berty.tech/go-ipfs-log/iface"
+	"berty.tech/go-orbit-db/keyring"
+	cid "github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// maxOpenedEntries The number of opened entries kept by a recipient log
+// before its cache is reset
+const maxOpenedEntries = 4096
+
+// recipientLog Exposes the entries of a log with their payload opened using
+// a recipient key, entries that can't be opened are hidden
+type recipientLog struct {
+	ipfslog.Log
+
+	key    *keyring.RecipientKey
+	logger *zap.Logger
+	opened map[string]ipfslog.Entry
+	muOpen sync.Mutex
+}
+
+func newRecipientLog(log ipfslog.Log, key *keyring.RecipientKey, logger *zap.Logger) *recipientLog {
+	return &recipientLog{
+		Log:    log,
+		key:    key,
+		logger: logger,
+		opened: map[string]ipfslog.Entry{},
+	}
+}
+
+func (l *recipientLog) Values() logiface.IPFSLogOrderedEntries {
+	return l.openEntries(l.Log.Values())
+}
+
+func (l *recipientLog) Heads() logiface.IPFSLogOrderedEntries {
+	return l.openEntries(l.Log.Heads())
+}
+
+func (l *recipientLog) RawHeads() logiface.IPFSLogOrderedEntries {
+	return l.openEntries(l.Log.RawHeads())
+}
+
+func (l *recipientLog) GetEntries() logiface.IPFSLogOrderedEntries {
+	return l.openEntries(l.Log.GetEntries())
+}
+
+func (l *recipientLog) Get(c cid.Cid) (logiface.IPFSLogEntry, bool) {
+	e, ok := l.Log.Get(c)
+	if !ok {
+		return nil, false
+	}
+
+	opened, ok := l.tryOpen(e)
+	if !ok {
+		return nil, false
+	}
+
+	return opened, true
+}
+
+func (l *recipientLog) Iterator(options *logiface.IteratorOptions, output chan<- logiface.IPFSLogEntry) error {
+	defer close(output)
+
+	sealed := make(chan logiface.IPFSLogEntry)
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- l.Log.Iterator(options, sealed)
+	}()
+
+	for e := range sealed {
+		if opened, ok := l.tryOpen(e); ok {
+			output <- opened
+		}
+	}
+
+	return <-errCh
+}
+
+// openEntries Returns the entries which can be opened, in the same order
+func (l *recipientLog) openEntries(entries logiface.IPFSLogOrderedEntries) logiface.IPFSLogOrderedEntries {
+	values := entries.Slice()
+	result := make([]ipfslog.Entry, 0, len(values))
+
+	for _, e := range values {
+		if opened, ok := l.tryOpen(e); ok {
+			result = append(result, opened)
+		}
+	}
+
+	return entry.NewOrderedMapFromEntries(result)
+}
+
+// tryOpen Opens an entry, an entry which can't be opened is hidden
+func (l *recipientLog) tryOpen(e ipfslog.Entry) (ipfslog.Entry, bool) {
+	opened, err := l.openEntry(e)
+	if err != nil {
+		l.logger.Debug("hiding entry which can't be opened", zap.String("hash", e.GetHash().String()), zap.Error(err))
+		return nil, false
+	}
+
+	return opened, true
+}
+
+func (l *recipientLog) openEntry(e ipfslog.Entry) (ipfslog.Entry, error) {
+	if !keyring.IsSealedForRecipients(e.GetPayload()) {
+		return e, nil
+	}
+
+	l.muOpen.Lock()
+	defer l.muOpen.Unlock()
+
+	if opened, ok := l.opened[e.GetHash().String()]; ok {
+		return opened, nil
+	}
+
+	castedEntry, ok := e.(*entry.Entry)
+	if !ok {
+		return nil, errors.New("unable to downcast entry")
+	}
+
+	payload, err := keyring.OpenForRecipient(castedEntry.GetPayload(), l.key)
+	if err != nil {
+		return nil, err
+	}
+
+	// the entry is copied, the one in the log keeps its sealed payload so
+	// it can still be verified and exchanged with other peers
+	opened := *castedEntry
+	opened.Payload = payload
+
+	if len(l.opened) >= maxOpenedEntries {
+		l.opened = map[string]ipfslog.Entry{}
+	}
+
+	l.opened[e.GetHash().String()] = &opened
+
+	return &opened, nil
+}
+
+// recipients Returns the keys of the readers of the store, the current
+// identity is always included so it can read its own entries
+func (b *BaseStore) recipients() ([]*[32]byte, error) {
+	readers, err := b.AccessController().GetAuthorizedByRole("read")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get readers")
+	}
+
+	recipients := []*[32]byte{b.options.RecipientKey.PublicKey}
+	seen := map[string]struct{}{b.options.RecipientKey.ID(): {}}
+
+	for _, reader := range readers {
+		if _, ok := seen[reader]; ok {
+			continue
+		}
+
+		seen[reader] = struct{}{}
+
+		pub, err := keyring.ParseRecipientID(reader)
+		if err != nil {
+			b.logger.Debug("ignoring reader which is not a recipient key", zap.String("reader", reader), zap.Error(err))
+			continue
+		}
+
+		recipients = append(recipients, pub)
+	}
+
+	return recipients, nil
+}
@@ -0,0 +1,82 @@
+package basestore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// replicationQueueKey The cache key holding the hashes which were still to be
+// replicated when the queue was last saved
+const replicationQueueKey = "_replicationQueue"
+
+// replicationQueueSaveInterval The minimum delay between two saves of the
+// replication queue while the replication is running
+const replicationQueueSaveInterval = time.Second * 5
+
+// saveReplicationQueue Persists the outstanding replication work in the cache
+func (b *BaseStore) saveReplicationQueue() error {
+	pending := b.Replicator().GetPending()
+
+	// failed hashes are given a new chance after a restart
+	for _, f := range b.Replicator().GetFailed() {
+		pending = append(pending, f.Hash)
+	}
+
+	if len(pending) == 0 {
+		if err := b.Cache().Delete(datastore.NewKey(replicationQueueKey)); err != nil && err != datastore.ErrNotFound {
+			return errors.Wrap(err, "unable to clear replication queue")
+		}
+
+		return nil
+	}
+
+	queueBytes, err := json.Marshal(pending)
+	if err != nil {
+		return errors.Wrap(err, "unable to serialize replication queue")
+	}
+
+	if err := b.Cache().Put(datastore.NewKey(replicationQueueKey), queueBytes); err != nil {
+		return errors.Wrap(err, "unable to save replication queue")
+	}
+
+	return nil
+}
+
+// resumeReplicationQueue Queues again the hashes saved by saveReplicationQueue,
+// when the store is opened
+func (b *BaseStore) resumeReplicationQueue(ctx context.Context) error {
+	queueBytes, err := b.Cache().Get(datastore.NewKey(replicationQueueKey))
+	if err == datastore.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "unable to get replication queue from cache")
+	}
+
+	var queue []cid.Cid
+	if err := json.Unmarshal(queueBytes, &queue); err != nil {
+		return errors.Wrap(err, "unable to deserialize replication queue")
+	}
+
+	var missing []cid.Cid
+	for _, c := range queue {
+		if _, ok := b.OpLog().Get(c); !ok {
+			missing = append(missing, c)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	b.Logger().Debug("resuming replication", zap.Int("count", len(missing)))
+
+	go b.Replicator().Load(ctx, missing)
+
+	return nil
+}
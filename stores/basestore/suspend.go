@@ -0,0 +1,115 @@
+package basestore
+
+import (
+	"berty.tech/go-orbit-db/iface"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Suspend Stops the replicator and closes the cache of the store while it
+// is still held, its pending replication is saved and resumed along with
+// them on the next use of the store. The Suspended and Resumed options let
+// the owner of the store stop its exchanges with peers meanwhile. Returns false when the store is
+// already suspended, closed or running an operation
+func (b *BaseStore) Suspend() (bool, error) {
+	if b.options.ReopenCache == nil {
+		return false, errors.New("the cache of the store can't be reopened")
+	}
+
+	b.muSuspend.Lock()
+	defer b.muSuspend.Unlock()
+
+	if b.suspended || b.closed || b.inUse > 0 {
+		return false, nil
+	}
+
+	// the peers stop announcing heads to the store, which would resume it
+	if b.options.Suspended != nil {
+		b.options.Suspended()
+	}
+
+	b.Replicator().Stop()
+	b.stopMainLoop()
+
+	if err := b.saveReplicationQueue(); err != nil {
+		b.logger.Warn("unable to save replication queue", zap.Error(err))
+	}
+
+	// the saved hashes are queued again once resumed
+	b.ReplicationStatus().DecreaseQueued(b.ReplicationStatus().GetQueued())
+
+	b.suspended = true
+
+	if err := b.Cache().Close(); err != nil {
+		return true, errors.Wrap(err, "unable to close cache")
+	}
+
+	return true, nil
+}
+
+// Suspended Returns whether the store is suspended until its next use
+func (b *BaseStore) Suspended() bool {
+	b.muSuspend.Lock()
+	defer b.muSuspend.Unlock()
+
+	return b.suspended
+}
+
+// use Resumes the store when it is suspended and prevents suspending it
+// until the returned function is called
+func (b *BaseStore) use() (func(), error) {
+	b.muSuspend.Lock()
+
+	resumed := false
+	if b.suspended && !b.closed {
+		if err := b.resume(); err != nil {
+			b.muSuspend.Unlock()
+			return nil, errors.Wrap(err, "unable to resume store")
+		}
+
+		resumed = true
+	}
+
+	b.inUse++
+	b.muSuspend.Unlock()
+
+	if b.options.Used != nil {
+		b.options.Used(resumed)
+	}
+
+	return func() {
+		b.muSuspend.Lock()
+		b.inUse--
+		b.muSuspend.Unlock()
+	}, nil
+}
+
+// resume Reopens the cache and restarts the replicator of a suspended
+// store, muSuspend must be held
+func (b *BaseStore) resume() error {
+	c, err := b.options.ReopenCache()
+	if err != nil {
+		return errors.Wrap(err, "unable to reopen cache")
+	}
+
+	b.muCache.Lock()
+	b.cache = c
+	b.muCache.Unlock()
+
+	b.startReplicator()
+	b.suspended = false
+
+	if b.options.Resumed != nil {
+		if err := b.options.Resumed(); err != nil {
+			b.logger.Warn("unable to resume store exchanges", zap.Error(err))
+		}
+	}
+
+	if err := b.resumeReplicationQueue(b.ctx); err != nil {
+		b.logger.Warn("unable to resume replication", zap.Error(err))
+	}
+
+	return nil
+}
+
+var _ iface.StoreSuspendInterface = &BaseStore{}
@@ -0,0 +1,250 @@
+package basestore
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	logac "berty.tech/go-ipfs-log/accesscontroller"
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-orbit-db/iface"
+	cid "github.com/ipfs/go-cid"
+	ipfspath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/pkg/errors"
+	otkv "go.opentelemetry.io/otel/api/kv"
+)
+
+// verifyFetchTimeout How long Verify waits for an entry which is not in the
+// log before reporting it as missing
+var verifyFetchTimeout = 5 * time.Second
+
+// VerificationFailureReason Describes why an entry failed verification
+type VerificationFailureReason string
+
+const (
+	// VerificationInvalidIdentity The identity of the entry is not valid for the identity provider
+	VerificationInvalidIdentity VerificationFailureReason = "invalid-identity"
+
+	// VerificationNotAllowed The access controller doesn't allow the entry author to write
+	VerificationNotAllowed VerificationFailureReason = "not-allowed"
+
+	// VerificationInvalidSignature The entry signature doesn't match its content
+	VerificationInvalidSignature VerificationFailureReason = "invalid-signature"
+
+	// VerificationHashMismatch The entry hash doesn't match its content
+	VerificationHashMismatch VerificationFailureReason = "hash-mismatch"
+
+	// VerificationInvalidEntry The entry block can't be decoded
+	VerificationInvalidEntry VerificationFailureReason = "invalid-entry"
+)
+
+// VerificationFailure An entry which failed verification
+type VerificationFailure struct {
+	Hash   cid.Cid
+	Reason VerificationFailureReason
+	Err    error
+}
+
+// VerificationReport The result of a log verification
+type VerificationReport struct {
+	// Checked The number of entries that have been verified
+	Checked int
+
+	// Failures The entries that failed verification
+	Failures []VerificationFailure
+
+	// Missing The entries referenced in the log which couldn't be found
+	Missing []cid.Cid
+}
+
+// Valid Returns true if every entry is present and valid
+func (r *VerificationReport) Valid() bool {
+	return len(r.Failures) == 0 && len(r.Missing) == 0
+}
+
+func (r *VerificationReport) addFailure(h cid.Cid, reason VerificationFailureReason, err error) {
+	r.Failures = append(r.Failures, VerificationFailure{
+		Hash:   h,
+		Reason: reason,
+		Err:    err,
+	})
+}
+
+// allowAllAccessController Accepts every entry, used to check signatures on
+// their own
+type allowAllAccessController struct{}
+
+func (allowAllAccessController) CanAppend(logac.LogEntry, identityprovider.Interface, logac.CanAppendAdditionalContext) error {
+	return nil
+}
+
+// Verify Walks the whole log of a store from its heads, checking the
+// identity, the write access, the signature of every entry and the hash of
+// its stored block. Nothing is written while verifying
+func Verify(ctx context.Context, b iface.Store) (*VerificationReport, error) {
+	ctx, span := b.Tracer().Start(ctx, "store-verify")
+	defer span.End()
+
+	identityProvider := b.Identity().Provider
+	if identityProvider == nil {
+		return nil, errors.New("identity-provider is required, cannot verify entries")
+	}
+
+	oplog := b.OpLog()
+	report := &VerificationReport{}
+
+	var queue []cid.Cid
+	for _, h := range oplog.Heads().Slice() {
+		queue = append(queue, h.GetHash())
+	}
+
+	visited := map[string]struct{}{}
+
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		h := queue[0]
+		queue = queue[1:]
+
+		if _, ok := visited[h.String()]; ok {
+			continue
+		}
+
+		visited[h.String()] = struct{}{}
+
+		e, inLog := oplog.Get(h)
+
+		raw, err := verifyReadBlock(ctx, b, h)
+		if err != nil {
+			span.AddEvent(ctx, "store-verify-missing", otkv.String("cid", h.String()))
+			report.Missing = append(report.Missing, h)
+
+			// the history of a loaded entry is still verified
+			if inLog {
+				queue = append(queue, e.GetNext()...)
+				queue = append(queue, e.GetRefs()...)
+			}
+
+			continue
+		}
+
+		report.Checked++
+
+		// the hash is computed from the stored bytes, not from the decoded
+		// entry which may have been normalized
+		if sum, err := h.Prefix().Sum(raw); err != nil {
+			report.addFailure(h, VerificationHashMismatch, err)
+		} else if !sum.Equals(h) {
+			report.addFailure(h, VerificationHashMismatch, errors.New(fmt.Sprintf("content hashes to %s", sum.String())))
+		} else if !inLog {
+			fetched, err := verifyFetchEntry(ctx, b, h)
+			if err != nil {
+				report.addFailure(h, VerificationInvalidEntry, err)
+				continue
+			}
+
+			e, inLog = fetched, true
+		}
+
+		// the links of a tampered block which is not in the log can't be
+		// trusted, it isn't walked
+		if !inLog {
+			continue
+		}
+
+		if err := identityProvider.VerifyIdentity(e.GetIdentity()); err != nil {
+			report.addFailure(h, VerificationInvalidIdentity, err)
+		} else if err := b.AccessController().CanAppend(e, identityProvider, &CanAppendContext{log: oplog}); err != nil {
+			report.addFailure(h, VerificationNotAllowed, err)
+		} else if err := verifySignature(b, oplog.GetID(), e); err != nil {
+			report.addFailure(h, VerificationInvalidSignature, err)
+		}
+
+		queue = append(queue, e.GetNext()...)
+		queue = append(queue, e.GetRefs()...)
+	}
+
+	span.AddEvent(ctx, "store-verify-done", otkv.Int("checked", report.Checked), otkv.Int("failures", len(report.Failures)), otkv.Int("missing", len(report.Missing)))
+
+	return report, nil
+}
+
+// verifyReadBlock Returns the stored bytes of an entry, fetching them from
+// the network when they are not available locally
+func verifyReadBlock(ctx context.Context, b iface.Store, h cid.Cid) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, verifyFetchTimeout)
+	defer cancel()
+
+	r, err := b.IPFS().Block().Get(ctx, ipfspath.IpfsPath(h))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get block")
+	}
+
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read block")
+	}
+
+	return raw, nil
+}
+
+// verifyFetchEntry Decodes an entry referenced in the log but not loaded
+func verifyFetchEntry(ctx context.Context, b iface.Store, h cid.Cid) (ipfslog.Entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, verifyFetchTimeout)
+	defer cancel()
+
+	one := 1
+
+	l, err := ipfslog.NewFromEntryHash(ctx, b.IPFS(), b.Identity(), h, &ipfslog.LogOptions{
+		ID:               b.OpLog().GetID(),
+		AccessController: allowAllAccessController{},
+		IO:               b.IO(),
+	}, &ipfslog.FetchOptions{
+		Length:  &one,
+		Timeout: verifyFetchTimeout,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch entry")
+	}
+
+	e, ok := l.Get(h)
+	if !ok {
+		return nil, errors.New("entry not found")
+	}
+
+	return e, nil
+}
+
+// verifySignature Checks the signature of an entry by joining it in an empty
+// log, the write access is checked separately
+func verifySignature(b iface.Store, logID string, e ipfslog.Entry) error {
+	single, err := ipfslog.NewLog(b.IPFS(), b.Identity(), &ipfslog.LogOptions{
+		ID:               logID,
+		Entries:          entry.NewOrderedMapFromEntries([]ipfslog.Entry{e}),
+		AccessController: allowAllAccessController{},
+		IO:               b.IO(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to create log")
+	}
+
+	empty, err := ipfslog.NewLog(b.IPFS(), b.Identity(), &ipfslog.LogOptions{
+		ID:               logID,
+		AccessController: allowAllAccessController{},
+		IO:               b.IO(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to create log")
+	}
+
+	if _, err := empty.Join(single, -1); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -48,6 +48,35 @@ func (o *orbitDBEventLogStore) Add(ctx context.Context, value []byte) (operation
 	return op, nil
 }
 
+func (o *orbitDBEventLogStore) AddBatch(ctx context.Context, values [][]byte) ([]operation.Operation, error) {
+	ops := make([]operation.Operation, len(values))
+	for i, value := range values {
+		ops[i] = operation.NewOperation(nil, "ADD", value)
+	}
+
+	// the entries appended before a failure are given back with the error
+	entries, addErr := o.AddOperations(ctx, ops, nil)
+	if addErr != nil && len(entries) == 0 {
+		return nil, errors.Wrap(addErr, "error while adding values")
+	}
+
+	ops = ops[:len(entries)]
+	for i, e := range entries {
+		op, err := operation.ParseOperation(e)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse newly created entry")
+		}
+
+		ops[i] = op
+	}
+
+	if addErr != nil {
+		return ops, errors.Wrap(addErr, "error while adding values")
+	}
+
+	return ops, nil
+}
+
 func (o *orbitDBEventLogStore) Get(ctx context.Context, cid cid.Cid) (operation.Operation, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
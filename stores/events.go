@@ -1,6 +1,8 @@
 package stores
 
 import (
+	"time"
+
 	ipfslog "berty.tech/go-ipfs-log"
 	"berty.tech/go-orbit-db/address"
 	"berty.tech/go-orbit-db/stores/replicator"
@@ -66,6 +68,96 @@ func NewEventLoad(addr address.Address, heads []ipfslog.Entry) *EventLoad {
 	}
 }
 
+// EventReplicateRetry An event sent when fetching an entry failed and will be
+// retried
+type EventReplicateRetry struct {
+	Address address.Address
+	Hash    cid.Cid
+	Attempt int
+	Delay   time.Duration
+	Err     error
+}
+
+// NewEventReplicateRetry Creates a new EventReplicateRetry event
+func NewEventReplicateRetry(addr address.Address, h cid.Cid, attempt int, delay time.Duration, err error) *EventReplicateRetry {
+	return &EventReplicateRetry{
+		Address: addr,
+		Hash:    h,
+		Attempt: attempt,
+		Delay:   delay,
+		Err:     err,
+	}
+}
+
+// EventReplicateFailed An event sent when an entry couldn't be fetched after
+// all retries, the store might be incomplete
+type EventReplicateFailed struct {
+	Address  address.Address
+	Hash     cid.Cid
+	Attempts int
+	Err      error
+}
+
+// NewEventReplicateFailed Creates a new EventReplicateFailed event
+func NewEventReplicateFailed(addr address.Address, h cid.Cid, attempts int, err error) *EventReplicateFailed {
+	return &EventReplicateFailed{
+		Address:  addr,
+		Hash:     h,
+		Attempts: attempts,
+		Err:      err,
+	}
+}
+
+// EventHeadsAcknowledged An event sent when a peer acknowledged the heads it
+// received from this peer
+type EventHeadsAcknowledged struct {
+	Address address.Address
+	Peer    p2pcore.PeerID
+	Heads   []cid.Cid
+}
+
+// NewEventHeadsAcknowledged Creates a new EventHeadsAcknowledged event
+func NewEventHeadsAcknowledged(addr address.Address, p p2pcore.PeerID, heads []cid.Cid) *EventHeadsAcknowledged {
+	return &EventHeadsAcknowledged{
+		Address: addr,
+		Peer:    p,
+		Heads:   heads,
+	}
+}
+
+// HeadsRejectReason Why the heads received from a peer were rejected
+type HeadsRejectReason string
+
+const (
+	// HeadsRejectedPeer The peer is denied or not in the allowed peers
+	HeadsRejectedPeer HeadsRejectReason = "peer"
+
+	// HeadsRejectedTooMany The message holds more heads than allowed
+	HeadsRejectedTooMany HeadsRejectReason = "too-many-heads"
+
+	// HeadsRejectedRateLimit The peer sent too many messages
+	HeadsRejectedRateLimit HeadsRejectReason = "rate-limit"
+)
+
+// EventHeadsRejected An event sent when the heads received from a peer are
+// rejected by the peer lists or the limits of the store
+type EventHeadsRejected struct {
+	Address address.Address
+	Peer    p2pcore.PeerID
+	Count   int
+	Reason  HeadsRejectReason
+}
+
+// NewEventHeadsRejected Creates a new EventHeadsRejected event
+func NewEventHeadsRejected(addr address.Address, p p2pcore.PeerID, count int, reason HeadsRejectReason) *EventHeadsRejected {
+	return &EventHeadsRejected{
+		Address: addr,
+		Peer:    p,
+		Count:   count,
+		Reason:  reason,
+	}
+}
+
 //type EventLoadProgress struct {
 //	Address           address.Address
 //	Hash              cid.Cid
@@ -0,0 +1,3 @@
+// headlessstore a store replicating the log of any store type without
+// indexing it, used by the replication service
+package headlessstore // import "berty.tech/go-orbit-db/stores/headlessstore"
@@ -0,0 +1,57 @@
+package headlessstore
+
+import (
+	"context"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/identityprovider"
+	coreapi "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/pkg/errors"
+
+	"berty.tech/go-orbit-db/address"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores/basestore"
+)
+
+type headlessStore struct {
+	basestore.BaseStore
+}
+
+func (o *headlessStore) Type() string {
+	return "headless"
+}
+
+type headlessIndex struct{}
+
+// Get Always returns nil, the entries are not indexed
+func (i *headlessIndex) Get(_ string) interface{} {
+	return nil
+}
+
+// UpdateIndex Does nothing, the payloads of the entries are never read
+func (i *headlessIndex) UpdateIndex(_ ipfslog.Log, _ []ipfslog.Entry) error {
+	return nil
+}
+
+// NewHeadlessIndex Creates an index which doesn't index anything
+func NewHeadlessIndex(_ []byte) iface.StoreIndex {
+	return &headlessIndex{}
+}
+
+// NewHeadlessStore Instantiates a store replicating the log of a store of any
+// type, without reading the payloads of its entries
+func NewHeadlessStore(ctx context.Context, ipfs coreapi.CoreAPI, identity *identityprovider.Identity, addr address.Address, options *iface.NewStoreOptions) (iface.Store, error) {
+	store := &headlessStore{}
+
+	options.Index = NewHeadlessIndex
+
+	if err := store.InitBaseStore(ctx, ipfs, identity, addr, options); err != nil {
+		return nil, errors.Wrap(err, "unable to initialize base store")
+	}
+
+	return store, nil
+}
+
+var _ iface.StoreConstructor = NewHeadlessStore
+var _ iface.IndexConstructor = NewHeadlessIndex
+var _ iface.Store = &headlessStore{}
@@ -1,6 +1,8 @@
 package replicator
 
 import (
+	"time"
+
 	ipfslog "berty.tech/go-ipfs-log"
 	cid "github.com/ipfs/go-cid"
 )
@@ -46,3 +48,53 @@ func NewEventLoadEnd(logs []ipfslog.Log) *EventLoadEnd {
 		Logs: logs,
 	}
 }
+
+// EventFetchRetry An event triggered when a fetch failed and will be retried
+type EventFetchRetry struct {
+	Hash    cid.Cid
+	Attempt int
+	Delay   time.Duration
+	Err     error
+}
+
+// NewEventFetchRetry Creates a new EventFetchRetry event
+func NewEventFetchRetry(h cid.Cid, attempt int, delay time.Duration, err error) *EventFetchRetry {
+	return &EventFetchRetry{
+		Hash:    h,
+		Attempt: attempt,
+		Delay:   delay,
+		Err:     err,
+	}
+}
+
+// EventFetchFailed An event triggered when a hash couldn't be fetched after
+// all retries
+type EventFetchFailed struct {
+	Hash     cid.Cid
+	Attempts int
+	Err      error
+}
+
+// NewEventFetchFailed Creates a new EventFetchFailed event
+func NewEventFetchFailed(h cid.Cid, attempts int, err error) *EventFetchFailed {
+	return &EventFetchFailed{
+		Hash:     h,
+		Attempts: attempts,
+		Err:      err,
+	}
+}
+
+// EventLoadSkipped An event triggered when a fetched entry has been discarded
+// by the replication filter
+type EventLoadSkipped struct {
+	Hash     cid.Cid
+	Decision FilterDecision
+}
+
+// NewEventLoadSkipped Creates a new EventLoadSkipped event
+func NewEventLoadSkipped(h cid.Cid, decision FilterDecision) *EventLoadSkipped {
+	return &EventLoadSkipped{
+		Hash:     h,
+		Decision: decision,
+	}
+}
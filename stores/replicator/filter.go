@@ -0,0 +1,106 @@
+package replicator
+
+import (
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-orbit-db/stores/operation"
+)
+
+// FilterDecision What the replicator does with an entry given to a Filter
+type FilterDecision int
+
+const (
+	// FilterKeep The entry is kept and its history is replicated
+	FilterKeep FilterDecision = iota
+
+	// FilterSkip The entry is discarded but its history is still replicated
+	FilterSkip
+
+	// FilterStop The entry and its history are discarded
+	FilterStop
+)
+
+// Filter Decides which entries are replicated, it is called once for each
+// fetched entry. The heads received by a store are also given to the filter
+// before being fetched, only the ones it stops are dropped at this point, the
+// others are fetched and filtered like the rest of the history
+type Filter func(e ipfslog.Entry) FilterDecision
+
+// FilterByAuthors Keeps the entries written by the given identity IDs, the
+// history of other entries is still replicated
+func FilterByAuthors(ids ...string) Filter {
+	authors := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		authors[id] = struct{}{}
+	}
+
+	return func(e ipfslog.Entry) FilterDecision {
+		if e.GetIdentity() == nil {
+			return FilterSkip
+		}
+
+		if _, ok := authors[e.GetIdentity().ID]; !ok {
+			return FilterSkip
+		}
+
+		return FilterKeep
+	}
+}
+
+// FilterByClock Keeps the entries whose clock time is within [min, max], a
+// zero max means no upper bound. As parents always have a lower clock time,
+// the history of entries below min is not replicated
+func FilterByClock(min, max int) Filter {
+	return func(e ipfslog.Entry) FilterDecision {
+		if e.GetClock() == nil {
+			return FilterSkip
+		}
+
+		t := e.GetClock().GetTime()
+
+		if t < min {
+			return FilterStop
+		}
+
+		if max > 0 && t > max {
+			return FilterSkip
+		}
+
+		return FilterKeep
+	}
+}
+
+// FilterByOperation Keeps the entries whose decoded operation matches the
+// given predicate, entries which can't be decoded are kept
+func FilterByOperation(predicate func(op operation.Operation) bool) Filter {
+	return func(e ipfslog.Entry) FilterDecision {
+		op, err := operation.ParseOperation(e)
+		if err != nil {
+			return FilterKeep
+		}
+
+		if !predicate(op) {
+			return FilterSkip
+		}
+
+		return FilterKeep
+	}
+}
+
+// CombineFilters Returns the most restrictive decision of the given filters
+func CombineFilters(filters ...Filter) Filter {
+	return func(e ipfslog.Entry) FilterDecision {
+		decision := FilterKeep
+
+		for _, f := range filters {
+			if d := f(e); d > decision {
+				decision = d
+			}
+
+			if decision == FilterStop {
+				break
+			}
+		}
+
+		return decision
+	}
+}
@@ -0,0 +1,131 @@
+package replicator
+
+import (
+	"testing"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-orbit-db/stores/operation"
+	"github.com/stretchr/testify/require"
+)
+
+func testingFilterEntry(t *testing.T, author string, time int, op operation.Operation) ipfslog.Entry {
+	t.Helper()
+
+	e := &entry.Entry{}
+
+	if author != "" {
+		e.Identity = &identityprovider.Identity{ID: author}
+	}
+
+	if time > 0 {
+		e.Clock = entry.NewLamportClock([]byte(author), time)
+	}
+
+	if op != nil {
+		payload, err := op.Marshal()
+		require.NoError(t, err)
+
+		e.Payload = payload
+	}
+
+	return e
+}
+
+func TestFilterByAuthors(t *testing.T) {
+	filter := FilterByAuthors("alice", "bob")
+
+	for _, tc := range []struct {
+		name     string
+		author   string
+		expected FilterDecision
+	}{
+		{name: "first author", author: "alice", expected: FilterKeep},
+		{name: "second author", author: "bob", expected: FilterKeep},
+		{name: "other author", author: "carol", expected: FilterSkip},
+		{name: "no identity", author: "", expected: FilterSkip},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, filter(testingFilterEntry(t, tc.author, 1, nil)))
+		})
+	}
+}
+
+func TestFilterByClock(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		min      int
+		max      int
+		time     int
+		expected FilterDecision
+	}{
+		{name: "within the bounds", min: 2, max: 5, time: 3, expected: FilterKeep},
+		{name: "on the lower bound", min: 2, max: 5, time: 2, expected: FilterKeep},
+		{name: "on the upper bound", min: 2, max: 5, time: 5, expected: FilterKeep},
+		{name: "below the lower bound", min: 2, max: 5, time: 1, expected: FilterStop},
+		{name: "above the upper bound", min: 2, max: 5, time: 6, expected: FilterSkip},
+		{name: "no upper bound", min: 2, time: 100, expected: FilterKeep},
+		{name: "no clock", min: 2, max: 5, time: 0, expected: FilterSkip},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, FilterByClock(tc.min, tc.max)(testingFilterEntry(t, "alice", tc.time, nil)))
+		})
+	}
+}
+
+func TestFilterByOperation(t *testing.T) {
+	filter := FilterByOperation(func(op operation.Operation) bool {
+		return op.GetOperation() == "PUT"
+	})
+
+	key := "key"
+
+	for _, tc := range []struct {
+		name     string
+		op       operation.Operation
+		expected FilterDecision
+	}{
+		{name: "matching operation", op: operation.NewOperation(&key, "PUT", []byte("value")), expected: FilterKeep},
+		{name: "other operation", op: operation.NewOperation(&key, "DEL", nil), expected: FilterSkip},
+		{name: "undecodable payload", op: nil, expected: FilterKeep},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, filter(testingFilterEntry(t, "alice", 1, tc.op)))
+		})
+	}
+}
+
+func TestCombineFilters(t *testing.T) {
+	decide := func(d FilterDecision, calls *int) Filter {
+		return func(ipfslog.Entry) FilterDecision {
+			*calls++
+			return d
+		}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		decisions []FilterDecision
+		expected  FilterDecision
+		calls     int
+	}{
+		{name: "no filters", decisions: nil, expected: FilterKeep, calls: 0},
+		{name: "all keep", decisions: []FilterDecision{FilterKeep, FilterKeep}, expected: FilterKeep, calls: 2},
+		{name: "skip wins over keep", decisions: []FilterDecision{FilterKeep, FilterSkip, FilterKeep}, expected: FilterSkip, calls: 3},
+		{name: "stop wins over skip", decisions: []FilterDecision{FilterSkip, FilterStop}, expected: FilterStop, calls: 2},
+		{name: "stop ends the evaluation", decisions: []FilterDecision{FilterStop, FilterKeep, FilterSkip}, expected: FilterStop, calls: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+
+			filters := make([]Filter, len(tc.decisions))
+			for i, d := range tc.decisions {
+				filters[i] = decide(d, &calls)
+			}
+
+			require.Equal(t, tc.expected, CombineFilters(filters...)(testingFilterEntry(t, "alice", 1, nil)))
+			require.Equal(t, tc.calls, calls)
+		})
+	}
+}
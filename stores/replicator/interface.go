@@ -11,8 +11,9 @@ import (
 	coreapi "github.com/ipfs/interface-go-ipfs-core"
 )
 
-// storeInterface An interface used to avoid import cycles
-type storeInterface interface {
+// StoreInterface The store methods used by a replicator, it is declared here
+// to avoid import cycles
+type StoreInterface interface {
 	OpLog() ipfslog.Log
 	IPFS() coreapi.CoreAPI
 	Identity() *identityprovider.Identity
@@ -21,6 +22,11 @@ type storeInterface interface {
 	IO() ipfslog.IO
 }
 
+// Constructor Creates a replicator for the given store, see NewReplicator.
+// The store merges the logs sent in EventLoadEnd events and tracks the
+// progress using EventLoadAdded and EventLoadProgress events
+type Constructor func(ctx context.Context, store StoreInterface, concurrency uint, opts *Options) Replicator
+
 // Replicator Replicates stores information among peers
 type Replicator interface {
 	events.EmitterInterface
@@ -36,6 +42,16 @@ type Replicator interface {
 
 	// GetBufferLen Gets the length of the buffer
 	GetBufferLen() int
+
+	// GetPending Returns the hashes which are queued, being fetched, waiting
+	// for a retry or fetched but not yet merged in the store
+	GetPending() []cid.Cid
+
+	// GetFailed Returns the hashes which couldn't be fetched after all retries
+	GetFailed() []*FailedFetch
+
+	// RetryFailed Queues the failed hashes again
+	RetryFailed(ctx context.Context)
 }
 
 // ReplicationInfo Holds information about the current replication state
@@ -0,0 +1,160 @@
+package replicator
+
+import (
+	"container/heap"
+	"sort"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// headPriority The priority of the hashes given to Load, higher than the
+// clock time of any entry
+const headPriority = int(^uint(0) >> 1)
+
+// queuedHash A hash waiting to be fetched by the replicator
+type queuedHash struct {
+	hash cid.Cid
+
+	// priority The clock time of the entry referencing the hash, or
+	// headPriority for the hashes given to Load
+	priority int
+
+	// depth The number of hops from the hashes given to Load
+	depth int
+
+	// batchSize The number of entries to fetch in adaptive mode, grown
+	// after each full batch of the history, the default when zero
+	batchSize int
+
+	index int
+}
+
+// hashQueue A priority queue of hashes, the ones referenced by the most
+// recent entries being fetched first
+type hashQueue struct {
+	items  []*queuedHash
+	byHash map[string]*queuedHash
+}
+
+func newHashQueue() *hashQueue {
+	return &hashQueue{
+		byHash: map[string]*queuedHash{},
+	}
+}
+
+func (q *hashQueue) Len() int {
+	return len(q.items)
+}
+
+func (q *hashQueue) Less(i, j int) bool {
+	if q.items[i].priority != q.items[j].priority {
+		return q.items[i].priority > q.items[j].priority
+	}
+
+	return q.items[i].depth < q.items[j].depth
+}
+
+func (q *hashQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+// Push Implements heap.Interface, use add instead
+func (q *hashQueue) Push(x interface{}) {
+	item := x.(*queuedHash)
+	item.index = len(q.items)
+	q.items = append(q.items, item)
+}
+
+// Pop Implements heap.Interface, use pop instead
+func (q *hashQueue) Pop() interface{} {
+	n := len(q.items)
+	item := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	item.index = -1
+
+	return item
+}
+
+// add Queues a hash, a hash already queued keeps the highest priority and
+// the lowest depth of both, it returns false in that case
+func (q *hashQueue) add(item *queuedHash) bool {
+	existing, ok := q.byHash[item.hash.String()]
+	if !ok {
+		q.byHash[item.hash.String()] = item
+		heap.Push(q, item)
+
+		return true
+	}
+
+	changed := false
+
+	if item.priority > existing.priority {
+		existing.priority = item.priority
+		changed = true
+	}
+
+	if item.depth < existing.depth {
+		existing.depth = item.depth
+		changed = true
+	}
+
+	if changed {
+		heap.Fix(q, existing.index)
+	}
+
+	return false
+}
+
+// pop Removes and returns the hash with the highest priority
+func (q *hashQueue) pop() *queuedHash {
+	if len(q.items) == 0 {
+		return nil
+	}
+
+	item := heap.Pop(q).(*queuedHash)
+	delete(q.byHash, item.hash.String())
+
+	return item
+}
+
+// has Returns whether the given hash is queued
+func (q *hashQueue) has(c cid.Cid) bool {
+	_, ok := q.byHash[c.String()]
+
+	return ok
+}
+
+// remove Removes the given hash from the queue
+func (q *hashQueue) remove(c cid.Cid) {
+	item, ok := q.byHash[c.String()]
+	if !ok {
+		return
+	}
+
+	heap.Remove(q, item.index)
+	delete(q.byHash, c.String())
+}
+
+// hashes Returns the queued hashes, by decreasing priority
+func (q *hashQueue) hashes() []cid.Cid {
+	items := make([]*queuedHash, len(q.items))
+	copy(items, q.items)
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].priority != items[j].priority {
+			return items[i].priority > items[j].priority
+		}
+
+		return items[i].depth < items[j].depth
+	})
+
+	hashes := make([]cid.Cid, len(items))
+	for i, item := range items {
+		hashes[i] = item.hash
+	}
+
+	return hashes
+}
@@ -0,0 +1,51 @@
+package replicator
+
+import (
+	"fmt"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func testingCID(t *testing.T, i int) cid.Cid {
+	t.Helper()
+
+	c, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: 0x12 /* sha2-256 */}.Sum([]byte(fmt.Sprintf("entry-%d", i)))
+	require.NoError(t, err)
+
+	return c
+}
+
+func TestHashQueue(t *testing.T) {
+	q := newHashQueue()
+
+	c1, c2, c3, c4 := testingCID(t, 1), testingCID(t, 2), testingCID(t, 3), testingCID(t, 4)
+
+	require.True(t, q.add(&queuedHash{hash: c1, priority: 3, depth: 2}))
+	require.True(t, q.add(&queuedHash{hash: c2, priority: 10, depth: 1}))
+	require.True(t, q.add(&queuedHash{hash: c3, priority: headPriority}))
+	require.True(t, q.add(&queuedHash{hash: c4, priority: 10, depth: 3}))
+
+	// re-adding keeps the highest priority
+	require.False(t, q.add(&queuedHash{hash: c1, priority: 12, depth: 1}))
+	require.False(t, q.add(&queuedHash{hash: c2, priority: 1, depth: 5}))
+
+	require.Equal(t, 4, q.Len())
+	require.True(t, q.has(c4))
+	require.Equal(t, []cid.Cid{c3, c1, c2, c4}, q.hashes())
+
+	q.remove(c4)
+	require.False(t, q.has(c4))
+
+	require.Equal(t, c3, q.pop().hash)
+	require.Equal(t, c1, q.pop().hash)
+
+	item := q.pop()
+	require.Equal(t, c2, item.hash)
+	require.Equal(t, 10, item.priority)
+	require.Equal(t, 1, item.depth)
+
+	require.Nil(t, q.pop())
+	require.Equal(t, 0, q.Len())
+}
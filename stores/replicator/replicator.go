@@ -18,7 +18,13 @@ import (
 	"go.uber.org/zap"
 )
 
-var batchSize = 1
+const (
+	defaultBatchSize       = 1
+	defaultMaxBatchSize    = 256
+	defaultMaxRetries      = 5
+	defaultRetryBackoff    = time.Second
+	defaultMaxRetryBackoff = time.Minute
+)
 
 type replicator struct {
 	// These require 64 bit alignment for ARM and 32bit devices
@@ -29,15 +35,37 @@ type replicator struct {
 
 	events.EventEmitter
 
+	ctx         context.Context
 	cancelFunc  context.CancelFunc
-	store       storeInterface
+	store       StoreInterface
 	fetching    map[string]cid.Cid
 	buffer      []ipfslog.Log
 	concurrency int64
-	queue       map[string]cid.Cid
+	queue       *hashQueue
+	workers     int64
 	lock        sync.RWMutex
 	logger      *zap.Logger
 	tracer      trace.Tracer
+
+	batchSize         int
+	maxBatchSize      int
+	adaptiveBatchSize bool
+	fetchConcurrency  int
+	fetchTimeout      time.Duration
+
+	attempts        map[string]int
+	failed          map[string]*FailedFetch
+	maxRetries      int
+	retryBackoff    time.Duration
+	maxRetryBackoff time.Duration
+
+	filter  Filter
+	skipped map[string]struct{}
+
+	maxDepth int
+
+	// fetch Fetches up to batchSize entries of the history of a hash
+	fetch func(ctx context.Context, h cid.Cid, batchSize int) (ipfslog.Log, error)
 }
 
 func (r *replicator) GetBufferLen() int {
@@ -55,50 +83,186 @@ func (r *replicator) GetQueue() []cid.Cid {
 	r.lock.RLock()
 	defer r.lock.RUnlock()
 
-	queue := make([]cid.Cid, len(r.queue))
-	i := 0
+	return r.queue.hashes()
+}
+
+func (r *replicator) Load(ctx context.Context, cids []cid.Cid) {
+	// explicitly requested hashes get a fresh set of attempts
+	r.lock.Lock()
+	for _, c := range cids {
+		delete(r.failed, c.String())
+		delete(r.attempts, c.String())
+	}
+	r.lock.Unlock()
 
-	for _, c := range r.queue {
-		queue[i] = c
-		i++
+	items := make([]*queuedHash, len(cids))
+	for i, c := range cids {
+		items[i] = &queuedHash{
+			hash:     c,
+			priority: headPriority,
+		}
 	}
 
-	return queue
+	r.load(ctx, items)
 }
 
-func (r *replicator) Load(ctx context.Context, cids []cid.Cid) {
-	cidsStrings := make([]string, len(cids))
-	for i, c := range cids {
-		cidsStrings[i] = c.String()
+func (r *replicator) GetPending() []cid.Cid {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	seen := map[string]struct{}{}
+	pending := []cid.Cid(nil)
+
+	add := func(c cid.Cid) {
+		if _, ok := seen[c.String()]; ok {
+			return
+		}
+
+		seen[c.String()] = struct{}{}
+		pending = append(pending, c)
+	}
+
+	for _, c := range r.queue.hashes() {
+		add(c)
+	}
+
+	for _, c := range r.fetching {
+		// fetched hashes stay in r.fetching once merged in the store
+		if _, ok := r.store.OpLog().Get(c); ok {
+			continue
+		}
+
+		add(c)
+	}
+
+	// hashes waiting for a retry
+	for k := range r.attempts {
+		c, err := cid.Decode(k)
+		if err != nil {
+			continue
+		}
+
+		add(c)
+	}
+
+	// fetched logs are not joined yet
+	for _, l := range r.buffer {
+		for _, h := range l.Heads().Slice() {
+			add(h.GetHash())
+		}
+	}
+
+	return pending
+}
+
+func (r *replicator) GetFailed() []*FailedFetch {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	failed := make([]*FailedFetch, 0, len(r.failed))
+	for _, f := range r.failed {
+		failed = append(failed, f)
+	}
+
+	return failed
+}
+
+func (r *replicator) RetryFailed(ctx context.Context) {
+	r.lock.RLock()
+	cids := make([]cid.Cid, 0, len(r.failed))
+	for _, f := range r.failed {
+		cids = append(cids, f.Hash)
+	}
+	r.lock.RUnlock()
+
+	if len(cids) > 0 {
+		r.Load(ctx, cids)
+	}
+}
+
+// load Queues the given hashes and starts the workers processing the queue
+func (r *replicator) load(ctx context.Context, items []*queuedHash) {
+	cidsStrings := make([]string, len(items))
+	for i, item := range items {
+		cidsStrings[i] = item.hash.String()
 	}
 
 	ctx, span := r.tracer.Start(ctx, "replicator-load", trace.WithAttributes(otkv.String("cids", strings.Join(cidsStrings, ","))))
 	defer span.End()
 
-	for _, h := range cids {
+	for _, item := range items {
+		h := item.hash
 		_, inLog := r.store.OpLog().Get(h)
 		r.lock.RLock()
 		_, fetching := r.fetching[h.String()]
-		_, queued := r.queue[h.String()]
+		_, skipped := r.skipped[h.String()]
 		r.lock.RUnlock()
 
-		if fetching || queued || inLog {
+		if fetching || inLog || skipped {
 			continue
 		}
 
-		r.addToQueue(ctx, span, h)
+		r.addToQueue(ctx, span, item)
 	}
 
-	r.processQueue(ctx)
+	r.processQueue()
 }
 
 type Options struct {
 	Logger *zap.Logger
 	Tracer trace.Tracer
+
+	// BatchSize The number of entries fetched at once when following the
+	// history of an entry, defaults to 1
+	BatchSize int
+
+	// AdaptiveBatchSize Doubles the batch size each time a full batch of
+	// the history of an entry is fetched, up to MaxBatchSize, so a long
+	// history is fetched in fewer rounds. The size is reset once a batch
+	// reaches the end of the history or entries already known
+	AdaptiveBatchSize bool
+
+	// MaxBatchSize The upper bound of the batch size in adaptive mode,
+	// defaults to 256
+	MaxBatchSize int
+
+	// FetchConcurrency The number of entries of a batch fetched concurrently
+	FetchConcurrency int
+
+	// FetchTimeout The maximum duration of a single fetch, unbounded when zero
+	FetchTimeout time.Duration
+
+	// MaxRetries The number of times a failed fetch is retried before the
+	// hash is marked as failed, defaults to 5, a negative value disables
+	// retries
+	MaxRetries int
+
+	// RetryBackoff The delay before the first retry, doubled after each
+	// failed attempt up to MaxRetryBackoff, defaults to 1s
+	RetryBackoff time.Duration
+
+	// MaxRetryBackoff The upper bound of the delay between two retries,
+	// defaults to 1m
+	MaxRetryBackoff time.Duration
+
+	// Filter Decides which fetched entries are kept, entries are fetched one
+	// by one when set
+	Filter Filter
+
+	// MaxDepth The number of next and refs hops followed from the loaded
+	// hashes, unlimited when zero
+	MaxDepth int
+}
+
+// FailedFetch Describes a hash which couldn't be fetched after all retries
+type FailedFetch struct {
+	Hash     cid.Cid
+	Attempts int
+	Err      error
 }
 
 // NewReplicator Creates a new Replicator instance
-func NewReplicator(ctx context.Context, store storeInterface, concurrency uint, opts *Options) Replicator {
+func NewReplicator(ctx context.Context, store StoreInterface, concurrency uint, opts *Options) Replicator {
 	if opts == nil {
 		opts = &Options{}
 	}
@@ -117,27 +281,78 @@ func NewReplicator(ctx context.Context, store storeInterface, concurrency uint,
 		concurrency = 128
 	}
 
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+
+	if opts.MaxBatchSize <= 0 {
+		opts.MaxBatchSize = defaultMaxBatchSize
+	}
+
+	if opts.MaxBatchSize < opts.BatchSize {
+		opts.MaxBatchSize = opts.BatchSize
+	}
+
+	if opts.Filter != nil {
+		opts.BatchSize = 1
+		opts.AdaptiveBatchSize = false
+	}
+
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = defaultMaxRetries
+	} else if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = defaultRetryBackoff
+	}
+
+	if opts.MaxRetryBackoff <= 0 {
+		opts.MaxRetryBackoff = defaultMaxRetryBackoff
+	}
+
+	if opts.MaxRetryBackoff < opts.RetryBackoff {
+		opts.MaxRetryBackoff = opts.RetryBackoff
+	}
+
 	r := replicator{
-		cancelFunc:  cancelFunc,
-		concurrency: int64(concurrency),
-		store:       store,
-		queue:       map[string]cid.Cid{},
-		fetching:    map[string]cid.Cid{},
-		logger:      opts.Logger,
-		tracer:      opts.Tracer,
+		ctx:               ctx,
+		cancelFunc:        cancelFunc,
+		concurrency:       int64(concurrency),
+		store:             store,
+		queue:             newHashQueue(),
+		fetching:          map[string]cid.Cid{},
+		logger:            opts.Logger,
+		tracer:            opts.Tracer,
+		batchSize:         opts.BatchSize,
+		maxBatchSize:      opts.MaxBatchSize,
+		adaptiveBatchSize: opts.AdaptiveBatchSize,
+		fetchConcurrency:  opts.FetchConcurrency,
+		fetchTimeout:      opts.FetchTimeout,
+		attempts:          map[string]int{},
+		failed:            map[string]*FailedFetch{},
+		maxRetries:        opts.MaxRetries,
+		retryBackoff:      opts.RetryBackoff,
+		maxRetryBackoff:   opts.MaxRetryBackoff,
+		filter:            opts.Filter,
+		skipped:           map[string]struct{}{},
+		maxDepth:          opts.MaxDepth,
 	}
 
+	r.fetch = r.fetchLog
+
 	go func() {
 		for {
 			select {
 			case <-time.After(time.Second * 3):
 				r.lock.RLock()
-				qLen := len(r.queue)
+				qLen := r.queue.Len()
 				r.lock.RUnlock()
 
 				if r.tasksRunning() == 0 && qLen > 0 {
 					r.logger.Debug(fmt.Sprintf("Had to flush the queue! %d items in the queue, %d %d tasks requested/finished", qLen, r.tasksRequested(), r.tasksFinished()))
-					go r.processQueue(ctx)
+					r.processQueue()
 				}
 			case <-ctx.Done():
 				return
@@ -160,146 +375,324 @@ func (r *replicator) tasksFinished() int64 {
 	return atomic.LoadInt64(&r.statsTasksProcessed)
 }
 
-func (r *replicator) processOne(ctx context.Context, h cid.Cid) ([]cid.Cid, error) {
+func (r *replicator) processOne(ctx context.Context, item *queuedHash) ([]*queuedHash, error) {
 	ctx, span := r.tracer.Start(ctx, "replicator-process-one")
 	defer span.End()
 
-	r.lock.Lock()
-	defer r.lock.Unlock()
+	h := item.hash
 
+	r.lock.Lock()
 	_, isFetching := r.fetching[h.String()]
 	_, hasEntry := r.store.OpLog().Get(h)
 
 	if hasEntry || isFetching {
+		r.lock.Unlock()
 		return nil, nil
 	}
 
+	batchSize := r.fetchBatchSize(item)
 	r.fetching[h.String()] = h
+	r.lock.Unlock()
 
 	r.Emit(ctx, NewEventLoadAdded(h))
 
-	atomic.AddInt64(&r.statsTasksStarted, 1)
+	// the lock is not held while fetching, so fetches run concurrently
+	l, err := r.fetch(ctx, h, batchSize)
 
-	l, err := ipfslog.NewFromEntryHash(ctx, r.store.IPFS(), r.store.Identity(), h, &ipfslog.LogOptions{
-		ID:               r.store.OpLog().GetID(),
-		AccessController: r.store.AccessController(),
-		SortFn:           r.store.SortFn(),
-		IO:               r.store.IO(),
-	}, &ipfslog.FetchOptions{
-		Length: &batchSize,
-	})
+	r.lock.Lock()
+	defer r.lock.Unlock()
 
 	if err != nil {
+		// release the hash so it can be fetched again, a fetch interrupted
+		// by Stop stays pending so it is saved with the replication queue
+		if r.ctx.Err() == nil {
+			delete(r.fetching, h.String())
+		}
+
 		return nil, errors.Wrap(err, "unable to fetch log")
 	}
 
+	delete(r.attempts, h.String())
+	r.queue.remove(h)
+
+	if r.filter != nil {
+		// batches are made of a single entry when a filter is set
+		e, ok := l.Get(h)
+		if !ok {
+			delete(r.fetching, h.String())
+
+			return nil, errors.New("fetched log doesn't contain the requested entry")
+		}
+
+		if decision := r.filter(e); decision != FilterKeep {
+			delete(r.fetching, h.String())
+			r.skipped[h.String()] = struct{}{}
+
+			r.Emit(ctx, NewEventLoadSkipped(h, decision))
+
+			if decision == FilterStop {
+				return nil, nil
+			}
+
+			return r.nextHashes(item, l), nil
+		}
+	}
+
 	var logToAppend ipfslog.Log = l
 
 	r.buffer = append(r.buffer, logToAppend)
 
 	latest := l.Values().At(0)
 
-	delete(r.queue, h.String())
-
 	// Mark this task as processed
 	//r.statsTasksProcessed++
 
 	// Notify subscribers that we made progress
 	r.Emit(ctx, NewEventLoadProgress("", h, latest, len(r.buffer))) // TODO JS: this._id should be undefined
 
-	var nextValues []cid.Cid
+	next := r.nextHashes(item, l)
+
+	if r.adaptiveBatchSize && l.Len() >= batchSize {
+		// a full batch means the history goes on, grow the next batches
+		grown := batchSize * 2
+		if grown > r.maxBatchSize {
+			grown = r.maxBatchSize
+		}
 
-	for _, e := range l.Values().Slice() {
-		nextValues = append(nextValues, e.GetNext()...)
-		nextValues = append(nextValues, e.GetRefs()...)
+		for _, n := range next {
+			n.batchSize = grown
+		}
 	}
 
 	// Return all next pointers
-	return nextValues, nil
+	return next, nil
 }
 
-func (r *replicator) processQueue(ctx context.Context) {
-	if r.tasksRunning() >= r.concurrency {
-		return
-	}
-
-	ctx, span := r.tracer.Start(ctx, "replicator-process-queue")
-	defer span.End()
+// fetchBatchSize Returns the number of entries to fetch for a queued hash,
+// the caller must hold the lock
+func (r *replicator) fetchBatchSize(item *queuedHash) int {
+	batchSize := r.batchSize
 
-	capacity := r.concurrency - r.tasksRunning()
-	slicedQueue := r.GetQueue()
-	if int64(len(slicedQueue)) < capacity {
-		capacity = int64(len(slicedQueue))
+	if r.adaptiveBatchSize && item.batchSize > batchSize {
+		batchSize = item.batchSize
+		if batchSize > r.maxBatchSize {
+			batchSize = r.maxBatchSize
+		}
 	}
 
-	items := map[string]cid.Cid{}
-	for _, h := range slicedQueue[:capacity] {
-		items[h.String()] = h
+	if r.maxDepth > 0 && batchSize > r.maxDepth-item.depth+1 {
+		// a batch of n entries can't go further than n-1 hops
+		batchSize = r.maxDepth - item.depth + 1
 	}
 
-	var hashesList = make([][]cid.Cid, len(items))
-	hashesListIdx := 0
-	wg := sync.WaitGroup{}
+	return batchSize
+}
 
-	r.lock.Lock()
-	for _, e := range items {
-		delete(r.queue, e.String())
+// fetchLog Fetches up to batchSize entries of the history of a hash from
+// IPFS
+func (r *replicator) fetchLog(ctx context.Context, h cid.Cid, batchSize int) (ipfslog.Log, error) {
+	if r.fetchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.fetchTimeout)
+		defer cancel()
 	}
-	r.lock.Unlock()
 
-	for _, e := range items {
-		wg.Add(1)
+	return ipfslog.NewFromEntryHash(ctx, r.store.IPFS(), r.store.Identity(), h, &ipfslog.LogOptions{
+		ID:               r.store.OpLog().GetID(),
+		AccessController: r.store.AccessController(),
+		SortFn:           r.store.SortFn(),
+		IO:               r.store.IO(),
+	}, &ipfslog.FetchOptions{
+		Length:      &batchSize,
+		Concurrency: r.fetchConcurrency,
+		Timeout:     r.fetchTimeout,
+	})
+}
 
-		go func(hashesListIdx int, e cid.Cid) {
-			defer wg.Done()
+// nextHashes Returns the hashes referenced by the entries of a log fetched
+// from item which are not part of it and within the max depth
+func (r *replicator) nextHashes(item *queuedHash, l ipfslog.Log) []*queuedHash {
+	// compute the depth of the fetched entries from the fetched hash
+	depths := map[string]int{item.hash.String(): item.depth}
+	pending := []cid.Cid{item.hash}
 
-			hashes, err := r.processOne(ctx, e)
-			if err != nil {
-				r.logger.Error("unable to get data to process %v", zap.Error(err))
-				return
+	var nextValues []*queuedHash
+
+	for len(pending) > 0 {
+		h := pending[0]
+		pending = pending[1:]
+
+		e, ok := l.Get(h)
+		if !ok {
+			continue
+		}
+
+		depth := depths[h.String()] + 1
+
+		for _, n := range append(e.GetNext(), e.GetRefs()...) {
+			if _, ok := depths[n.String()]; ok {
+				continue
+			}
+
+			depths[n.String()] = depth
+
+			// entries of the batch are already fetched
+			if _, ok := l.Get(n); ok {
+				pending = append(pending, n)
+				continue
 			}
 
-			hashesList[hashesListIdx] = hashes
-		}(hashesListIdx, e)
+			if r.maxDepth > 0 && depth > r.maxDepth {
+				continue
+			}
 
-		hashesListIdx++
+			nextValues = append(nextValues, &queuedHash{
+				hash:     n,
+				priority: e.GetClock().GetTime(),
+				depth:    depth,
+			})
+		}
 	}
 
-	wg.Wait()
+	return nextValues
+}
 
-	for _, hashes := range hashesList {
-		r.lock.RLock()
-		b := r.buffer
-		bLen := len(b)
-		r.lock.RUnlock()
+// processQueue Starts workers, up to the concurrency, to fetch the queued
+// hashes
+func (r *replicator) processQueue() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 
-		// Mark this task as processed
-		atomic.AddInt64(&r.statsTasksProcessed, 1)
+	for r.workers < r.concurrency && r.workers < int64(r.queue.Len()) {
+		r.workers++
+
+		// workers outlive the load which started them
+		go r.worker(r.ctx)
+	}
+}
+
+// worker Fetches the queued hash with the highest priority until the queue is
+// empty or the replicator is stopped
+func (r *replicator) worker(ctx context.Context) {
+	ctx, span := r.tracer.Start(ctx, "replicator-worker")
+	defer span.End()
 
-		if bLen > 0 && r.tasksRunning() == 0 {
-			r.lock.Lock()
-			r.buffer = []ipfslog.Log{}
+	for {
+		r.lock.Lock()
+		var item *queuedHash
+		if ctx.Err() == nil {
+			item = r.queue.pop()
+		}
+
+		if item == nil {
+			r.workers--
 			r.lock.Unlock()
 
-			r.logger.Debug(fmt.Sprintf("load end logs, logs found :%d", bLen))
+			return
+		}
+
+		atomic.AddInt64(&r.statsTasksStarted, 1)
+		r.lock.Unlock()
 
-			r.Emit(ctx, NewEventLoadEnd(b))
+		hashes, err := r.processOne(ctx, item)
+		if err != nil {
+			r.logger.Error("unable to get data to process", zap.Error(err))
+			r.fetchFailed(ctx, item, err)
 		}
 
+		// the next hashes are queued before this task is marked as
+		// processed, so the load doesn't look over in the meantime
 		if len(hashes) > 0 {
-			r.Load(ctx, hashes)
+			r.load(ctx, hashes)
 		}
+
+		atomic.AddInt64(&r.statsTasksProcessed, 1)
+
+		r.flushBuffer(ctx)
+	}
+}
+
+// flushBuffer Sends the fetched logs to the store once no fetch is running,
+// or when enough logs have been buffered
+func (r *replicator) flushBuffer(ctx context.Context) {
+	r.lock.Lock()
+	b := r.buffer
+	bLen := len(b)
+
+	if bLen == 0 || (r.tasksRunning() > 0 && int64(bLen) < r.concurrency) {
+		r.lock.Unlock()
+		return
 	}
+
+	r.buffer = []ipfslog.Log{}
+	r.lock.Unlock()
+
+	r.logger.Debug(fmt.Sprintf("load end logs, logs found :%d", bLen))
+
+	r.Emit(ctx, NewEventLoadEnd(b))
 }
 
-func (r *replicator) addToQueue(ctx context.Context, span trace.Span, h cid.Cid) {
-	span.AddEvent(ctx, "replicator-add-to-queue", otkv.String("cid", h.String()))
+func (r *replicator) addToQueue(ctx context.Context, span trace.Span, item *queuedHash) {
+	span.AddEvent(ctx, "replicator-add-to-queue", otkv.String("cid", item.hash.String()))
 
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
-	atomic.AddInt64(&r.statsTasksRequested, 1)
-	r.queue[h.String()] = h
+	if r.queue.add(item) {
+		atomic.AddInt64(&r.statsTasksRequested, 1)
+	}
+}
+
+// fetchFailed Schedules a new attempt to fetch the given hash after a backoff
+// delay, or marks it as failed when all retries have been used
+func (r *replicator) fetchFailed(ctx context.Context, item *queuedHash, err error) {
+	h := item.hash
+
+	if r.ctx.Err() != nil {
+		// the replicator has been stopped
+		return
+	}
+
+	r.lock.Lock()
+	r.attempts[h.String()]++
+	attempts := r.attempts[h.String()]
+
+	if attempts > r.maxRetries {
+		delete(r.attempts, h.String())
+
+		r.failed[h.String()] = &FailedFetch{
+			Hash:     h,
+			Attempts: attempts,
+			Err:      err,
+		}
+		r.lock.Unlock()
+
+		r.logger.Warn("giving up fetching entry", zap.String("cid", h.String()), zap.Int("attempts", attempts), zap.Error(err))
+		r.Emit(ctx, NewEventFetchFailed(h, attempts, err))
+
+		return
+	}
+	r.lock.Unlock()
+
+	delay := r.retryBackoff
+	for i := 1; i < attempts && delay < r.maxRetryBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > r.maxRetryBackoff {
+		delay = r.maxRetryBackoff
+	}
+
+	r.Emit(ctx, NewEventFetchRetry(h, attempts, delay, err))
+
+	time.AfterFunc(delay, func() {
+		if r.ctx.Err() != nil {
+			return
+		}
+
+		r.load(r.ctx, []*queuedHash{item})
+	})
 }
 
 var _ Replicator = &replicator{}
+var _ Constructor = NewReplicator
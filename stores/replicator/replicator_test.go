@@ -0,0 +1,486 @@
+package replicator
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-ipfs-log/identityprovider"
+	logiface "berty.tech/go-ipfs-log/iface"
+	"berty.tech/go-orbit-db/accesscontroller"
+	cid "github.com/ipfs/go-cid"
+	coreapi "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// testingLog A log holding the given entries, the methods which aren't used
+// by the replicator are left unimplemented
+type testingLog struct {
+	ipfslog.Log
+
+	entries []ipfslog.Entry
+}
+
+func (l *testingLog) GetID() string {
+	return "testing"
+}
+
+func (l *testingLog) Get(c cid.Cid) (ipfslog.Entry, bool) {
+	for _, e := range l.entries {
+		if e.GetHash().Equals(c) {
+			return e, true
+		}
+	}
+
+	return nil, false
+}
+
+func (l *testingLog) Len() int {
+	return len(l.entries)
+}
+
+func (l *testingLog) Values() logiface.IPFSLogOrderedEntries {
+	return entry.NewOrderedMapFromEntries(l.entries)
+}
+
+func (l *testingLog) Heads() logiface.IPFSLogOrderedEntries {
+	if len(l.entries) == 0 {
+		return entry.NewOrderedMapFromEntries(nil)
+	}
+
+	return entry.NewOrderedMapFromEntries(l.entries[:1])
+}
+
+// testingStore A store with an empty log
+type testingStore struct {
+	log *testingLog
+}
+
+func (s *testingStore) OpLog() ipfslog.Log                           { return s.log }
+func (s *testingStore) IPFS() coreapi.CoreAPI                        { return nil }
+func (s *testingStore) Identity() *identityprovider.Identity         { return nil }
+func (s *testingStore) AccessController() accesscontroller.Interface { return nil }
+func (s *testingStore) SortFn() ipfslog.SortFn                       { return nil }
+func (s *testingStore) IO() ipfslog.IO                               { return nil }
+
+func testingEntry(h cid.Cid, time int, next ...cid.Cid) ipfslog.Entry {
+	return &entry.Entry{
+		Hash:  h,
+		Next:  next,
+		Clock: entry.NewLamportClock([]byte("testing"), time),
+	}
+}
+
+func testingReplicator(ctx context.Context, t *testing.T, concurrency uint, opts *Options) *replicator {
+	t.Helper()
+
+	r := NewReplicator(ctx, &testingStore{log: &testingLog{}}, concurrency, opts)
+	t.Cleanup(r.Stop)
+
+	return r.(*replicator)
+}
+
+func TestReplicatorConcurrentFetches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const concurrency = 4
+
+	r := testingReplicator(ctx, t, concurrency, nil)
+
+	var running, maxRunning, calls int64
+	release := make(chan struct{})
+
+	r.fetch = func(ctx context.Context, h cid.Cid, batchSize int) (ipfslog.Log, error) {
+		atomic.AddInt64(&calls, 1)
+
+		n := atomic.AddInt64(&running, 1)
+		defer atomic.AddInt64(&running, -1)
+
+		for {
+			max := atomic.LoadInt64(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt64(&maxRunning, max, n) {
+				break
+			}
+		}
+
+		<-release
+
+		return &testingLog{entries: []ipfslog.Entry{testingEntry(h, 1)}}, nil
+	}
+
+	cids := make([]cid.Cid, concurrency*3)
+	for i := range cids {
+		cids[i] = testingCID(t, i)
+	}
+
+	sub := r.Subscribe(ctx)
+
+	r.Load(ctx, cids)
+
+	// the fetches don't wait for each other
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&running) == concurrency
+	}, time.Second*5, time.Millisecond*10)
+
+	// and are bounded by the concurrency
+	time.Sleep(time.Millisecond * 100)
+	require.Equal(t, int64(concurrency), atomic.LoadInt64(&running))
+	require.Equal(t, int64(concurrency), atomic.LoadInt64(&maxRunning))
+
+	close(release)
+
+	// the workers process the whole queue
+	fetched := 0
+	for fetched < len(cids) {
+		select {
+		case evt := <-sub:
+			if e, ok := evt.(*EventLoadEnd); ok {
+				fetched += len(e.Logs)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("timed out waiting for the fetched logs, %d of %d received", fetched, len(cids))
+		}
+	}
+
+	require.Equal(t, len(cids), fetched)
+	require.Equal(t, int64(len(cids)), atomic.LoadInt64(&calls))
+	require.Equal(t, int64(concurrency), atomic.LoadInt64(&maxRunning))
+}
+
+func TestReplicatorPriority(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a single worker processes the queue in order
+	r := testingReplicator(ctx, t, 1, nil)
+
+	first := testingCID(t, 0)
+	release := make(chan struct{})
+
+	var lock sync.Mutex
+	var order []cid.Cid
+
+	r.fetch = func(ctx context.Context, h cid.Cid, batchSize int) (ipfslog.Log, error) {
+		if h.Equals(first) {
+			<-release
+		}
+
+		lock.Lock()
+		order = append(order, h)
+		lock.Unlock()
+
+		return &testingLog{entries: []ipfslog.Entry{testingEntry(h, 1)}}, nil
+	}
+
+	r.Load(ctx, []cid.Cid{first})
+
+	require.Eventually(t, func() bool {
+		r.lock.RLock()
+		defer r.lock.RUnlock()
+
+		return len(r.fetching) == 1
+	}, time.Second*5, time.Millisecond*10)
+
+	// queued while the worker is busy
+	priorities := []int{3, 10, 1, 7}
+	items := make([]*queuedHash, len(priorities))
+	for i, p := range priorities {
+		items[i] = &queuedHash{hash: testingCID(t, p), priority: p}
+	}
+
+	r.load(ctx, items)
+	close(release)
+
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return len(order) == len(priorities)+1
+	}, time.Second*5, time.Millisecond*10)
+
+	require.Equal(t, []cid.Cid{first, testingCID(t, 10), testingCID(t, 7), testingCID(t, 3), testingCID(t, 1)}, order)
+}
+
+func TestReplicatorMaxDepth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const (
+		length   = 10
+		maxDepth = 3
+	)
+
+	// a chain of entries, each one pointing to the next one
+	chain := make([]cid.Cid, length)
+	for i := range chain {
+		chain[i] = testingCID(t, i)
+	}
+
+	entries := map[string]ipfslog.Entry{}
+	for i, h := range chain {
+		var next []cid.Cid
+		if i+1 < length {
+			next = []cid.Cid{chain[i+1]}
+		}
+
+		entries[h.String()] = testingEntry(h, length-i, next...)
+	}
+
+	r := testingReplicator(ctx, t, 2, &Options{MaxDepth: maxDepth})
+
+	var calls int64
+
+	r.fetch = func(ctx context.Context, h cid.Cid, batchSize int) (ipfslog.Log, error) {
+		atomic.AddInt64(&calls, 1)
+
+		return &testingLog{entries: []ipfslog.Entry{entries[h.String()]}}, nil
+	}
+
+	sub := r.Subscribe(ctx)
+
+	r.Load(ctx, chain[:1])
+
+	var fetched []cid.Cid
+	for len(fetched) < maxDepth+1 {
+		select {
+		case evt := <-sub:
+			if e, ok := evt.(*EventLoadEnd); ok {
+				for _, l := range e.Logs {
+					fetched = append(fetched, l.Values().At(0).GetHash())
+				}
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("timed out waiting for the fetched logs, %d received", len(fetched))
+		}
+	}
+
+	// the entries beyond the max depth are not fetched
+	time.Sleep(time.Millisecond * 100)
+	require.ElementsMatch(t, chain[:maxDepth+1], fetched)
+	require.Equal(t, int64(maxDepth+1), atomic.LoadInt64(&calls))
+	require.Empty(t, r.GetQueue())
+}
+
+func TestReplicatorAdaptiveBatchSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := testingReplicator(ctx, t, 2, &Options{
+		BatchSize:         2,
+		MaxBatchSize:      16,
+		AdaptiveBatchSize: true,
+	})
+
+	for _, tc := range []struct {
+		name      string
+		batchSize int
+		depth     int
+		maxDepth  int
+		expected  int
+	}{
+		{name: "first batch", batchSize: 0, expected: 2},
+		{name: "grown batch", batchSize: 8, expected: 8},
+		{name: "above the max batch size", batchSize: 40, expected: 16},
+		{name: "bounded by the max depth", batchSize: 8, depth: 3, maxDepth: 5, expected: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r.lock.Lock()
+			defer r.lock.Unlock()
+
+			r.maxDepth = tc.maxDepth
+
+			require.Equal(t, tc.expected, r.fetchBatchSize(&queuedHash{depth: tc.depth, batchSize: tc.batchSize}))
+		})
+	}
+
+	// without adaptive mode the batch size doesn't change
+	r.lock.Lock()
+	r.adaptiveBatchSize = false
+	r.maxDepth = 0
+	require.Equal(t, 2, r.fetchBatchSize(&queuedHash{batchSize: 8}))
+	r.lock.Unlock()
+}
+
+func TestReplicatorAdaptiveBatchSizeLinearHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const (
+		length       = 1000
+		batchSize    = 2
+		maxBatchSize = 64
+	)
+
+	// a linear history, each entry pointing to the previous one
+	chain := make([]ipfslog.Entry, length)
+	index := map[string]int{}
+	for i := length - 1; i >= 0; i-- {
+		h := testingCID(t, i)
+		index[h.String()] = i
+
+		if i+1 < length {
+			chain[i] = testingEntry(h, length-i, chain[i+1].GetHash())
+		} else {
+			chain[i] = testingEntry(h, length-i)
+		}
+	}
+
+	// the default concurrency, a single hash is queued at a time
+	r := testingReplicator(ctx, t, 0, &Options{
+		BatchSize:         batchSize,
+		MaxBatchSize:      maxBatchSize,
+		AdaptiveBatchSize: true,
+	})
+
+	var calls int64
+
+	r.fetch = func(ctx context.Context, h cid.Cid, size int) (ipfslog.Log, error) {
+		atomic.AddInt64(&calls, 1)
+
+		start := index[h.String()]
+		end := start + size
+		if end > length {
+			end = length
+		}
+
+		return &testingLog{entries: chain[start:end]}, nil
+	}
+
+	sub := r.Subscribe(ctx)
+
+	r.Load(ctx, []cid.Cid{chain[0].GetHash()})
+
+	fetched := 0
+	for fetched < length {
+		select {
+		case evt := <-sub:
+			if e, ok := evt.(*EventLoadEnd); ok {
+				for _, l := range e.Logs {
+					fetched += l.Len()
+				}
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("timed out waiting for the fetched logs, %d of %d received", fetched, length)
+		}
+	}
+
+	require.Equal(t, length, fetched)
+
+	// 2, 4, ..., 32 then batches of 64 entries
+	require.Less(t, atomic.LoadInt64(&calls), int64(length/batchSize))
+	require.LessOrEqual(t, atomic.LoadInt64(&calls), int64(20))
+}
+
+func TestReplicatorRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := testingReplicator(ctx, t, 1, &Options{
+		MaxRetries:      2,
+		RetryBackoff:    time.Millisecond * 20,
+		MaxRetryBackoff: time.Millisecond * 30,
+	})
+
+	var calls int64
+	var available int32
+
+	r.fetch = func(ctx context.Context, h cid.Cid, batchSize int) (ipfslog.Log, error) {
+		atomic.AddInt64(&calls, 1)
+
+		if atomic.LoadInt32(&available) == 0 {
+			return nil, errors.New("not available")
+		}
+
+		return &testingLog{entries: []ipfslog.Entry{testingEntry(h, 1)}}, nil
+	}
+
+	sub := r.Subscribe(ctx)
+	c := testingCID(t, 1)
+	started := time.Now()
+
+	r.Load(ctx, []cid.Cid{c})
+
+	var retries []*EventFetchRetry
+	var failed *EventFetchFailed
+
+	for failed == nil {
+		select {
+		case evt := <-sub:
+			switch e := evt.(type) {
+			case *EventFetchRetry:
+				retries = append(retries, e)
+			case *EventFetchFailed:
+				failed = e
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for the fetch to fail")
+		}
+	}
+
+	// the backoff is doubled and capped
+	require.Len(t, retries, 2)
+	require.Equal(t, 1, retries[0].Attempt)
+	require.Equal(t, time.Millisecond*20, retries[0].Delay)
+	require.Equal(t, 2, retries[1].Attempt)
+	require.Equal(t, time.Millisecond*30, retries[1].Delay)
+	require.True(t, time.Since(started) >= time.Millisecond*50)
+
+	require.True(t, failed.Hash.Equals(c))
+	require.Equal(t, 3, failed.Attempts)
+	require.Equal(t, int64(3), atomic.LoadInt64(&calls))
+
+	require.Len(t, r.GetFailed(), 1)
+	require.True(t, r.GetFailed()[0].Hash.Equals(c))
+	require.Empty(t, r.GetPending())
+
+	// retrying the failed hashes clears them once fetched
+	atomic.StoreInt32(&available, 1)
+	r.RetryFailed(ctx)
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&calls) == 4
+	}, time.Second*5, time.Millisecond*10)
+	require.Empty(t, r.GetFailed())
+}
+
+func TestReplicatorRetryMissingEntry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := testingReplicator(ctx, t, 1, &Options{
+		MaxRetries: -1,
+		Filter:     func(ipfslog.Entry) FilterDecision { return FilterKeep },
+	})
+
+	var calls int64
+
+	r.fetch = func(ctx context.Context, h cid.Cid, batchSize int) (ipfslog.Log, error) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			// a log without the requested entry
+			return &testingLog{entries: []ipfslog.Entry{testingEntry(testingCID(t, 2), 1)}}, nil
+		}
+
+		return &testingLog{entries: []ipfslog.Entry{testingEntry(h, 1)}}, nil
+	}
+
+	c := testingCID(t, 1)
+	r.Load(ctx, []cid.Cid{c})
+
+	require.Eventually(t, func() bool {
+		return len(r.GetFailed()) == 1
+	}, time.Second*5, time.Millisecond*10)
+	require.Empty(t, r.GetPending())
+
+	// the hash is not considered as being fetched anymore
+	r.RetryFailed(ctx)
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&calls) == 2
+	}, time.Second*5, time.Millisecond*10)
+}
@@ -7,7 +7,9 @@ import (
 	"path"
 	"strings"
 	"testing"
+	"time"
 
+	"berty.tech/go-ipfs-log/enc"
 	"berty.tech/go-ipfs-log/identityprovider"
 	"berty.tech/go-ipfs-log/io"
 	"berty.tech/go-ipfs-log/keystore"
@@ -15,10 +17,12 @@ import (
 	"berty.tech/go-orbit-db/accesscontroller"
 	"berty.tech/go-orbit-db/address"
 	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/keyring"
 	"berty.tech/go-orbit-db/stores/operation"
 	"berty.tech/go-orbit-db/utils"
 	datastore "github.com/ipfs/go-datastore"
 	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/polydawn/refmt/cbor"
 	"github.com/polydawn/refmt/obj/atlas"
 	"github.com/stretchr/testify/require"
@@ -406,6 +410,11 @@ func TestCreateOpen(t *testing.T) {
 			require.NoError(t, err)
 			require.NotNil(t, identity)
 
+			// an open store is only shared with holders using the same identity
+			_, err = orbit.Open(ctx, "abc", &orbitdb.CreateDBOptions{Create: &create, StoreType: &storeType, Overwrite: &overwrite, Identity: identity})
+			require.Error(t, err)
+			require.NoError(t, db.Close())
+
 			db, err = orbit.Open(ctx, "abc", &orbitdb.CreateDBOptions{Create: &create, StoreType: &storeType, Overwrite: &overwrite, Identity: identity})
 			require.NoError(t, err)
 
@@ -496,4 +505,283 @@ func TestCreateOpen(t *testing.T) {
 			require.Equal(t, string(res2.GetValue()), "hello2")
 		})
 	})
+
+	t.Run("ListLocalDatabases", func(t *testing.T) {
+		defer setup(t)()
+
+		databases, err := orbit.ListLocalDatabases(ctx)
+		require.NoError(t, err)
+		require.Empty(t, databases)
+
+		logStore, err := orbit.Create(ctx, "first", "eventlog", nil)
+		require.NoError(t, err)
+
+		kvStore, err := orbit.Create(ctx, "second", "keyvalue", nil)
+		require.NoError(t, err)
+
+		require.NoError(t, logStore.Close())
+		require.NoError(t, kvStore.Close())
+
+		databases, err = orbit.ListLocalDatabases(ctx)
+		require.NoError(t, err)
+		require.Len(t, databases, 2)
+
+		found := map[string]*orbitdb.LocalDatabase{}
+		for _, db := range databases {
+			found[db.Address.String()] = db
+		}
+
+		require.Contains(t, found, logStore.Address().String())
+		require.Equal(t, "first", found[logStore.Address().String()].Name)
+		require.Equal(t, "eventlog", found[logStore.Address().String()].Type)
+
+		require.Contains(t, found, kvStore.Address().String())
+		require.Equal(t, "second", found[kvStore.Address().String()].Name)
+		require.Equal(t, "keyvalue", found[kvStore.Address().String()].Type)
+	})
+
+	t.Run("shares the stores open at the same address", func(t *testing.T) {
+		defer setup(t)()
+
+		db1, err := orbit.Log(ctx, "shared", nil)
+		require.NoError(t, err)
+
+		db2, err := orbit.Log(ctx, db1.Address().String(), nil)
+		require.NoError(t, err)
+		require.Same(t, db1, db2)
+
+		stores := orbit.Stores()
+		require.Len(t, stores, 1)
+		require.Contains(t, stores, db1.Address().String())
+
+		// the store stays open while it has holders
+		require.NoError(t, db1.Close())
+
+		store, ok := orbit.GetStore(db1.Address().String())
+		require.True(t, ok)
+		require.Same(t, db2, store)
+
+		_, err = db2.Add(ctx, []byte("hello"))
+		require.NoError(t, err)
+
+		require.NoError(t, db2.Close())
+
+		_, ok = orbit.GetStore(db1.Address().String())
+		require.False(t, ok)
+		require.Empty(t, orbit.Stores())
+
+		// a closed store is opened again as a new instance
+		db3, err := orbit.Log(ctx, db1.Address().String(), nil)
+		require.NoError(t, err)
+		require.NotSame(t, db1, db3)
+		require.NoError(t, db3.Close())
+	})
+
+	t.Run("opens a followed store with its type once it is closed", func(t *testing.T) {
+		defer setup(t)()
+
+		db, err := orbit.Log(ctx, "followed", nil)
+		require.NoError(t, err)
+
+		addr := db.Address().String()
+		require.NoError(t, db.Close())
+
+		headless, err := orbit.Follow(ctx, addr, nil)
+		require.NoError(t, err)
+		require.Equal(t, "headless", headless.Type())
+
+		// the headless store can't be used as an event log
+		_, err = orbit.Log(ctx, addr, nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "headless")
+
+		require.NoError(t, headless.Close())
+
+		typed, err := orbit.Log(ctx, addr, nil)
+		require.NoError(t, err)
+		require.Equal(t, "eventlog", typed.Type())
+
+		// while a typed store replicates for its followers
+		followed, err := orbit.Follow(ctx, addr, nil)
+		require.NoError(t, err)
+		require.Same(t, typed, followed)
+
+		require.NoError(t, followed.Close())
+		require.NoError(t, typed.Close())
+	})
+
+	t.Run("refuses to share a store opened with conflicting options", func(t *testing.T) {
+		defer setup(t)()
+
+		db, err := orbit.Log(ctx, "conflicting", &orbitdb.CreateDBOptions{
+			ReplicationConcurrency: 4,
+		})
+		require.NoError(t, err)
+
+		defer db.Close()
+
+		addr := db.Address().String()
+
+		_, err = orbit.Log(ctx, addr, &orbitdb.CreateDBOptions{ReplicationConcurrency: 2})
+		require.Error(t, err)
+
+		sharedKey, err := enc.NewSecretbox(make([]byte, 32))
+		require.NoError(t, err)
+
+		_, err = orbit.Log(ctx, addr, &orbitdb.CreateDBOptions{SharedKey: sharedKey})
+		require.Error(t, err)
+
+		_, err = orbit.KeyValue(ctx, addr, nil)
+		require.Error(t, err)
+
+		// the options left unset don't conflict
+		same, err := orbit.Log(ctx, addr, &orbitdb.CreateDBOptions{ReplicationConcurrency: 4})
+		require.NoError(t, err)
+		require.Same(t, db, same)
+		require.NoError(t, same.Close())
+	})
+
+	t.Run("refuses to share a store opened with another topic or other peer restrictions", func(t *testing.T) {
+		defer setup(t)()
+
+		sharedKey, err := enc.NewSecretbox(make([]byte, 32))
+		require.NoError(t, err)
+
+		alice, bob := peer.ID("alice"), peer.ID("bob")
+
+		db, err := orbit.Log(ctx, "conflicting-restrictions", &orbitdb.CreateDBOptions{
+			SharedKey:   sharedKey,
+			DeniedPeers: []peer.ID{alice, bob},
+		})
+		require.NoError(t, err)
+
+		defer db.Close()
+
+		addr := db.Address().String()
+
+		// the store is open on its public topic
+		_, err = orbit.Log(ctx, addr, &orbitdb.CreateDBOptions{SharedKey: sharedKey, PrivateTopic: true})
+		require.Error(t, err)
+
+		_, err = orbit.Log(ctx, addr, &orbitdb.CreateDBOptions{DeniedPeers: []peer.ID{alice}})
+		require.Error(t, err)
+
+		_, err = orbit.Log(ctx, addr, &orbitdb.CreateDBOptions{AllowedPeers: []peer.ID{alice}})
+		require.Error(t, err)
+
+		_, err = orbit.Log(ctx, addr, &orbitdb.CreateDBOptions{MaxIncomingHeads: 10})
+		require.Error(t, err)
+
+		// the same peers are accepted in any order
+		same, err := orbit.Log(ctx, addr, &orbitdb.CreateDBOptions{DeniedPeers: []peer.ID{bob, alice}})
+		require.NoError(t, err)
+		require.Same(t, db, same)
+		require.NoError(t, same.Close())
+
+		private, err := orbit.Log(ctx, "conflicting-private-topic", &orbitdb.CreateDBOptions{
+			SharedKey:    sharedKey,
+			PrivateTopic: true,
+		})
+		require.NoError(t, err)
+
+		defer private.Close()
+
+		otherKey, err := keyring.GenerateRecipientKey()
+		require.NoError(t, err)
+
+		_, err = orbit.Log(ctx, private.Address().String(), &orbitdb.CreateDBOptions{RecipientKey: otherKey})
+		require.Error(t, err)
+
+		same, err = orbit.Log(ctx, private.Address().String(), &orbitdb.CreateDBOptions{SharedKey: sharedKey, PrivateTopic: true})
+		require.NoError(t, err)
+		require.Same(t, private, same)
+		require.NoError(t, same.Close())
+	})
+
+	t.Run("suspends the idle stores", func(t *testing.T) {
+		idlePath, idlePathClean := testingTempDir(t, "db")
+		defer idlePathClean()
+
+		idleOrbit, err := orbitdb.NewOrbitDB(ctx, ipfs, &orbitdb.NewOrbitDBOptions{Directory: &idlePath, StoreIdleTimeout: time.Millisecond * 200})
+		require.NoError(t, err)
+
+		defer idleOrbit.Close()
+
+		db, err := idleOrbit.Log(ctx, "idle", nil)
+		require.NoError(t, err)
+
+		_, err = db.Add(ctx, []byte("hello"))
+		require.NoError(t, err)
+
+		suspendable, ok := db.(iface.StoreSuspendInterface)
+		require.True(t, ok)
+
+		// the store is suspended while it is still held
+		require.Eventually(t, suspendable.Suspended, time.Second*5, time.Millisecond*50)
+
+		_, ok = idleOrbit.GetStore(db.Address().String())
+		require.True(t, ok)
+
+		// and resumed by its next write
+		_, err = db.Add(ctx, []byte("world"))
+		require.NoError(t, err)
+		require.Equal(t, 2, db.OpLog().Len())
+
+		// the last holder closes the store immediately
+		require.NoError(t, db.Close())
+
+		_, ok = idleOrbit.GetStore(db.Address().String())
+		require.False(t, ok)
+	})
+
+	t.Run("suspends the least recently used stores beyond the maximum", func(t *testing.T) {
+		cappedPath, cappedPathClean := testingTempDir(t, "db")
+		defer cappedPathClean()
+
+		cappedOrbit, err := orbitdb.NewOrbitDB(ctx, ipfs, &orbitdb.NewOrbitDBOptions{Directory: &cappedPath, MaxOpenStores: 1})
+		require.NoError(t, err)
+
+		defer cappedOrbit.Close()
+
+		db1, err := cappedOrbit.Log(ctx, "first", nil)
+		require.NoError(t, err)
+
+		_, err = db1.Add(ctx, []byte("hello"))
+		require.NoError(t, err)
+
+		db2, err := cappedOrbit.Log(ctx, "second", nil)
+		require.NoError(t, err)
+
+		suspendable1 := db1.(iface.StoreSuspendInterface)
+		suspendable2 := db2.(iface.StoreSuspendInterface)
+
+		// the held stores are evicted as well, they stay registered
+		require.Eventually(t, suspendable1.Suspended, time.Second*5, time.Millisecond*50)
+		require.False(t, suspendable2.Suspended())
+		require.Len(t, cappedOrbit.Stores(), 2)
+
+		// using the evicted store reopens it and evicts the other one
+		_, err = db1.Add(ctx, []byte("world"))
+		require.NoError(t, err)
+
+		require.Eventually(t, suspendable2.Suspended, time.Second*5, time.Millisecond*50)
+		require.False(t, suspendable1.Suspended())
+
+		// a suspended store is closed immediately by its last holder
+		require.NoError(t, db2.Close())
+
+		_, ok := cappedOrbit.GetStore(db2.Address().String())
+		require.False(t, ok)
+
+		require.NoError(t, db1.Close())
+
+		// the entries written around the eviction have been persisted
+		db3, err := cappedOrbit.Log(ctx, db1.Address().String(), nil)
+		require.NoError(t, err)
+		require.NotSame(t, db1, db3)
+
+		require.NoError(t, db3.Load(ctx, -1))
+		require.Equal(t, 2, db3.OpLog().Len())
+		require.NoError(t, db3.Close())
+	})
 }
@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	orbitdb "berty.tech/go-orbit-db"
 	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores"
 	"berty.tech/go-orbit-db/stores/operation"
 	cid "github.com/ipfs/go-cid"
 	"github.com/stretchr/testify/require"
@@ -125,6 +127,53 @@ func TestLogDatabase(t *testing.T) {
 		}
 	})
 
+	t.Run("adds a batch of items", func(t *testing.T) {
+		defer setup(t)()
+
+		db, err := orbitdb1.Log(ctx, "batch database", nil)
+		require.NoError(t, err)
+
+		defer db.Close()
+
+		sub := db.Subscribe(ctx)
+
+		values := make([][]byte, 10)
+		for i := range values {
+			values[i] = []byte(fmt.Sprintf("hello%d", i))
+		}
+
+		ops, err := db.AddBatch(ctx, values)
+		require.NoError(t, err)
+		require.Equal(t, len(values), len(ops))
+
+		items, err := db.List(ctx, &orbitdb.StreamOptions{Amount: &infinity})
+		require.NoError(t, err)
+		require.Equal(t, len(values), len(items))
+
+		for i := range values {
+			require.Equal(t, string(values[i]), string(items[i].GetValue()))
+			require.Equal(t, ops[i].GetEntry().GetHash().String(), items[i].GetEntry().GetHash().String())
+		}
+
+		// a single write event is emitted for the whole batch
+		writes := 0
+		timeout := time.After(time.Second)
+
+	loop:
+		for {
+			select {
+			case evt := <-sub:
+				if _, ok := evt.(*stores.EventWrite); ok {
+					writes++
+				}
+			case <-timeout:
+				break loop
+			}
+		}
+
+		require.Equal(t, 1, writes)
+	})
+
 	t.Run("adds an item that is > 256 bytes", func(t *testing.T) {
 		defer setup(t)()
 		db, err := orbitdb1.Log(ctx, "third database", nil)
@@ -13,6 +13,8 @@ import (
 	"berty.tech/go-orbit-db/stores"
 	"berty.tech/go-orbit-db/stores/basestore"
 	"berty.tech/go-orbit-db/stores/operation"
+	"berty.tech/go-orbit-db/stores/replicator"
+	cid "github.com/ipfs/go-cid"
 	"github.com/stretchr/testify/require"
 )
 
@@ -158,6 +160,88 @@ func TestPersistence(t *testing.T) {
 		}
 	})
 
+	t.Run("resumes the replication queue when reopened", func(t *testing.T) {
+		defer setup(t)()
+
+		db, err := orbitdb1.Log(ctx, fmt.Sprintf("replication-queue-%d", time.Now().UnixNano()), nil)
+		require.NoError(t, err)
+
+		dbAddress := db.Address()
+
+		// a hash which can't be fetched stays pending
+		missing, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: 0x12 /* sha2-256 */}.Sum([]byte("missing entry"))
+		require.NoError(t, err)
+
+		isPending := func(db orbitdb.EventLogStore) func() bool {
+			return func() bool {
+				for _, c := range db.Replicator().GetPending() {
+					if c.Equals(missing) {
+						return true
+					}
+				}
+
+				return false
+			}
+		}
+
+		go db.Replicator().Load(ctx, []cid.Cid{missing})
+		require.Eventually(t, isPending(db), time.Second*5, time.Millisecond*50)
+
+		require.NoError(t, db.Close())
+
+		db, err = orbitdb1.Log(ctx, dbAddress.String(), nil)
+		require.NoError(t, err)
+
+		defer db.Close()
+
+		// the replication is resumed without loading the store
+		require.Eventually(t, isPending(db), time.Second*5, time.Millisecond*50)
+	})
+
+	t.Run("keeps the filtered entries out when reopened", func(t *testing.T) {
+		defer setup(t)()
+
+		db, err := orbitdb1.Log(ctx, fmt.Sprintf("filtered-%d", time.Now().UnixNano()), nil)
+		require.NoError(t, err)
+
+		dbAddress := db.Address()
+
+		for i := 0; i < 10; i++ {
+			_, err := db.Add(ctx, []byte(fmt.Sprintf("hello%d", i)))
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, db.Close())
+
+		// hello0 to hello2 and their history are stopped, hello5 is skipped
+		filter := replicator.CombineFilters(
+			replicator.FilterByClock(4, 0),
+			replicator.FilterByOperation(func(op operation.Operation) bool {
+				return string(op.GetValue()) != "hello5"
+			}),
+		)
+
+		expected := []string{"hello3", "hello4", "hello6", "hello7", "hello8", "hello9"}
+
+		for i := 0; i < 2; i++ {
+			db, err = orbitdb1.Log(ctx, dbAddress.String(), &orbitdb.CreateDBOptions{ReplicationFilter: filter})
+			require.NoError(t, err)
+
+			require.NoError(t, db.Load(ctx, infinity))
+
+			items, err := db.List(ctx, &orbitdb.StreamOptions{Amount: &infinity})
+			require.NoError(t, err)
+
+			values := make([]string, len(items))
+			for j, item := range items {
+				values[j] = string(item.GetValue())
+			}
+
+			require.Equal(t, expected, values)
+			require.NoError(t, db.Close())
+		}
+	})
+
 	t.Run("loading a database emits 'ready' event", func(t *testing.T) {
 		defer setup(t)()
 		db, err := orbitdb1.Log(ctx, address.String(), nil)
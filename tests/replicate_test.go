@@ -3,6 +3,7 @@ package tests
 import (
 	"berty.tech/go-ipfs-log/enc"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"sync"
@@ -10,13 +11,21 @@ import (
 	"time"
 
 	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/entry"
 	orbitdb "berty.tech/go-orbit-db"
 	"berty.tech/go-orbit-db/accesscontroller"
 	"berty.tech/go-orbit-db/events"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/keyring"
 	"berty.tech/go-orbit-db/pubsub/directchannel"
+	"berty.tech/go-orbit-db/pubsub/inmem"
 	"berty.tech/go-orbit-db/pubsub/pubsubraw"
 	orbitstores "berty.tech/go-orbit-db/stores"
 	"berty.tech/go-orbit-db/stores/operation"
+	"berty.tech/go-orbit-db/stores/replicator"
+	cid "github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	p2ppubsub "github.com/libp2p/go-libp2p-pubsub"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -153,6 +162,53 @@ func testRawPubSubNodeGenerator(t *testing.T, mn mocknet.Mocknet, i int) (orbitd
 	return orbitdb1, dbPath1, performCloseOps
 }
 
+func testInmemNode(t *testing.T, mn mocknet.Mocknet, network *inmem.Network, i int) (orbitdb.OrbitDB, peer.ID, string, func()) {
+	return testInmemNodeWithOptions(t, mn, network, i, &orbitdb.NewOrbitDBOptions{})
+}
+
+func testInmemNodeWithOptions(t *testing.T, mn mocknet.Mocknet, network *inmem.Network, i int, options *orbitdb.NewOrbitDBOptions) (orbitdb.OrbitDB, peer.ID, string, func()) {
+	var closeOps []func()
+
+	performCloseOps := func() {
+		for i := len(closeOps) - 1; i >= 0; i-- {
+			closeOps[i]()
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*70)
+	closeOps = append(closeOps, cancel)
+
+	dbPath1, clean := testingTempDir(t, fmt.Sprintf("db%d", i))
+	closeOps = append(closeOps, clean)
+
+	node1, clean := testingIPFSNode(ctx, t, mn)
+	closeOps = append(closeOps, clean)
+
+	ipfs1 := testingCoreAPI(t, node1)
+	zap.L().Named("orbitdb.tests").Debug(fmt.Sprintf("node%d is %s", i, node1.Identity.String()))
+
+	// the blocks are still exchanged through IPFS, the messages go through
+	// the in-memory network
+	nodeOptions := *options
+	nodeOptions.Directory = &dbPath1
+	nodeOptions.PubSub = network.PubSub(node1.Identity)
+	nodeOptions.DirectChannelFactory = network.DirectChannelFactory(node1.Identity)
+
+	orbitdb1, err := orbitdb.NewOrbitDB(ctx, ipfs1, &nodeOptions)
+	require.NoError(t, err)
+
+	closeOps = append(closeOps, func() { _ = orbitdb1.Close() })
+
+	return orbitdb1, node1.Identity, dbPath1, performCloseOps
+}
+
+func testInmemNodeGenerator(network *inmem.Network) func(t *testing.T, mn mocknet.Mocknet, i int) (orbitdb.OrbitDB, string, func()) {
+	return func(t *testing.T, mn mocknet.Mocknet, i int) (orbitdb.OrbitDB, string, func()) {
+		db, _, dbPath, clean := testInmemNode(t, mn, network, i)
+		return db, dbPath, clean
+	}
+}
+
 func testDefaultNodeGenerator(t *testing.T, mn mocknet.Mocknet, i int) (orbitdb.OrbitDB, string, func()) {
 	var closeOps []func()
 
@@ -317,6 +373,9 @@ func TestReplication(t *testing.T) {
 		)
 	}
 
+	network := inmem.NewNetwork()
+	defer network.Close()
+
 	for _, amount := range []int{
 		1,
 		10,
@@ -326,6 +385,7 @@ func TestReplication(t *testing.T) {
 			"default":        testDefaultNodeGenerator,
 			"direct-channel": testDirectChannelNodeGenerator,
 			"raw-pubsub":     testRawPubSubNodeGenerator,
+			"inmem":          testInmemNodeGenerator(network),
 		} {
 			t.Run(fmt.Sprintf("replicates database of %d entries with node type %s", amount, nodeType), func(t *testing.T) {
 				testLogAppendReplicate(t, amount, nodeGen)
@@ -334,6 +394,376 @@ func TestReplication(t *testing.T) {
 	}
 }
 
+func TestReplicationStoreTopicLeave(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	network := inmem.NewNetwork()
+	defer network.Close()
+
+	mn := testingMockNet(ctx)
+
+	dbs := make([]orbitdb.OrbitDB, 2)
+	ids := make([]peer.ID, 2)
+	dbPaths := make([]string, 2)
+
+	for i := 0; i < 2; i++ {
+		var clean func()
+		dbs[i], ids[i], dbPaths[i], clean = testInmemNode(t, mn, network, i)
+		defer clean()
+	}
+
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	access := &accesscontroller.CreateAccessControllerOptions{
+		Access: map[string][]string{
+			"write": {
+				dbs[0].Identity().ID,
+				dbs[1].Identity().ID,
+			},
+		},
+	}
+
+	store0, err := dbs[0].Log(ctx, "replication-tests", &orbitdb.CreateDBOptions{
+		Directory:        &dbPaths[0],
+		AccessController: access,
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = store0.Close() }()
+
+	store1, err := dbs[1].Log(ctx, store0.Address().String(), &orbitdb.CreateDBOptions{
+		Directory:        &dbPaths[1],
+		AccessController: access,
+	})
+	require.NoError(t, err)
+
+	// the subscription of the first peer to the store topic
+	topic, err := network.PubSub(ids[0]).TopicSubscribe(ctx, store0.Address().String())
+	require.NoError(t, err)
+
+	peers, err := topic.WatchPeers(ctx)
+	require.NoError(t, err)
+
+	evt := <-peers
+	require.Equal(t, ids[1], evt.(*iface.EventPubSubJoin).Peer)
+
+	require.NoError(t, store1.Close())
+
+	select {
+	case evt = <-peers:
+		require.Equal(t, ids[1], evt.(*iface.EventPubSubLeave).Peer)
+	case <-ctx.Done():
+		t.Fatal("the peer didn't leave the store topic")
+	}
+}
+
+func TestReplicationPublishHeadsDebounce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	network := inmem.NewNetwork()
+	defer network.Close()
+
+	mn := testingMockNet(ctx)
+
+	db, _, dbPath, clean := testInmemNodeWithOptions(t, mn, network, 0, &orbitdb.NewOrbitDBOptions{
+		PublishHeadsDebounce: time.Millisecond * 500,
+	})
+	defer clean()
+
+	store, err := db.Log(ctx, "debounce-tests", &orbitdb.CreateDBOptions{
+		Directory: &dbPath,
+	})
+	require.NoError(t, err)
+
+	// a peer watching the announcements on the store topic
+	topic, err := network.PubSub(peer.ID("observer")).TopicSubscribe(ctx, store.Address().String())
+	require.NoError(t, err)
+
+	messages, err := topic.WatchMessages(ctx)
+	require.NoError(t, err)
+
+	receiveHeads := func() []*entry.Entry {
+		select {
+		case msg := <-messages:
+			var heads []*entry.Entry
+			require.NoError(t, json.Unmarshal(msg.Content, &heads))
+
+			return heads
+
+		case <-time.After(time.Second * 5):
+			t.Fatal("no heads were announced")
+		}
+
+		return nil
+	}
+
+	var last operation.Operation
+	for i := 0; i < 5; i++ {
+		last, err = store.Add(ctx, []byte(fmt.Sprintf("hello%d", i)))
+		require.NoError(t, err)
+	}
+
+	// the writes of the window are announced once, with the latest heads
+	heads := receiveHeads()
+	require.Len(t, heads, 1)
+	require.Equal(t, last.GetEntry().GetHash().String(), heads[0].Hash.String())
+
+	select {
+	case <-messages:
+		t.Fatal("the writes were announced more than once")
+	case <-time.After(time.Second):
+	}
+
+	// a pending announcement is published when the store is closed
+	last, err = store.Add(ctx, []byte("closing"))
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	heads = receiveHeads()
+	require.Len(t, heads, 1)
+	require.Equal(t, last.GetEntry().GetHash().String(), heads[0].Hash.String())
+}
+
+func TestReplicationReopenedStoreValidator(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	network := inmem.NewNetwork()
+	defer network.Close()
+
+	mn := testingMockNet(ctx)
+
+	db, id, dbPath, clean := testInmemNode(t, mn, network, 0)
+	defer clean()
+
+	store, err := db.Log(ctx, "validator-tests", &orbitdb.CreateDBOptions{
+		Directory: &dbPath,
+	})
+	require.NoError(t, err)
+
+	addr := store.Address().String()
+
+	// the new instance registers its validator before the closed one has
+	// removed its own
+	require.NoError(t, store.Close())
+
+	store, err = db.Log(ctx, addr, &orbitdb.CreateDBOptions{
+		Directory: &dbPath,
+	})
+	require.NoError(t, err)
+
+	intruder, err := network.PubSub(peer.ID("intruder")).TopicSubscribe(ctx, addr)
+	require.NoError(t, err)
+
+	topic, err := network.PubSub(id).TopicSubscribe(ctx, addr)
+	require.NoError(t, err)
+
+	msgs, err := topic.WatchMessages(ctx)
+	require.NoError(t, err)
+
+	// leaves the closed instance time to remove its validator
+	<-time.After(time.Millisecond * 200)
+
+	require.NoError(t, intruder.Publish(ctx, []byte("not an announcement")))
+
+	select {
+	case msg := <-msgs:
+		t.Fatalf("an invalid message has been delivered: %v", msg)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	// the validator is removed once the last instance is closed
+	require.NoError(t, store.Close())
+
+	require.Eventually(t, func() bool {
+		topic, err := network.PubSub(id).TopicSubscribe(ctx, addr)
+		if err != nil {
+			return false
+		}
+
+		msgs, err := topic.WatchMessages(ctx)
+		if err != nil {
+			return false
+		}
+
+		if err := intruder.Publish(ctx, []byte("not an announcement")); err != nil {
+			return false
+		}
+
+		select {
+		case msg, ok := <-msgs:
+			return ok && msg != nil
+		case <-time.After(time.Millisecond * 100):
+			return false
+		}
+	}, time.Second*5, time.Millisecond*50)
+}
+
+func TestReplicationValidatorDeniedPeer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	mn := testingMockNet(ctx)
+
+	node, clean := testingIPFSNode(ctx, t, mn)
+	defer clean()
+
+	denied, clean := testingIPFSNode(ctx, t, mn)
+	defer clean()
+
+	allowed, clean := testingIPFSNode(ctx, t, mn)
+	defer clean()
+
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	dbPath, clean := testingTempDir(t, "db")
+	defer clean()
+
+	ps := pubsubraw.NewPubSub(node.PubSub, node.Identity, nil, nil)
+
+	db, err := orbitdb.NewOrbitDB(ctx, testingCoreAPI(t, node), &orbitdb.NewOrbitDBOptions{
+		Directory: &dbPath,
+		PubSub:    ps,
+	})
+	require.NoError(t, err)
+
+	defer db.Close()
+
+	store, err := db.Log(ctx, "validator-denied-tests", &orbitdb.CreateDBOptions{
+		Directory:   &dbPath,
+		DeniedPeers: []peer.ID{denied.Identity},
+	})
+	require.NoError(t, err)
+
+	defer store.Close()
+
+	addr := store.Address().String()
+
+	op, err := store.Add(ctx, []byte("hello"))
+	require.NoError(t, err)
+
+	// a valid announcement, only its publisher differs
+	announcement, err := json.Marshal([]ipfslog.Entry{op.GetEntry()})
+	require.NoError(t, err)
+
+	observer, err := ps.TopicSubscribe(ctx, addr)
+	require.NoError(t, err)
+
+	msgs, err := observer.WatchMessages(ctx)
+	require.NoError(t, err)
+
+	join := func(peerPubSub *p2ppubsub.PubSub) *p2ppubsub.Topic {
+		topic, err := peerPubSub.Join(addr)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			for _, p := range topic.ListPeers() {
+				if p == node.Identity {
+					return true
+				}
+			}
+
+			return false
+		}, time.Second*10, time.Millisecond*50)
+
+		return topic
+	}
+
+	deniedTopic := join(denied.PubSub)
+	allowedTopic := join(allowed.PubSub)
+
+	// the announcement of the denied peer is rejected by the validator
+	require.NoError(t, deniedTopic.Publish(ctx, announcement))
+
+	select {
+	case msg := <-msgs:
+		t.Fatalf("an announcement of a denied peer has been delivered: %v", msg)
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	// while the one of another peer is delivered
+	require.NoError(t, allowedTopic.Publish(ctx, announcement))
+
+	select {
+	case msg := <-msgs:
+		require.Equal(t, allowed.Identity, msg.From)
+	case <-time.After(time.Second * 5):
+		t.Fatal("the announcement of an allowed peer hasn't been delivered")
+	}
+}
+
+func TestReplicationSuspendedStoreTopic(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	network := inmem.NewNetwork()
+	defer network.Close()
+
+	mn := testingMockNet(ctx)
+
+	db, id, dbPath, clean := testInmemNodeWithOptions(t, mn, network, 0, &orbitdb.NewOrbitDBOptions{
+		StoreIdleTimeout:    time.Millisecond * 200,
+		AntiEntropyInterval: time.Millisecond * 50,
+	})
+	defer clean()
+
+	store, err := db.Log(ctx, "suspended-topic-tests", &orbitdb.CreateDBOptions{
+		Directory: &dbPath,
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = store.Close() }()
+
+	op, err := store.Add(ctx, []byte("hello"))
+	require.NoError(t, err)
+
+	suspendable, ok := store.(iface.StoreSuspendInterface)
+	require.True(t, ok)
+
+	// a peer announcing heads on the topic of the store
+	topic, err := network.PubSub(peer.ID("publisher")).TopicSubscribe(ctx, store.Address().String())
+	require.NoError(t, err)
+
+	joined := func() bool {
+		peers, err := topic.Peers(ctx)
+		require.NoError(t, err)
+
+		for _, p := range peers {
+			if p == id {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	require.Eventually(t, joined, time.Second*5, time.Millisecond*50)
+
+	// the suspended store leaves its topic
+	require.Eventually(t, suspendable.Suspended, time.Second*5, time.Millisecond*50)
+	require.False(t, joined())
+
+	// so the announcements of its peers don't resume it
+	announcement, err := json.Marshal([]ipfslog.Entry{op.GetEntry()})
+	require.NoError(t, err)
+	require.NoError(t, topic.Publish(ctx, announcement))
+
+	require.Never(t, func() bool { return !suspendable.Suspended() }, time.Millisecond*500, time.Millisecond*10)
+
+	// the store joins its topic again once used, and leaves it once
+	// suspended again
+	_, err = store.Add(ctx, []byte("world"))
+	require.NoError(t, err)
+	require.True(t, joined())
+
+	require.Eventually(t, suspendable.Suspended, time.Second*5, time.Millisecond*50)
+	require.False(t, joined())
+}
+
 func TestReplicationMultipeer(t *testing.T) {
 	if os.Getenv("WITH_GOLEAK") == "1" {
 		defer goleak.VerifyNone(t,
@@ -437,8 +867,8 @@ func TestLogAppendReplicateEncrypted(t *testing.T) {
 	require.Equal(t, fmt.Sprintf("hello%d", amount-1), string(items[len(items)-1].GetValue()))
 }
 
-func TestLogAppendReplicateEncryptedWrongKey(t *testing.T) {
-	amount := 5
+func TestLogAppendReplicateEncryptedPrivateTopic(t *testing.T) {
+	amount := 2
 	nodeGen := testDefaultNodeGenerator
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*50)
@@ -448,10 +878,7 @@ func TestLogAppendReplicateEncryptedWrongKey(t *testing.T) {
 	dbPaths := make([]string, 2)
 	mn := testingMockNet(ctx)
 
-	sharedKey0, err := enc.NewSecretbox([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 1, 2})
-	require.NoError(t, err)
-
-	sharedKey1, err := enc.NewSecretbox([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 1, 3})
+	sharedKey, err := enc.NewSecretbox([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 1, 2})
 	require.NoError(t, err)
 
 	for i := 0; i < 2; i++ {
@@ -474,10 +901,19 @@ func TestLogAppendReplicateEncryptedWrongKey(t *testing.T) {
 		},
 	}
 
+	// the topic can't be derived without a key
+	_, err = dbs[0].Log(ctx, "replication-tests-no-key", &orbitdb.CreateDBOptions{
+		Directory:        &dbPaths[0],
+		AccessController: access,
+		PrivateTopic:     true,
+	})
+	require.Error(t, err)
+
 	store0, err := dbs[0].Log(ctx, "replication-tests", &orbitdb.CreateDBOptions{
 		Directory:        &dbPaths[0],
 		AccessController: access,
-		SharedKey:        sharedKey0,
+		SharedKey:        sharedKey,
+		PrivateTopic:     true,
 	})
 	require.NoError(t, err)
 
@@ -486,7 +922,8 @@ func TestLogAppendReplicateEncryptedWrongKey(t *testing.T) {
 	store1, err := dbs[1].Log(ctx, store0.Address().String(), &orbitdb.CreateDBOptions{
 		Directory:        &dbPaths[1],
 		AccessController: access,
-		SharedKey:        sharedKey1,
+		SharedKey:        sharedKey,
+		PrivateTopic:     true,
 	})
 	require.NoError(t, err)
 
@@ -506,5 +943,426 @@ func TestLogAppendReplicateEncryptedWrongKey(t *testing.T) {
 	<-time.After(time.Millisecond * 2000)
 	items, err = store1.List(ctx, &orbitdb.StreamOptions{Amount: &infinity})
 	require.NoError(t, err)
-	require.Equal(t, 0, len(items))
+	require.Equal(t, amount, len(items))
+	require.Equal(t, "hello0", string(items[0].GetValue()))
+	require.Equal(t, fmt.Sprintf("hello%d", amount-1), string(items[len(items)-1].GetValue()))
+}
+
+func TestLogAppendReplicateEncryptedWrongKey(t *testing.T) {
+	amount := 5
+	nodeGen := testDefaultNodeGenerator
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*50)
+	defer cancel()
+
+	dbs := make([]orbitdb.OrbitDB, 2)
+	dbPaths := make([]string, 2)
+	mn := testingMockNet(ctx)
+
+	sharedKey0, err := enc.NewSecretbox([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 1, 2})
+	require.NoError(t, err)
+
+	sharedKey1, err := enc.NewSecretbox([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 1, 3})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		dbs[i], dbPaths[i], cancel = nodeGen(t, mn, i)
+		defer cancel()
+	}
+
+	err = mn.LinkAll()
+	require.NoError(t, err)
+
+	err = mn.ConnectAllButSelf()
+	require.NoError(t, err)
+
+	access := &accesscontroller.CreateAccessControllerOptions{
+		Access: map[string][]string{
+			"write": {
+				dbs[0].Identity().ID,
+				dbs[1].Identity().ID,
+			},
+		},
+	}
+
+	store0, err := dbs[0].Log(ctx, "replication-tests", &orbitdb.CreateDBOptions{
+		Directory:        &dbPaths[0],
+		AccessController: access,
+		SharedKey:        sharedKey0,
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = store0.Close() }()
+
+	store1, err := dbs[1].Log(ctx, store0.Address().String(), &orbitdb.CreateDBOptions{
+		Directory:        &dbPaths[1],
+		AccessController: access,
+		SharedKey:        sharedKey1,
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = store1.Close() }()
+
+	infinity := -1
+
+	for i := 0; i < amount; i++ {
+		_, err = store0.Add(ctx, []byte(fmt.Sprintf("hello%d", i)))
+		require.NoError(t, err)
+	}
+
+	items, err := store0.List(ctx, &orbitdb.StreamOptions{Amount: &infinity})
+	require.NoError(t, err)
+	require.Equal(t, amount, len(items))
+
+	<-time.After(time.Millisecond * 2000)
+	items, err = store1.List(ctx, &orbitdb.StreamOptions{Amount: &infinity})
+	require.NoError(t, err)
+	require.Equal(t, 0, len(items))
+}
+
+func TestLogAppendReplicateEncryptedMultipleStores(t *testing.T) {
+	amount := 3
+	nodeGen := testDefaultNodeGenerator
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*50)
+	defer cancel()
+
+	dbs := make([]orbitdb.OrbitDB, 2)
+	dbPaths := make([]string, 2)
+	mn := testingMockNet(ctx)
+
+	sharedKeys := make([]enc.SharedKey, 2)
+	for i := range sharedKeys {
+		var err error
+
+		sharedKeys[i], err = enc.NewSecretbox([]byte{byte(i), 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 1, 2})
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		dbs[i], dbPaths[i], cancel = nodeGen(t, mn, i)
+		defer cancel()
+	}
+
+	err := mn.LinkAll()
+	require.NoError(t, err)
+
+	err = mn.ConnectAllButSelf()
+	require.NoError(t, err)
+
+	access := &accesscontroller.CreateAccessControllerOptions{
+		Access: map[string][]string{
+			"write": {
+				dbs[0].Identity().ID,
+				dbs[1].Identity().ID,
+			},
+		},
+	}
+
+	// both stores are written before the second peer opens them, their heads
+	// go through the same direct channel
+	sources := make([]orbitdb.EventLogStore, len(sharedKeys))
+	for i, sharedKey := range sharedKeys {
+		sources[i], err = dbs[0].Log(ctx, fmt.Sprintf("replication-tests-%d", i), &orbitdb.CreateDBOptions{
+			Directory:        &dbPaths[0],
+			AccessController: access,
+			SharedKey:        sharedKey,
+		})
+		require.NoError(t, err)
+
+		defer func(store orbitdb.EventLogStore) { _ = store.Close() }(sources[i])
+
+		for j := 0; j < amount; j++ {
+			_, err = sources[i].Add(ctx, []byte(fmt.Sprintf("hello%d-%d", i, j)))
+			require.NoError(t, err)
+		}
+	}
+
+	replicas := make([]orbitdb.EventLogStore, len(sharedKeys))
+	for i, sharedKey := range sharedKeys {
+		replicas[i], err = dbs[1].Log(ctx, sources[i].Address().String(), &orbitdb.CreateDBOptions{
+			Directory:        &dbPaths[1],
+			AccessController: access,
+			SharedKey:        sharedKey,
+		})
+		require.NoError(t, err)
+
+		defer func(store orbitdb.EventLogStore) { _ = store.Close() }(replicas[i])
+	}
+
+	<-time.After(time.Millisecond * 2000)
+
+	infinity := -1
+
+	for i, replica := range replicas {
+		items, err := replica.List(ctx, &orbitdb.StreamOptions{Amount: &infinity})
+		require.NoError(t, err)
+		require.Equal(t, amount, len(items))
+		require.Equal(t, fmt.Sprintf("hello%d-0", i), string(items[0].GetValue()))
+	}
+}
+
+func TestReplicationDeniedPeer(t *testing.T) {
+	amount := 5
+	nodeGen := testDefaultNodeGenerator
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*50)
+	defer cancel()
+
+	dbs := make([]orbitdb.OrbitDB, 2)
+	dbPaths := make([]string, 2)
+	mn := testingMockNet(ctx)
+
+	for i := 0; i < 2; i++ {
+		dbs[i], dbPaths[i], cancel = nodeGen(t, mn, i)
+		defer cancel()
+	}
+
+	err := mn.LinkAll()
+	require.NoError(t, err)
+
+	err = mn.ConnectAllButSelf()
+	require.NoError(t, err)
+
+	access := &accesscontroller.CreateAccessControllerOptions{
+		Access: map[string][]string{
+			"write": {
+				dbs[0].Identity().ID,
+				dbs[1].Identity().ID,
+			},
+		},
+	}
+
+	self0, err := dbs[0].IPFS().Key().Self(ctx)
+	require.NoError(t, err)
+
+	store0, err := dbs[0].Log(ctx, "replication-tests", &orbitdb.CreateDBOptions{
+		Directory:        &dbPaths[0],
+		AccessController: access,
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = store0.Close() }()
+
+	store1, err := dbs[1].Log(ctx, store0.Address().String(), &orbitdb.CreateDBOptions{
+		Directory:        &dbPaths[1],
+		AccessController: access,
+		DeniedPeers:      []peer.ID{self0.ID()},
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = store1.Close() }()
+
+	subCtx, subCancel := context.WithCancel(ctx)
+	defer subCancel()
+
+	sub := store1.Subscribe(subCtx)
+
+	infinity := -1
+
+	for i := 0; i < amount; i++ {
+		_, err = store0.Add(ctx, []byte(fmt.Sprintf("hello%d", i)))
+		require.NoError(t, err)
+	}
+
+	// wait for the heads of the written entries to be rejected
+	for rejected := false; !rejected; {
+		select {
+		case evt := <-sub:
+			e, ok := evt.(*orbitstores.EventHeadsRejected)
+			if !ok || e.Count == 0 {
+				continue
+			}
+
+			require.Equal(t, self0.ID(), e.Peer)
+			require.Equal(t, orbitstores.HeadsRejectedPeer, e.Reason)
+
+			rejected = true
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for the heads to be rejected")
+		}
+	}
+
+	items, err := store1.List(ctx, &orbitdb.StreamOptions{Amount: &infinity})
+	require.NoError(t, err)
+	require.Equal(t, 0, len(items))
+}
+
+// recordingReplicator Records the hashes given to the replicator it wraps
+type recordingReplicator struct {
+	replicator.Replicator
+
+	muLoaded sync.Mutex
+	loaded   map[string]struct{}
+}
+
+func (r *recordingReplicator) Load(ctx context.Context, cids []cid.Cid) {
+	r.muLoaded.Lock()
+	for _, c := range cids {
+		r.loaded[c.String()] = struct{}{}
+	}
+	r.muLoaded.Unlock()
+
+	r.Replicator.Load(ctx, cids)
+}
+
+func (r *recordingReplicator) hasLoaded(c cid.Cid) bool {
+	r.muLoaded.Lock()
+	defer r.muLoaded.Unlock()
+
+	_, ok := r.loaded[c.String()]
+	return ok
+}
+
+func TestReplicationReplicatorConstructor(t *testing.T) {
+	amount := 5
+	nodeGen := testDefaultNodeGenerator
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*50)
+	defer cancel()
+
+	dbs := make([]orbitdb.OrbitDB, 2)
+	dbPaths := make([]string, 2)
+	mn := testingMockNet(ctx)
+
+	for i := 0; i < 2; i++ {
+		dbs[i], dbPaths[i], cancel = nodeGen(t, mn, i)
+		defer cancel()
+	}
+
+	err := mn.LinkAll()
+	require.NoError(t, err)
+
+	err = mn.ConnectAllButSelf()
+	require.NoError(t, err)
+
+	access := &accesscontroller.CreateAccessControllerOptions{
+		Access: map[string][]string{
+			"write": {dbs[0].Identity().ID},
+		},
+	}
+
+	store0, err := dbs[0].Log(ctx, "replicator-constructor-tests", &orbitdb.CreateDBOptions{
+		Directory:        &dbPaths[0],
+		AccessController: access,
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = store0.Close() }()
+
+	var recorder *recordingReplicator
+
+	store1, err := dbs[1].Log(ctx, store0.Address().String(), &orbitdb.CreateDBOptions{
+		Directory:        &dbPaths[1],
+		AccessController: access,
+		ReplicatorConstructor: func(ctx context.Context, store replicator.StoreInterface, concurrency uint, opts *replicator.Options) replicator.Replicator {
+			recorder = &recordingReplicator{
+				Replicator: replicator.NewReplicator(ctx, store, concurrency, opts),
+				loaded:     map[string]struct{}{},
+			}
+
+			return recorder
+		},
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = store1.Close() }()
+
+	require.NotNil(t, recorder)
+	require.Equal(t, recorder, store1.Replicator())
+
+	infinity := -1
+
+	for i := 0; i < amount; i++ {
+		_, err = store0.Add(ctx, []byte(fmt.Sprintf("hello%d", i)))
+		require.NoError(t, err)
+	}
+
+	heads := store0.OpLog().Heads().Slice()
+	require.Len(t, heads, 1)
+
+	// the announced head is given to the custom replicator, which replicates
+	// the store
+	require.Eventually(t, func() bool {
+		return recorder.hasLoaded(heads[0].GetHash())
+	}, time.Second*10, time.Millisecond*100)
+
+	require.Eventually(t, func() bool {
+		items, err := store1.List(ctx, &orbitdb.StreamOptions{Amount: &infinity})
+		return err == nil && len(items) == amount
+	}, time.Second*10, time.Millisecond*100)
+}
+
+func TestLogAppendReplicateRecipients(t *testing.T) {
+	amount := 3
+	nodeGen := testDefaultNodeGenerator
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*50)
+	defer cancel()
+
+	dbs := make([]orbitdb.OrbitDB, 3)
+	dbPaths := make([]string, 3)
+	recipientKeys := make([]*keyring.RecipientKey, 3)
+	mn := testingMockNet(ctx)
+
+	for i := 0; i < 3; i++ {
+		dbs[i], dbPaths[i], cancel = nodeGen(t, mn, i)
+		defer cancel()
+
+		var err error
+		recipientKeys[i], err = keyring.GenerateRecipientKey()
+		require.NoError(t, err)
+	}
+
+	err := mn.LinkAll()
+	require.NoError(t, err)
+
+	err = mn.ConnectAllButSelf()
+	require.NoError(t, err)
+
+	// the third peer replicates the store without being a reader
+	access := &accesscontroller.CreateAccessControllerOptions{
+		Access: map[string][]string{
+			"write": {dbs[0].Identity().ID},
+			"read":  {recipientKeys[1].ID()},
+		},
+	}
+
+	stores := make([]orbitdb.EventLogStore, 3)
+	for i := 0; i < 3; i++ {
+		address := "replication-tests"
+		if i > 0 {
+			address = stores[0].Address().String()
+		}
+
+		stores[i], err = dbs[i].Log(ctx, address, &orbitdb.CreateDBOptions{
+			Directory:        &dbPaths[i],
+			AccessController: access,
+			RecipientKey:     recipientKeys[i],
+		})
+		require.NoError(t, err)
+
+		defer func(store orbitdb.EventLogStore) { _ = store.Close() }(stores[i])
+	}
+
+	for i := 0; i < amount; i++ {
+		_, err = stores[0].Add(ctx, []byte(fmt.Sprintf("hello%d", i)))
+		require.NoError(t, err)
+	}
+
+	for _, store := range stores[1:] {
+		store := store
+		require.Eventually(t, func() bool { return store.OpLog().Len() == amount }, time.Second*10, time.Millisecond*100)
+	}
+
+	infinity := -1
+
+	items, err := stores[1].List(ctx, &orbitdb.StreamOptions{Amount: &infinity})
+	require.NoError(t, err)
+	require.Equal(t, amount, len(items))
+	require.Equal(t, "hello0", string(items[0].GetValue()))
+
+	items, err = stores[2].List(ctx, &orbitdb.StreamOptions{Amount: &infinity})
+	require.NoError(t, err)
+	require.Empty(t, items)
 }
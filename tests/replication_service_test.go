@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	orbitdb "berty.tech/go-orbit-db"
+	"berty.tech/go-orbit-db/accesscontroller"
+	"berty.tech/go-orbit-db/pubsub/inmem"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicationService(t *testing.T) {
+	amount := 5
+	nodeGen := testDefaultNodeGenerator
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*50)
+	defer cancel()
+
+	dbs := make([]orbitdb.OrbitDB, 2)
+	dbPaths := make([]string, 2)
+	mn := testingMockNet(ctx)
+
+	for i := 0; i < 2; i++ {
+		dbs[i], dbPaths[i], cancel = nodeGen(t, mn, i)
+		defer cancel()
+	}
+
+	err := mn.LinkAll()
+	require.NoError(t, err)
+
+	err = mn.ConnectAllButSelf()
+	require.NoError(t, err)
+
+	store0, err := dbs[0].Log(ctx, "replication-service-tests", &orbitdb.CreateDBOptions{
+		Directory: &dbPaths[0],
+		AccessController: &accesscontroller.CreateAccessControllerOptions{
+			Access: map[string][]string{
+				"write": {dbs[0].Identity().ID},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = store0.Close() }()
+
+	serviceCtx, serviceCancel := context.WithCancel(ctx)
+	defer serviceCancel()
+
+	err = dbs[1].StartReplicationService(serviceCtx, &orbitdb.ReplicationServiceOptions{
+		Addresses: []string{store0.Address().String()},
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < amount; i++ {
+		_, err = store0.Add(ctx, []byte(fmt.Sprintf("hello%d", i)))
+		require.NoError(t, err)
+	}
+
+	<-time.After(time.Millisecond * 2000)
+
+	// the service already follows the store
+	follower, err := dbs[1].Follow(ctx, store0.Address().String(), nil)
+	require.NoError(t, err)
+	require.Equal(t, "headless", follower.Type())
+	require.Equal(t, amount, follower.OpLog().Len())
+
+	pins, err := dbs[1].IPFS().Pin().Ls(ctx)
+	require.NoError(t, err)
+
+	pinned := map[string]struct{}{}
+	for p := range pins {
+		pinned[p.Path().Cid().String()] = struct{}{}
+	}
+
+	for _, e := range follower.OpLog().GetEntries().Slice() {
+		require.Contains(t, pinned, e.GetHash().String())
+	}
+}
+
+func TestReplicationServiceControlTopic(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	network := inmem.NewNetwork()
+	defer network.Close()
+
+	mn := testingMockNet(ctx)
+
+	dbs := make([]orbitdb.OrbitDB, 2)
+	ids := make([]peer.ID, 2)
+	dbPaths := make([]string, 2)
+
+	for i := 0; i < 2; i++ {
+		var clean func()
+		dbs[i], ids[i], dbPaths[i], clean = testInmemNode(t, mn, network, i)
+		defer clean()
+	}
+
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	store0, err := dbs[0].Log(ctx, "replication-service-tests", &orbitdb.CreateDBOptions{
+		Directory: &dbPaths[0],
+	})
+	require.NoError(t, err)
+
+	defer func() { _ = store0.Close() }()
+
+	addr := store0.Address().String()
+
+	serviceCtx, serviceCancel := context.WithCancel(ctx)
+	defer serviceCancel()
+
+	// the peers allowed to publish on the control topic must be chosen
+	err = dbs[1].StartReplicationService(serviceCtx, &orbitdb.ReplicationServiceOptions{
+		ControlTopic: "control",
+	})
+	require.Error(t, err)
+
+	err = dbs[1].StartReplicationService(serviceCtx, &orbitdb.ReplicationServiceOptions{
+		ControlTopic:      "control",
+		ControlTopicPeers: []peer.ID{ids[0]},
+		DisablePinning:    true,
+	})
+	require.NoError(t, err)
+
+	intruder, err := network.PubSub(peer.ID("intruder")).TopicSubscribe(ctx, "control")
+	require.NoError(t, err)
+
+	require.NoError(t, intruder.Publish(ctx, []byte(addr)))
+
+	<-time.After(time.Millisecond * 500)
+
+	_, ok := dbs[1].GetStore(addr)
+	require.False(t, ok)
+
+	control, err := network.PubSub(ids[0]).TopicSubscribe(ctx, "control")
+	require.NoError(t, err)
+
+	require.NoError(t, control.Publish(ctx, []byte(addr)))
+
+	require.Eventually(t, func() bool {
+		_, ok := dbs[1].GetStore(addr)
+		return ok
+	}, time.Second*10, time.Millisecond*100)
+}
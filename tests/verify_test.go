@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"context"
+	"encoding/base32"
+	"fmt"
+	"testing"
+	"time"
+
+	orbitdb "berty.tech/go-orbit-db"
+	"berty.tech/go-orbit-db/accesscontroller"
+	"berty.tech/go-orbit-db/accesscontroller/simple"
+	"berty.tech/go-orbit-db/stores/basestore"
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	ipfspath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/stretchr/testify/require"
+)
+
+// deniedAccessStore A log store whose access controller doesn't allow its
+// entries
+type deniedAccessStore struct {
+	orbitdb.EventLogStore
+	ac accesscontroller.Interface
+}
+
+func (s *deniedAccessStore) AccessController() accesscontroller.Interface {
+	return s.ac
+}
+
+// blockKey Returns the key of a block in the datastore of an IPFS node
+func blockKey(c cid.Cid) datastore.Key {
+	return datastore.NewKey("/blocks/" + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(c.Hash()))
+}
+
+func TestVerify(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	dbPath, clean := testingTempDir(t, "db-verify")
+	defer clean()
+
+	mocknet := testingMockNet(ctx)
+	node, nodeClean := testingIPFSNode(ctx, t, mocknet)
+	defer nodeClean()
+
+	ipfs := testingCoreAPI(t, node)
+
+	odb, err := orbitdb.NewOrbitDB(ctx, ipfs, &orbitdb.NewOrbitDBOptions{
+		Directory: &dbPath,
+	})
+	require.NoError(t, err)
+
+	defer odb.Close()
+
+	db, err := odb.Log(ctx, "verify database", nil)
+	require.NoError(t, err)
+
+	defer db.Close()
+
+	report, err := basestore.Verify(ctx, db)
+	require.NoError(t, err)
+	require.True(t, report.Valid())
+	require.Equal(t, 0, report.Checked)
+
+	amount := 10
+	for i := 0; i < amount; i++ {
+		_, err := db.Add(ctx, []byte(fmt.Sprintf("hello%d", i)))
+		require.NoError(t, err)
+	}
+
+	report, err = basestore.Verify(ctx, db)
+	require.NoError(t, err)
+	require.True(t, report.Valid())
+	require.Equal(t, amount, report.Checked)
+	require.Empty(t, report.Failures)
+	require.Empty(t, report.Missing)
+
+	// entries written by an identity which is not allowed
+	ac, err := simple.NewSimpleAccessController(ctx, nil, accesscontroller.NewSimpleManifestParams("simple", map[string][]string{
+		"write": {"someone-else"},
+	}))
+	require.NoError(t, err)
+
+	report, err = basestore.Verify(ctx, &deniedAccessStore{EventLogStore: db, ac: ac})
+	require.NoError(t, err)
+	require.False(t, report.Valid())
+	require.Len(t, report.Failures, amount)
+
+	for _, failure := range report.Failures {
+		require.Equal(t, basestore.VerificationNotAllowed, failure.Reason)
+	}
+
+	target := db.OpLog().Values().Slice()[0].GetHash()
+
+	// a block whose content has been altered
+	original, err := node.Repo.Datastore().Get(blockKey(target))
+	require.NoError(t, err)
+	require.NoError(t, node.Repo.Datastore().Put(blockKey(target), []byte("tampered")))
+
+	report, err = basestore.Verify(ctx, db)
+	require.NoError(t, err)
+	require.False(t, report.Valid())
+	require.Len(t, report.Failures, 1)
+	require.True(t, report.Failures[0].Hash.Equals(target))
+	require.Equal(t, basestore.VerificationHashMismatch, report.Failures[0].Reason)
+
+	require.NoError(t, node.Repo.Datastore().Put(blockKey(target), original))
+
+	// a block which is not available anymore
+	require.NoError(t, ipfs.Block().Rm(ctx, ipfspath.IpfsPath(target)))
+
+	report, err = basestore.Verify(ctx, db)
+	require.NoError(t, err)
+	require.False(t, report.Valid())
+	require.Empty(t, report.Failures)
+	require.Len(t, report.Missing, 1)
+	require.True(t, report.Missing[0].Equals(target))
+	require.Equal(t, amount-1, report.Checked)
+}